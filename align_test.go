@@ -0,0 +1,29 @@
+//go:build 386 || arm || mips || mipsle
+
+package lock_test
+
+import (
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+// On 32-bit platforms 64-bit atomic operations fault on words that are
+// not 8-byte aligned, which a leading int32 would misalign unless the
+// lock types align themselves.
+func TestAlign32(t *testing.T) {
+	var s struct {
+		_  int32
+		rw RW
+		_  int32
+		r  ReentrantRW
+		_  int32
+		g  Guarded[int]
+	}
+	s.rw.Lock()
+	s.rw.Downgrade()
+	s.rw.RUnlock()
+	s.r.Lock()
+	s.r.Unlock()
+	s.g.Write(func(v *int) { *v++ })
+}
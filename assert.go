@@ -0,0 +1,29 @@
+package lock
+
+// AssertWriteHeld panics unless rw is write-locked, making a contract
+// such as "must be called with rw locked" checkable in tests. Like
+// IsWriteLocked, it looks at a snapshot: it cannot tell whose write
+// lock it sees, so on its own it only proves something when no other
+// goroutine could be holding rw, as in a single-threaded test. In
+// lockdebug builds, which track the writer, it also panics unless the
+// calling goroutine is the one holding the write lock.
+func (rw *RW) AssertWriteHeld() {
+	if rw.state.Load()&1 == 0 {
+		panic("lock: " + rw.named() + " not write-locked")
+	}
+	if !rw.dbg.owned() {
+		panic("lock: " + rw.named() + " write-locked by another goroutine")
+	}
+}
+
+// AssertReadHeld panics unless rw is read-locked, counting a writer
+// that downgraded. Readers are not tracked, even in lockdebug builds,
+// so it cannot tell whether the read lock it sees is the caller's, and
+// only proves that when no other goroutine could hold one. Readers
+// still waiting for a writer count, as they do for ReaderCount, but
+// cannot call it.
+func (rw *RW) AssertReadHeld() {
+	if readersOf(rw.state.Load()) == 0 {
+		panic("lock: " + rw.named() + " not read-locked")
+	}
+}
@@ -0,0 +1,32 @@
+package lock_test
+
+import (
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestAssertHeld(t *testing.T) {
+	var rw RW
+	if !mustPanic(rw.AssertWriteHeld) || !mustPanic(rw.AssertReadHeld) {
+		t.Fatalf("assertion on an idle lock did not panic")
+	}
+	rw.Lock()
+	rw.AssertWriteHeld()
+	if !mustPanic(rw.AssertReadHeld) {
+		t.Fatalf("AssertReadHeld on a write-locked lock did not panic")
+	}
+	rw.Downgrade()
+	rw.AssertReadHeld()
+	if !mustPanic(rw.AssertWriteHeld) {
+		t.Fatalf("AssertWriteHeld after Downgrade did not panic")
+	}
+	rw.RUnlock()
+	rw.RLock()
+	rw.AssertReadHeld()
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.Fatalf("assertions changed the lock")
+	}
+	rw.Unlock()
+}
@@ -0,0 +1,79 @@
+package lock
+
+import (
+	"sort"
+	"unsafe"
+)
+
+// LockAll locks each of locks for writing. Locks are acquired in
+// order of address rather than argument order, so goroutines that
+// lock overlapping sets with LockAll cannot deadlock on each other
+// however they list them. A lock that appears more than once is
+// locked once. Release the locks with UnlockAll.
+func LockAll(locks ...*RW) {
+	for _, rw := range ordered(locks) {
+		rw.Lock()
+	}
+}
+
+// UnlockAll unlocks each of locks, which must have been locked with
+// LockAll(locks...).
+func UnlockAll(locks ...*RW) {
+	rws := ordered(locks)
+	for i := len(rws) - 1; i >= 0; i-- {
+		rws[i].Unlock()
+	}
+}
+
+// RLockAll locks each of locks for reading, in the same order as
+// LockAll. Release the locks with RUnlockAll.
+func RLockAll(locks ...*RW) {
+	for _, rw := range ordered(locks) {
+		rw.RLock()
+	}
+}
+
+// TryRLockAll tries to lock each of locks for reading without
+// blocking, in the same order as RLockAll, and reports whether it
+// locked them all. If any of them cannot be read-locked at once, as
+// while it is write-locked, the read locks already taken are released
+// and none of locks is left held. Release the locks of a successful
+// call with RUnlockAll.
+func TryRLockAll(locks ...*RW) bool {
+	rws := ordered(locks)
+	for i, rw := range rws {
+		if !rw.TryRLock() {
+			for i--; i >= 0; i-- {
+				rws[i].RUnlock()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// RUnlockAll unlocks each of locks for reading, which must have been
+// locked with RLockAll(locks...).
+func RUnlockAll(locks ...*RW) {
+	rws := ordered(locks)
+	for i := len(rws) - 1; i >= 0; i-- {
+		rws[i].RUnlock()
+	}
+}
+
+// ordered returns the distinct locks in order of address. It sorts a
+// copy, leaving the caller's slice as it was.
+func ordered(locks []*RW) []*RW {
+	c := append([]*RW(nil), locks...)
+	sort.Slice(c, func(i, j int) bool {
+		return uintptr(unsafe.Pointer(c[i])) < uintptr(unsafe.Pointer(c[j]))
+	})
+	n := 0
+	for i, rw := range c {
+		if i == 0 || rw != c[n-1] {
+			c[n] = rw
+			n++
+		}
+	}
+	return c[:n]
+}
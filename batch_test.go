@@ -0,0 +1,94 @@
+package lock_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestLockAll(t *testing.T) {
+	var a, b, c RW
+	locks := []*RW{&c, &a, &b, &a}
+	LockAll(locks...)
+	for i, rw := range []*RW{&a, &b, &c} {
+		if !rw.IsWriteLocked() {
+			t.Fatalf("lock %d not held after LockAll", i)
+		}
+	}
+	if locks[0] != &c || locks[1] != &a || locks[2] != &b || locks[3] != &a {
+		t.Fatalf("LockAll reordered the caller's slice")
+	}
+	UnlockAll(locks...)
+	for i, rw := range []*RW{&a, &b, &c} {
+		if !rw.TryLock() {
+			t.Fatalf("lock %d still held after UnlockAll", i)
+		}
+	}
+}
+
+func TestRLockAll(t *testing.T) {
+	var a, b RW
+	RLockAll(&b, &a, &b)
+	if a.ReaderCount() != 1 || b.ReaderCount() != 1 {
+		t.Fatalf("RLockAll: reader counts %d, %d, want 1, 1", a.ReaderCount(), b.ReaderCount())
+	}
+	RUnlockAll(&b, &a, &b)
+	if !a.TryLock() || !b.TryLock() {
+		t.Fatalf("locks still held after RUnlockAll")
+	}
+}
+
+func TestTryRLockAll(t *testing.T) {
+	var a, b, c RW
+	if !TryRLockAll(&c, &a, &b, &a) {
+		t.Fatalf("TryRLockAll failed on idle locks")
+	}
+	if a.ReaderCount() != 1 || b.ReaderCount() != 1 || c.ReaderCount() != 1 {
+		t.Fatalf("TryRLockAll: reader counts %d, %d, %d, want 1 each", a.ReaderCount(), b.ReaderCount(), c.ReaderCount())
+	}
+	RUnlockAll(&c, &a, &b, &a)
+
+	// With one of the set write-locked, whichever position it sorts
+	// to, the others are all released again.
+	for i, held := range []*RW{&a, &b, &c} {
+		held.Lock()
+		if TryRLockAll(&a, &b, &c) {
+			t.Fatalf("TryRLockAll succeeded with lock %d write-locked", i)
+		}
+		held.Unlock()
+		for j, rw := range []*RW{&a, &b, &c} {
+			if !rw.TryLock() {
+				t.Fatalf("lock %d still held after TryRLockAll failed on lock %d: %v", j, i, rw)
+			}
+			rw.Unlock()
+		}
+	}
+}
+
+func TestLockAllOpposingOrder(t *testing.T) {
+	var a, b, c RW
+	const loops = 10000
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < loops; i++ {
+			LockAll(&a, &b, &c)
+			UnlockAll(&a, &b, &c)
+		}
+		done <- true
+	}()
+	go func() {
+		for i := 0; i < loops; i++ {
+			LockAll(&c, &b, &a)
+			UnlockAll(&c, &b, &a)
+		}
+		done <- true
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("deadlock locking the same set in opposite order")
+		}
+	}
+}
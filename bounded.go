@@ -0,0 +1,35 @@
+package lock
+
+// TryLockN locks rw, making at most spins more attempts after the first
+// fails, and reports whether the lock was acquired. It never reads the
+// clock, for fast paths that should try hard but not block for good.
+// If spins <= 0, it makes a single attempt like TryLock. Unlike that of
+// RLockN, the N here counts attempts, not holders.
+func (rw *RW) TryLockN(spins int) bool {
+	if spins <= 0 {
+		return rw.TryLock()
+	}
+	s := spinner{cfg: rw.cfg, bounded: true, limit: spins}
+	return rw.lock(&s)
+}
+
+// TryRLockN locks rw for reading, making at most spins more attempts
+// after the first fails, and reports whether the read lock was
+// acquired. As with TryRLockTimeout, a failure leaves no trace on rw:
+// the reader it added to rw while waiting has been removed again. If
+// spins <= 0, it makes a single attempt like TryRLock.
+func (rw *RW) TryRLockN(spins int) bool {
+	if spins <= 0 {
+		return rw.TryRLock()
+	}
+	s := spinner{cfg: rw.cfg, bounded: true, limit: spins}
+	return rw.rlock(&s, 1)
+}
+
+// TryUpgradeN is TryUpgrade, under the name of the other bounded
+// acquisitions: the caller's read lock becomes the write lock if the
+// other readers leave within spins attempts, and the caller still holds
+// its read lock if TryUpgradeN returns false.
+func (rw *RW) TryUpgradeN(spins int) bool {
+	return rw.TryUpgrade(spins)
+}
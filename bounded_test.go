@@ -0,0 +1,143 @@
+package lock_test
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+var spinBudgets = []int{-1, 0, 1, 100, 10000}
+
+func TestTryLockN(t *testing.T) {
+	for _, spins := range spinBudgets {
+		var rw RW
+		if !rw.TryLockN(spins) {
+			t.Fatalf("TryLockN(%d) failed on idle lock", spins)
+		}
+		if rw.TryLockN(spins) {
+			t.Fatalf("TryLockN(%d) succeeded on write-locked lock", spins)
+		}
+		rw.Downgrade()
+		if rw.TryLockN(spins) {
+			t.Fatalf("TryLockN(%d) succeeded on read-locked lock", spins)
+		}
+		rw.RUnlock()
+		if st := rw.Stats(); st != (Stats{}) {
+			t.Fatalf("TryLockN(%d) left a trace after failing: %+v", spins, st)
+		}
+	}
+}
+
+func TestTryRLockN(t *testing.T) {
+	for _, spins := range spinBudgets {
+		var rw RW
+		if !rw.TryRLockN(spins) {
+			t.Fatalf("TryRLockN(%d) failed on idle lock", spins)
+		}
+		rw.RUnlock()
+		rw.Lock()
+		if rw.TryRLockN(spins) {
+			t.Fatalf("TryRLockN(%d) succeeded on write-locked lock", spins)
+		}
+		if st := rw.Stats(); st.Readers != 0 {
+			t.Fatalf("TryRLockN(%d) left a reader behind after failing: %+v", spins, st)
+		}
+		rw.Unlock()
+		if !rw.TryLock() {
+			t.Fatalf("TryRLockN(%d) kept writers out after failing", spins)
+		}
+		rw.Unlock()
+	}
+}
+
+func TestTryUpgradeN(t *testing.T) {
+	for _, spins := range spinBudgets {
+		var rw RW
+		rw.RLock()
+		rw.RLock()
+		if rw.TryUpgradeN(spins) {
+			t.Fatalf("TryUpgradeN(%d) succeeded with another reader in", spins)
+		}
+		rw.RUnlock()
+		if !rw.TryUpgradeN(spins) {
+			t.Fatalf("TryUpgradeN(%d) failed as the sole reader", spins)
+		}
+		rw.Unlock()
+	}
+}
+
+// TestTryLockNContended hammers a lock with bounded acquisitions of
+// every kind and budget, checking mutual exclusion and that every
+// failure is clean.
+func TestTryLockNContended(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	for _, spins := range spinBudgets {
+		t.Run(fmt.Sprint(spins), func(t *testing.T) {
+			var (
+				rw               RW
+				writers, readers int32
+				got, failed      int64
+			)
+			const goroutines, loops = 6, 2000
+			done := make(chan bool)
+			for g := 0; g < goroutines; g++ {
+				go func(g int) {
+					defer func() { done <- true }()
+					for i := 0; i < loops; i++ {
+						var ok bool
+						switch (g + i) % 3 {
+						case 0:
+							if ok = rw.TryLockN(spins); ok {
+								if atomic.AddInt32(&writers, 1) != 1 || atomic.LoadInt32(&readers) != 0 {
+									t.Errorf("writer not alone")
+								}
+								runtime.Gosched()
+								atomic.AddInt32(&writers, -1)
+								rw.Unlock()
+							}
+						case 1:
+							if ok = rw.TryRLockN(spins); ok {
+								atomic.AddInt32(&readers, 1)
+								if atomic.LoadInt32(&writers) != 0 {
+									t.Errorf("reader alongside a writer")
+								}
+								runtime.Gosched()
+								atomic.AddInt32(&readers, -1)
+								rw.RUnlock()
+							}
+						default:
+							rw.RLock()
+							if ok = rw.TryUpgradeN(spins); ok {
+								if atomic.AddInt32(&writers, 1) != 1 || atomic.LoadInt32(&readers) != 0 {
+									t.Errorf("upgraded writer not alone")
+								}
+								atomic.AddInt32(&writers, -1)
+								rw.Unlock()
+							} else {
+								rw.RUnlock()
+							}
+						}
+						if ok {
+							atomic.AddInt64(&got, 1)
+						} else {
+							atomic.AddInt64(&failed, 1)
+						}
+					}
+				}(g)
+			}
+			for g := 0; g < goroutines; g++ {
+				<-done
+			}
+			if st := rw.Stats(); st != (Stats{}) {
+				t.Fatalf("lock not idle after the hammer: %+v", st)
+			}
+			if got == 0 {
+				t.Fatalf("no acquisition succeeded")
+			}
+			t.Logf("%d acquired, %d gave up", got, failed)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package lock
+
+// RLockN locks rw for reading on behalf of n readers at once, as if by
+// n calls to RLock but adding all of them to rw in a single step and
+// waiting for a writer at most once. The read locks may be released
+// together by RUnlockN or one at a time by RUnlock. RLockN does nothing
+// if n is 0 and panics if n is negative.
+func (rw *RW) RLockN(n int) {
+	if n < 0 {
+		panic("lock: RLockN with negative count")
+	}
+	if n == 0 {
+		return
+	}
+	s := spinner{cfg: rw.cfg}
+	if !rw.rlock(&s, int64(n)) {
+		rw.panicClosed()
+	}
+}
+
+// RUnlockN releases n read locks of rw at once, as if by n calls to
+// RUnlock. RUnlockN does nothing if n is 0 and panics if n is
+// negative.
+func (rw *RW) RUnlockN(n int) {
+	if n < 0 {
+		panic("lock: RUnlockN with negative count")
+	}
+	if n == 0 {
+		return
+	}
+	rw.runlock(int64(n))
+}
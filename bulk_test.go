@@ -0,0 +1,64 @@
+package lock_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestRLockN(t *testing.T) {
+	var rw RW
+	rw.RLockN(0)
+	rw.RUnlockN(0)
+	if s := rw.Stats(); s != (Stats{}) {
+		t.Fatalf("RLockN(0) changed the lock: %+v", s)
+	}
+	rw.RLockN(3)
+	if n := rw.ReaderCount(); n != 3 {
+		t.Fatalf("RLockN(3): %d readers", n)
+	}
+	rw.RUnlock()
+	rw.RUnlockN(2)
+	if !rw.TryLock() {
+		t.Fatalf("lock held after RUnlockN")
+	}
+	rw.Unlock()
+	if !mustPanic(func() { rw.RLockN(-1) }) {
+		t.Fatalf("RLockN(-1) did not panic")
+	}
+	if !mustPanic(func() { rw.RUnlockN(-1) }) {
+		t.Fatalf("RUnlockN(-1) did not panic")
+	}
+}
+
+func TestRLockNWriter(t *testing.T) {
+	var rw RW
+	var activity int32
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			rw.Lock()
+			if n := atomic.AddInt32(&activity, 10000); n != 10000 {
+				panic("writer not exclusive")
+			}
+			atomic.AddInt32(&activity, -10000)
+			rw.Unlock()
+		}
+		done <- true
+	}()
+	go func() {
+		for i := 0; i < 1000; i++ {
+			n := i%4 + 1
+			rw.RLockN(n)
+			if a := atomic.AddInt32(&activity, int32(n)); a >= 10000 {
+				panic("bulk readers admitted with a writer")
+			}
+			atomic.AddInt32(&activity, -int32(n))
+			rw.RUnlockN(n)
+		}
+		done <- true
+	}()
+	<-done
+	<-done
+}
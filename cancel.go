@@ -0,0 +1,37 @@
+package lock
+
+// LockChan locks rw like LockContext, but gives up once cancel is
+// closed instead of when a context is done. It reports whether the
+// lock was acquired; if not, the caller holds nothing. Like the
+// context, cancel is only checked while spinning, and then only every
+// so often, so an available lock is acquired even if cancel is already
+// closed. A nil cancel never closes, making LockChan a Lock.
+func (rw *RW) LockChan(cancel <-chan struct{}) bool {
+	s := spinner{cfg: rw.cfg, stop: closed(cancel)}
+	return rw.lock(&s)
+}
+
+// RLockChan locks rw for reading like RLockContext, but gives up once
+// cancel is closed. It reports whether the read lock was acquired; if
+// not, the speculative reader added by the attempt has been removed
+// and the caller holds nothing. A nil cancel never closes.
+func (rw *RW) RLockChan(cancel <-chan struct{}) bool {
+	s := spinner{cfg: rw.cfg, stop: closed(cancel)}
+	return rw.rlock(&s, 1)
+}
+
+// closed returns a stop condition for a spinner that reports whether
+// cancel is closed, or nil, letting the waiter block, if it is nil.
+func closed(cancel <-chan struct{}) func() bool {
+	if cancel == nil {
+		return nil
+	}
+	return func() bool {
+		select {
+		case <-cancel:
+			return true
+		default:
+			return false
+		}
+	}
+}
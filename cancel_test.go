@@ -0,0 +1,75 @@
+package lock_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestLockChan(t *testing.T) {
+	var rw RW
+	if !rw.LockChan(nil) {
+		t.Fatalf("LockChan(nil) of an idle lock failed")
+	}
+	cancel := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		done <- rw.LockChan(cancel)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("LockChan returned while the lock was held")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(cancel)
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatalf("LockChan of a held lock succeeded")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("LockChan did not return after cancel was closed")
+	}
+	rw.Unlock()
+	if !rw.LockChan(cancel) {
+		t.Fatalf("LockChan with cancel closed failed on an idle lock")
+	}
+	rw.Unlock()
+}
+
+func TestRLockChan(t *testing.T) {
+	var rw RW
+	if !rw.RLockChan(nil) || !rw.RLockChan(nil) {
+		t.Fatalf("RLockChan of a read-locked lock failed")
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+
+	rw.Lock()
+	cancel := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		done <- rw.RLockChan(cancel)
+	}()
+	for rw.ReaderCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(cancel)
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatalf("RLockChan of a write-locked lock succeeded")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("RLockChan did not return after cancel was closed")
+	}
+	if n := rw.ReaderCount(); n != 0 {
+		t.Fatalf("cancelled RLockChan left %d readers behind", n)
+	}
+	rw.Unlock()
+	if !rw.TryLock() {
+		t.Fatalf("cancelled RLockChan left the lock held")
+	}
+	rw.Unlock()
+}
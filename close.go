@@ -0,0 +1,71 @@
+package lock
+
+import "errors"
+
+// ErrClosed is returned by the acquisitions that return an error, such
+// as LockContext, when the RW has been closed by Close.
+var ErrClosed = errors.New("lock: acquisition of a closed RW")
+
+// closedBit is set in the state of an RW by Close, above every bit the
+// readers could reach.
+const closedBit = 1 << 62
+
+// underflowBit is set in the state of an RW, closed or not, once more
+// read locks are released than were taken: the readers would have to
+// number 1<<60 to set it otherwise.
+const underflowBit = closedBit >> 1
+
+// Close closes rw for good, for a clean end to the life of what it
+// protects, as when a subsystem shuts down: from then on every new
+// acquisition of rw fails at once. The read and write locks held when
+// Close is called stay held until they are released as usual. Waiters
+// fail as well, blocked or spinning, and so do readers that announced
+// themselves to a writer holding rw but have not got in. Acquisitions
+// that report failure, such as TryLock, LockChan or Upgrade, report it;
+// those that return an error, such as LockContext, return ErrClosed;
+// and those that cannot fail, such as Lock and RLock, panic. Closing a
+// closed RW does nothing. Reset reopens rw.
+func (rw *RW) Close() {
+	for {
+		v := rw.state.Load()
+		if v&closedBit != 0 {
+			return
+		}
+		if rw.state.CompareAndSwap(v, v|closedBit) {
+			break
+		}
+	}
+	if raceEnabled {
+		raceDisable()
+	}
+	rw.readerq.wake()
+	rw.writerq.wake()
+	if raceEnabled {
+		raceEnable()
+	}
+}
+
+// IsClosed reports whether rw has been closed by Close.
+func (rw *RW) IsClosed() bool {
+	return rw.state.Load()&closedBit != 0
+}
+
+// closedErr returns ErrClosed if an acquisition of rw failed because
+// rw is closed, and otherwise err, the reason it stopped waiting.
+func (rw *RW) closedErr(err error) error {
+	if rw.IsClosed() {
+		return ErrClosed
+	}
+	return err
+}
+
+// panicClosed panics for an acquisition of rw that cannot fail, made
+// after rw was closed.
+func (rw *RW) panicClosed() {
+	panic("lock: acquisition of closed " + rw.named())
+}
+
+// readersOf returns the number of readers counted in the state v.
+func readersOf(v int64) int64 {
+	return (v &^ closedBit) >> 1
+}
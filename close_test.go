@@ -0,0 +1,122 @@
+package lock_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestClose(t *testing.T) {
+	var rw RW
+	rw.Close()
+	rw.Close() // does nothing
+	if !rw.IsClosed() {
+		t.Fatalf("IsClosed false after Close")
+	}
+	if got := rw.String(); got != "RW{closed}" {
+		t.Fatalf("String() = %q, want %q", got, "RW{closed}")
+	}
+	if b, _ := rw.MarshalText(); string(b) != "closed" {
+		t.Fatalf("MarshalText() = %q, want %q", b, "closed")
+	}
+	if !mustPanic(rw.Lock) || !mustPanic(rw.RLock) || !mustPanic(rw.LockBlocking) || !mustPanic(func() { rw.RLockN(2) }) {
+		t.Fatalf("a blocking acquisition of a closed RW did not panic")
+	}
+	if rw.TryLock() || rw.TryRLock() || rw.TryLockTimeout(time.Millisecond) || rw.TryRLockTimeout(time.Millisecond) {
+		t.Fatalf("a try-lock of a closed RW succeeded")
+	}
+	ctx := context.Background()
+	if err := rw.LockContext(ctx); !errors.Is(err, ErrClosed) {
+		t.Fatalf("LockContext of a closed RW = %v, want ErrClosed", err)
+	}
+	if err := rw.RLockContext(ctx); !errors.Is(err, ErrClosed) {
+		t.Fatalf("RLockContext of a closed RW = %v, want ErrClosed", err)
+	}
+	if err := rw.LockDeadline(time.Now().Add(time.Second)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("LockDeadline of a closed RW = %v, want ErrClosed", err)
+	}
+	if n := rw.ReaderCount(); n != 0 || rw.IsWriteLocked() {
+		t.Fatalf("failed acquisitions left %v behind", &rw)
+	}
+	if !mustPanic(rw.RUnlock) || !mustPanic(rw.Unlock) {
+		t.Fatalf("release of a closed RW not held did not panic")
+	}
+	rw.Reset()
+	if rw.IsClosed() || !rw.TryLock() {
+		t.Fatalf("Reset did not reopen the RW: %v", &rw)
+	}
+	rw.Unlock()
+}
+
+func TestCloseHeld(t *testing.T) {
+	var rw RW
+	rw.RLock()
+	rw.Close()
+	if got := rw.String(); got != "RW{closed, readers: 1}" {
+		t.Fatalf("String() = %q, want %q", got, "RW{closed, readers: 1}")
+	}
+	if rw.Upgrade() {
+		t.Fatalf("Upgrade of a closed RW succeeded")
+	}
+	rw.RUnlock()
+
+	var w RW
+	w.Lock()
+	w.Close()
+	w.Downgrade()
+	if w.ReaderCount() != 1 || w.IsWriteLocked() {
+		t.Fatalf("Downgrade of a closed RW left %v", &w)
+	}
+	w.RUnlock()
+	if got := w.String(); got != "RW{closed}" {
+		t.Fatalf("String() = %q after the holders left, want %q", got, "RW{closed}")
+	}
+}
+
+func TestCloseWaiters(t *testing.T) {
+	var rw RW
+	rw.RLock()
+	writer := make(chan error)
+	go func() { writer <- rw.LockContext(context.Background()) }()
+	for !rw.HasPendingWriters() {
+		runtime.Gosched()
+	}
+	blocked := make(chan bool)
+	go func() { blocked <- mustPanic(rw.LockBlocking) }()
+	time.Sleep(10 * time.Millisecond) // let it park
+	rw.Close()
+	if err := <-writer; !errors.Is(err, ErrClosed) {
+		t.Fatalf("waiting writer got %v from Close, want ErrClosed", err)
+	}
+	if !<-blocked {
+		t.Fatalf("parked Lock of a closed RW did not panic")
+	}
+	rw.RUnlock()
+
+	// Readers waiting for a writer that holds the RW give up too.
+	var w RW
+	w.Lock()
+	reader := make(chan error)
+	go func() { reader <- w.RLockContext(context.Background()) }()
+	for w.ReaderCount() == 0 {
+		runtime.Gosched()
+	}
+	parked := make(chan bool)
+	go func() { parked <- mustPanic(w.RLock) }()
+	time.Sleep(10 * time.Millisecond)
+	w.Close()
+	if err := <-reader; !errors.Is(err, ErrClosed) {
+		t.Fatalf("waiting reader got %v from Close, want ErrClosed", err)
+	}
+	if !<-parked {
+		t.Fatalf("parked RLock of a closed RW did not panic")
+	}
+	w.Unlock()
+	if got := w.String(); got != "RW{closed}" {
+		t.Fatalf("String() = %q after the writer left, want %q", got, "RW{closed}")
+	}
+}
@@ -0,0 +1,85 @@
+package lock
+
+// A mutation is a change to the value of a Guarded given to
+// SubmitMutation, waiting to be applied with the rest of its batch.
+type mutation[T any] struct {
+	fn       func(*T)
+	next     *mutation[T]  // submitted before it, while pending
+	done     chan struct{} // closed once fn has run
+	panicked any           // what fn panicked with, if it did
+}
+
+// SubmitMutation applies fn to the value under the write lock, as Write
+// does, but combines it with the mutations other goroutines submit at
+// the same time, so that many small writes cost one acquisition of the
+// lock rather than one each. The first goroutine to submit while none
+// is pending becomes the leader: it write-locks g and applies every
+// mutation submitted by then, in the order they were submitted, then
+// downgrades, so that readers see the whole batch at once, and lets
+// each of their submitters return. Mutations submitted while it holds
+// the lock wait for the next batch, whose leader is the first of them.
+// Every fn is called exactly once, by whichever goroutine leads its
+// batch, and has been applied and published when SubmitMutation
+// returns. As with Write, fn must not retain the pointer.
+//
+// A batch advances Version once, however many mutations it holds. If
+// fn panics, the value is left as fn left it, the rest of the batch is
+// still applied, and the panic carries on in fn's submitter once the
+// batch is published.
+func (g *Guarded[T]) SubmitMutation(fn func(*T)) {
+	m := &mutation[T]{fn: fn, done: make(chan struct{})}
+	var prev *mutation[T]
+	for {
+		// Once m is pushed, a leader may relink it at any time, so
+		// m.next must not be read back.
+		prev = g.pending.Load()
+		m.next = prev
+		if g.pending.CompareAndSwap(prev, m) {
+			break
+		}
+	}
+	if prev == nil {
+		// No mutation was pending, so no leader is about to take
+		// them: this goroutine leads the next batch.
+		g.combine()
+	}
+	<-m.done
+	if m.panicked != nil {
+		panic(m.panicked)
+	}
+}
+
+// combine applies the pending mutations of g as one batch under the
+// write lock, and releases their submitters.
+func (g *Guarded[T]) combine() {
+	g.rw.Lock()
+	// The pending mutations are stacked newest first.
+	var batch *mutation[T]
+	for m := g.pending.Swap(nil); m != nil; {
+		next := m.next
+		m.next = batch
+		batch, m = m, next
+	}
+	for m := batch; m != nil; m = m.next {
+		m.apply(&g.v)
+	}
+	g.published()
+	g.rw.Downgrade()
+	for m := batch; m != nil; {
+		next := m.next
+		close(m.done)
+		m = next
+	}
+	g.rw.RUnlock()
+}
+
+// apply calls m's function with v, recording a panic rather than
+// letting it stop the batch.
+func (m *mutation[T]) apply(v *T) {
+	defer func() {
+		if p := recover(); p != nil {
+			m.panicked = p
+		}
+	}()
+	m.fn(v)
+}
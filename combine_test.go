@@ -0,0 +1,117 @@
+package lock_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestSubmitMutation(t *testing.T) {
+	const n, each = 16, 200
+	g := NewGuarded(map[int]int{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				key := i*each + j
+				g.SubmitMutation(func(m *map[int]int) { (*m)[key]++ })
+				// Applied and published by the time it returns.
+				var got int
+				g.Read(func(m map[int]int) { got = m[key] })
+				if got != 1 {
+					t.Errorf("mutation %d applied %d times on return", key, got)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	g.Read(func(m map[int]int) {
+		if len(m) != n*each {
+			t.Fatalf("%d mutations applied, want %d", len(m), n*each)
+		}
+		for key, count := range m {
+			if count != 1 {
+				t.Fatalf("mutation %d applied %d times", key, count)
+			}
+		}
+	})
+	if v := g.Version(); v == 0 || v > n*each {
+		t.Fatalf("Version = %d after %d mutations, want one per batch", v, n*each)
+	}
+}
+
+func TestSubmitMutationOrder(t *testing.T) {
+	// A mutation submitted after another returned is applied after it.
+	g := NewGuarded([]int(nil))
+	for i := 0; i < 10; i++ {
+		g.SubmitMutation(func(s *[]int) { *s = append(*s, i) })
+	}
+	g.Read(func(s []int) {
+		if fmt.Sprint(s) != "[0 1 2 3 4 5 6 7 8 9]" {
+			t.Fatalf("mutations applied as %v", s)
+		}
+	})
+}
+
+func TestSubmitMutationPanic(t *testing.T) {
+	g := NewGuarded(0)
+	var wg sync.WaitGroup
+	panics := make(chan bool, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			panics <- mustPanic(func() {
+				g.SubmitMutation(func(v *int) {
+					*v++
+					if i%2 == 0 {
+						panic("mutation")
+					}
+				})
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(panics)
+	var n int
+	for p := range panics {
+		if p {
+			n++
+		}
+	}
+	if n != 4 {
+		t.Fatalf("%d submitters panicked, want the 4 whose mutation did", n)
+	}
+	var got int
+	g.Read(func(v int) { got = v })
+	if got != 8 {
+		t.Fatalf("value %d after 8 mutations, half of which panicked after writing", got)
+	}
+}
+
+// BenchmarkSubmitMutation compares SubmitMutation with taking the write
+// lock once per mutation, with every goroutine writing.
+func BenchmarkSubmitMutation(b *testing.B) {
+	for _, bm := range []struct {
+		name  string
+		write func(g *Guarded[int], fn func(*int))
+	}{
+		{"Write", (*Guarded[int]).Write},
+		{"SubmitMutation", (*Guarded[int]).SubmitMutation},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			g := NewGuarded(0)
+			b.SetParallelism(8)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					bm.write(g, func(v *int) { *v++ })
+				}
+			})
+		})
+	}
+}
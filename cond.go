@@ -0,0 +1,88 @@
+package lock
+
+// Cond is a condition variable for state protected by an RW, like
+// sync.Cond but with a wait for either half of the lock: Wait is
+// called with L write-locked and RWait with L read-locked. Both
+// release that half of L while they wait, reacquire it before they
+// return, and, as with sync.Cond, should be called in a loop that
+// checks the condition.
+//
+// Waiting goroutines are parked rather than left to spin, since a
+// condition may take arbitrarily long to come about.
+//
+// A Cond must be created with NewCond and must not be copied after
+// first use.
+type Cond struct {
+	noCopy noCopy
+
+	// L is held, for writing or reading, while observing or changing
+	// the condition.
+	L *RW
+
+	mu      RW       // guards waiters
+	waiters []*waitq // semaphores of parked waiters, oldest first
+}
+
+// NewCond returns a new Cond with lock l.
+func NewCond(l *RW) *Cond {
+	return &Cond{L: l}
+}
+
+// Wait unlocks c.L for writing, suspends the calling goroutine until
+// woken by Signal or Broadcast, and locks c.L for writing again.
+func (c *Cond) Wait() {
+	q := c.enqueue()
+	c.L.Unlock()
+	q.sleep()
+	c.L.Lock()
+}
+
+// RWait unlocks c.L for reading, suspends the calling goroutine until
+// woken by Signal or Broadcast, and locks c.L for reading again.
+func (c *Cond) RWait() {
+	q := c.enqueue()
+	c.L.RUnlock()
+	q.sleep()
+	c.L.RLock()
+}
+
+// enqueue adds a waiter to c before it releases c.L, so that a Signal
+// or Broadcast after the release cannot miss it.
+func (c *Cond) enqueue() *waitq {
+	q := new(waitq)
+	c.mu.Lock()
+	c.waiters = append(c.waiters, q)
+	c.mu.Unlock()
+	return q
+}
+
+// Signal wakes the goroutine that has waited on c the longest, if
+// there is one. Waiters are queued in the order they called Wait or
+// RWait and each Signal takes exactly one of them off the queue, so
+// that, as in a work queue, a Signal per unit of work wakes one
+// consumer per unit rather than all of them. The caller may, but need
+// not, hold c.L.
+func (c *Cond) Signal() {
+	c.mu.Lock()
+	if len(c.waiters) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	q := c.waiters[0]
+	c.waiters[0] = nil
+	c.waiters = c.waiters[1:]
+	c.mu.Unlock()
+	q.post()
+}
+
+// Broadcast wakes all goroutines waiting on c. The caller may, but
+// need not, hold c.L.
+func (c *Cond) Broadcast() {
+	c.mu.Lock()
+	waiters := c.waiters
+	c.waiters = nil
+	c.mu.Unlock()
+	for _, q := range waiters {
+		q.post()
+	}
+}
@@ -0,0 +1,148 @@
+package lock_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestCondBoundedBuffer(t *testing.T) {
+	var (
+		rw       RW
+		notFull  = NewCond(&rw)
+		notEmpty = NewCond(&rw)
+		buf      []int
+	)
+	const size, producers, items = 4, 3, 1000
+	for p := 0; p < producers; p++ {
+		go func() {
+			for i := 0; i < items; i++ {
+				rw.Lock()
+				for len(buf) == size {
+					notFull.Wait()
+				}
+				buf = append(buf, 1)
+				rw.Unlock()
+				notEmpty.Signal()
+			}
+		}()
+	}
+	done := make(chan int)
+	go func() {
+		sum := 0
+		for i := 0; i < producers*items; i++ {
+			rw.Lock()
+			for len(buf) == 0 {
+				notEmpty.Wait()
+			}
+			sum += buf[0]
+			buf = buf[1:]
+			rw.Unlock()
+			notFull.Signal()
+		}
+		done <- sum
+	}()
+	select {
+	case sum := <-done:
+		if sum != producers*items {
+			t.Fatalf("consumed %d items, want %d", sum, producers*items)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("bounded buffer deadlocked")
+	}
+}
+
+func TestCondBroadcast(t *testing.T) {
+	var rw RW
+	c := NewCond(&rw)
+	ready := false
+	const readers = 5
+	var waiting, woken sync.WaitGroup
+	waiting.Add(readers)
+	woken.Add(readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			rw.RLock()
+			waiting.Done()
+			for !ready {
+				c.RWait()
+			}
+			rw.RUnlock()
+			woken.Done()
+		}()
+	}
+	waiting.Wait()
+	rw.Lock()
+	ready = true
+	rw.Unlock()
+	c.Broadcast()
+	done := make(chan bool)
+	go func() {
+		woken.Wait()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Broadcast did not wake every reader")
+	}
+}
+
+// TestCondSignal checks that each Signal wakes one waiter, the one
+// that has waited longest, and never more.
+func TestCondSignal(t *testing.T) {
+	var rw RW
+	c := NewCond(&rw)
+	const waiters, signals = 6, 4
+	woken := make(chan int, waiters)
+	waiting := 0
+	for i := 0; i < waiters; i++ {
+		go func(i int) {
+			rw.Lock()
+			waiting++
+			c.Wait()
+			rw.Unlock()
+			woken <- i
+		}(i)
+		// Wait for it to queue before starting the next one,
+		// so that waiters queue in order of i.
+		for {
+			rw.Lock()
+			n := waiting
+			rw.Unlock()
+			if n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	seen := make(map[int]bool)
+	for s := 0; s < signals; s++ {
+		c.Signal()
+		select {
+		case i := <-woken:
+			if seen[i] {
+				t.Fatalf("waiter %d woken twice", i)
+			}
+			seen[i] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Signal %d woke no waiter", s)
+		}
+	}
+	select {
+	case i := <-woken:
+		t.Fatalf("waiter %d woken without a Signal", i)
+	case <-time.After(20 * time.Millisecond):
+	}
+	for i := 0; i < signals; i++ {
+		if !seen[i] {
+			t.Fatalf("waiters woken %v, want the %d oldest", seen, signals)
+		}
+	}
+	c.Broadcast()
+	for i := signals; i < waiters; i++ {
+		<-woken
+	}
+}
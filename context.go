@@ -0,0 +1,44 @@
+package lock
+
+import "context"
+
+// LockContext locks rw, spinning until the lock is available or ctx
+// is done. It returns nil if the lock was acquired, or ctx.Err() if ctx
+// was done first, in which case the caller holds nothing. The context
+// is only consulted while spinning, so an available lock is acquired
+// even if ctx is already done.
+func (rw *RW) LockContext(ctx context.Context) error {
+	s := spinner{cfg: rw.cfg, stop: func() bool { return ctx.Err() != nil }}
+	if !rw.lock(&s) {
+		return rw.closedErr(ctx.Err())
+	}
+	return nil
+}
+
+// RLockContext locks rw for reading, spinning while a writer holds
+// rw until the writer releases or ctx is done. It returns nil if the
+// read lock was acquired, or ctx.Err() if ctx was done first, in which
+// case the speculative reader added by the attempt has been removed
+// and the caller holds nothing.
+func (rw *RW) RLockContext(ctx context.Context) error {
+	s := spinner{cfg: rw.cfg, stop: func() bool { return ctx.Err() != nil }}
+	if !rw.rlock(&s, 1) {
+		return rw.closedErr(ctx.Err())
+	}
+	return nil
+}
+
+// UpgradeContext upgrades the caller's read lock to the write lock
+// like Upgrade, spinning while other readers hold rw until they leave
+// or ctx is done. It returns nil if the caller now holds the write
+// lock, or ctx.Err() if ctx was done first, in which case the caller
+// still holds its read lock. As with LockContext, an upgrade that is
+// possible at once succeeds even if ctx is already done. The warning
+// on Upgrade about readers waiting on each other's upgrade applies.
+func (rw *RW) UpgradeContext(ctx context.Context) error {
+	s := spinner{cfg: rw.cfg, stop: func() bool { return ctx.Err() != nil }}
+	if !rw.upgrade(&s) {
+		return rw.closedErr(ctx.Err())
+	}
+	return nil
+}
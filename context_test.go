@@ -0,0 +1,105 @@
+package lock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestLockContext(t *testing.T) {
+	var rw RW
+	if err := rw.LockContext(context.Background()); err != nil {
+		t.Fatalf("LockContext on idle lock: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rw.LockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("LockContext on held lock: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	rw.Unlock()
+	if !rw.TryLock() {
+		t.Fatalf("cancelled LockContext left the lock held")
+	}
+	rw.Unlock()
+}
+
+func TestLockContextCancel(t *testing.T) {
+	var rw RW
+	rw.RLock()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- rw.LockContext(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("LockContext did not return after cancel")
+	}
+	rw.RUnlock()
+}
+
+func TestRLockContext(t *testing.T) {
+	var rw RW
+	if err := rw.RLockContext(context.Background()); err != nil {
+		t.Fatalf("RLockContext on idle lock: %v", err)
+	}
+	if err := rw.RLockContext(context.Background()); err != nil {
+		t.Fatalf("RLockContext on read-locked lock: %v", err)
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+
+	rw.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rw.RLockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("RLockContext on write-locked lock: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := rw.ReaderCount(); n != 0 {
+		t.Fatalf("cancelled RLockContext left %d readers behind", n)
+	}
+	rw.Unlock()
+	if !rw.TryLock() {
+		t.Fatalf("cancelled RLockContext left a reader behind")
+	}
+	rw.Unlock()
+}
+
+func TestUpgradeContext(t *testing.T) {
+	var rw RW
+	rw.RLock()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := rw.UpgradeContext(cancelled); err != nil {
+		t.Fatalf("UpgradeContext as sole reader: %v", err)
+	}
+	if !rw.IsWriteLocked() {
+		t.Fatalf("UpgradeContext succeeded without the write lock")
+	}
+	rw.Downgrade()
+
+	rw.RLock()
+	if err := rw.UpgradeContext(cancelled); err != context.Canceled {
+		t.Fatalf("UpgradeContext with a co-reader and cancelled context: got %v, want %v", err, context.Canceled)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rw.UpgradeContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("UpgradeContext with a co-reader: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := rw.ReaderCount(); n != 2 {
+		t.Fatalf("failed UpgradeContext: %d readers, want 2", n)
+	}
+	rw.RUnlock()
+	if err := rw.UpgradeContext(context.Background()); err != nil {
+		t.Fatalf("UpgradeContext after co-reader left: %v", err)
+	}
+	rw.Unlock()
+}
@@ -0,0 +1,53 @@
+package lock
+
+import "sync/atomic"
+
+// Counters are lifetime counts of the acquisitions of an RW, as
+// returned by RW.Counters.
+type Counters struct {
+	Writes    uint64 // write locks acquired, upgrades included
+	Reads     uint64 // read locks acquired, n for each RLockN(n)
+	Contended uint64 // acquisitions of either kind that had to wait
+}
+
+// acquireCounts accumulates the Counters of an RW made WithCounters.
+type acquireCounts struct {
+	writes    atomic.Uint64
+	reads     atomic.Uint64
+	contended atomic.Uint64
+}
+
+// Counters returns the number of times rw was acquired in each mode,
+// and how many of those acquisitions waited for it, if rw was made
+// WithCounters. For any other RW it returns the zero Counters. The
+// three are read one after another, not as of a single instant, so
+// under concurrent use they need not add up exactly; rates over time
+// taken from them do.
+func (rw *RW) Counters() Counters {
+	if rw.cfg == nil || rw.cfg.counts == nil {
+		return Counters{}
+	}
+	c := rw.cfg.counts
+	return Counters{
+		Writes:    c.writes.Load(),
+		Reads:     c.reads.Load(),
+		Contended: c.contended.Load(),
+	}
+}
+
+// counted adds n acquisitions of rw in mode m to its Counters, if it
+// keeps them, and one contended acquisition if the caller waited.
+func (rw *RW) counted(m Mode, n int64, waited bool) {
+	if rw.cfg == nil || rw.cfg.counts == nil {
+		return
+	}
+	c := rw.cfg.counts
+	if m == Write {
+		c.writes.Add(uint64(n))
+	} else {
+		c.reads.Add(uint64(n))
+	}
+	if waited {
+		c.contended.Add(1)
+	}
+}
@@ -0,0 +1,105 @@
+package lock_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestCounters(t *testing.T) {
+	rw := New(WithCounters())
+	for i := 0; i < 5; i++ {
+		rw.Lock()
+		rw.Unlock()
+	}
+	for i := 0; i < 3; i++ {
+		rw.RLock()
+		rw.RUnlock()
+	}
+	if !rw.TryLock() {
+		t.Fatalf("TryLock failed on an idle RW")
+	}
+	rw.Downgrade() // not an acquisition
+	rw.RUnlock()
+	if !rw.TryRLock() {
+		t.Fatalf("TryRLock failed on an idle RW")
+	}
+	if !rw.Upgrade() {
+		t.Fatalf("Upgrade of the only reader failed")
+	}
+	rw.Unlock()
+	rw.RLockN(4)
+	rw.RUnlockN(4)
+	if !rw.TryLock() {
+		t.Fatalf("TryLock failed on an idle RW")
+	}
+	if rw.TryRLock() { // a failed try is not counted
+		t.Fatalf("TryRLock succeeded on a write-locked RW")
+	}
+	rw.Unlock()
+	want := Counters{Writes: 5 + 1 + 1 + 1, Reads: 3 + 1 + 4}
+	if got := rw.Counters(); got != want {
+		t.Fatalf("Counters = %+v, want %+v", got, want)
+	}
+
+	// A writer and a reader that wait for the lock are contended.
+	rw.Lock()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); rw.Lock(); rw.Unlock() }()
+	go func() { defer wg.Done(); rw.RLock(); rw.RUnlock() }()
+	for rw.Stats().WaitingWriters == 0 || rw.Stats().Readers == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	rw.Unlock()
+	wg.Wait()
+	want.Writes += 2
+	want.Reads++
+	want.Contended = 2
+	if got := rw.Counters(); got != want {
+		t.Fatalf("Counters = %+v after two waits, want %+v", got, want)
+	}
+}
+
+func TestCountersConcurrent(t *testing.T) {
+	const n, loops = 8, 1000
+	rw := New(WithCounters())
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < loops; j++ {
+				if j%4 == 0 {
+					rw.Lock()
+					rw.Unlock()
+				} else {
+					rw.RLock()
+					rw.RUnlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	got := rw.Counters()
+	if got.Writes != n*loops/4 || got.Reads != n*loops*3/4 {
+		t.Fatalf("Counters = %+v after %d writes and %d reads", got, n*loops/4, n*loops*3/4)
+	}
+	if got.Contended > got.Writes+got.Reads {
+		t.Fatalf("Counters = %+v, more contended than acquisitions", got)
+	}
+}
+
+func TestCountersOff(t *testing.T) {
+	for _, rw := range []*RW{new(RW), New()} {
+		rw.Lock()
+		rw.Unlock()
+		rw.RLock()
+		rw.RUnlock()
+		if got := rw.Counters(); got != (Counters{}) {
+			t.Fatalf("Counters = %+v without WithCounters, want zero", got)
+		}
+	}
+}
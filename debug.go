@@ -0,0 +1,321 @@
+//go:build lockdebug
+
+package lock
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const debug = true
+
+// debugState is the bookkeeping of an RW for the checks of lockdebug
+// builds. Without the tag it is empty and its methods do nothing.
+type debugState struct {
+	// owner is the goroutine id of the writer, or, negated, of the
+	// writer that downgraded last, for as long as it may hold the read
+	// lock it downgraded to, or 0. It is a single word, so that it
+	// leaves the RW of lockdebug builds within a cache line.
+	owner atomic.Int64
+}
+
+// The dbg field comes first in an RW, so that a debugState can name
+// the RW it belongs to.
+var _ [0]struct{} = [unsafe.Offsetof(RW{}.dbg)]struct{}{}
+
+// rw returns the RW that d is the bookkeeping of.
+func (d *debugState) rw() *RW {
+	return (*RW)(unsafe.Pointer(d))
+}
+
+// String identifies the RW of d, by its name and address, in panics.
+func (d *debugState) String() string {
+	return fmt.Sprintf("%s %p", d.rw().named(), d.rw())
+}
+
+// locked records the calling goroutine as the writer.
+func (d *debugState) locked() {
+	d.owner.Store(goid())
+}
+
+// checkReentry panics if the calling goroutine already holds the write
+// lock it is about to wait for without end, which would never be
+// granted.
+func (d *debugState) checkReentry() {
+	if d.owner.Load() == goid() {
+		panic("lock: reentrant write lock of " + d.rw().named() + " already write-locked by the calling goroutine")
+	}
+}
+
+// unlocked checks that the calling goroutine is the writer as it
+// gives up the write lock by Unlock or Downgrade.
+func (d *debugState) unlocked() {
+	d.checkOwner()
+	d.owner.Store(0)
+}
+
+// downgraded records that the calling goroutine, the writer until
+// now, downgraded to a read lock.
+func (d *debugState) downgraded() {
+	d.owner.Store(-goid())
+}
+
+// rUnlocked records that the calling goroutine released a read lock,
+// which is the one it downgraded to if it downgraded last.
+func (d *debugState) rUnlocked() {
+	d.owner.CompareAndSwap(-goid(), 0)
+}
+
+// checkDowngraded panics, saying what to call instead, if the calling
+// goroutine is trying to Unlock the read lock it downgraded to.
+func (d *debugState) checkDowngraded() {
+	if d.owner.Load() == -goid() {
+		panic("lock: Unlock of " + d.rw().named() + " after Downgrade: the calling goroutine holds a read lock now, to be released with RUnlock")
+	}
+}
+
+// owned reports whether the calling goroutine is the writer.
+func (d *debugState) owned() bool {
+	return d.owner.Load() == goid()
+}
+
+// checkOwner panics unless the calling goroutine is the writer.
+func (d *debugState) checkOwner() {
+	if d.owner.Load() != goid() {
+		panic("lock: Unlock of " + d.rw().named() + " write-locked by another goroutine")
+	}
+}
+
+// order is the lock-order registry of lockdebug builds.
+var order lockOrder
+
+// A lockOrder tracks the RWs each goroutine holds and, for every RW
+// acquired while another was held, an edge from the held one to it. A
+// blocking acquisition that would close a cycle of edges could deadlock
+// with goroutines that acquired the same RWs in the order of the cycle,
+// however rarely their timing lines up, so it panics instead. Edges are
+// never forgotten, which keeps every RW that has been in one reachable.
+//
+// The registry is off in race builds: the race detector would take its
+// mutex for synchronization between the goroutines locking RWs, and
+// miss the races on the data they protect.
+type lockOrder struct {
+	mu    sync.Mutex
+	held  map[int64][]*debugState                // by goroutine, oldest first
+	edges map[*debugState]map[*debugState]string // to the site that added it
+}
+
+// checkOrder records that the calling goroutine is about to block
+// acquiring d while holding the RWs it holds, and panics if one of
+// them has been acquired while holding d, directly or through others.
+// Acquisitions that cannot block, such as TryLock, skip the check.
+func (d *debugState) checkOrder() {
+	if raceEnabled {
+		return
+	}
+	id := goid()
+	order.mu.Lock()
+	defer order.mu.Unlock()
+	site := ""
+	for _, h := range order.held[id] {
+		if h == d || order.edges[h][d] != "" {
+			continue
+		}
+		if site == "" {
+			site = callSite()
+		}
+		if path := order.path(d, h); path != nil {
+			var b strings.Builder
+			fmt.Fprintf(&b, "lock: lock order inversion: %v acquired at %s while holding %v, which was acquired after it:", d, site, h)
+			for i := 0; i+1 < len(path); i++ {
+				fmt.Fprintf(&b, "\n\t%v acquired at %s while holding %v", path[i+1], order.edges[path[i]][path[i+1]], path[i])
+			}
+			panic(b.String())
+		}
+		if order.edges == nil {
+			order.edges = make(map[*debugState]map[*debugState]string)
+		}
+		if order.edges[h] == nil {
+			order.edges[h] = make(map[*debugState]string)
+		}
+		order.edges[h][d] = site
+	}
+}
+
+// hold records that the calling goroutine acquired d n times.
+func (d *debugState) hold(n int) {
+	if raceEnabled {
+		return
+	}
+	id := goid()
+	order.mu.Lock()
+	defer order.mu.Unlock()
+	if order.held == nil {
+		order.held = make(map[int64][]*debugState)
+	}
+	for i := 0; i < n; i++ {
+		order.held[id] = append(order.held[id], d)
+	}
+}
+
+// drop records that d was released n times, by the calling goroutine
+// if it holds d and otherwise on behalf of whichever goroutine does, as
+// when a read lock is released by another goroutine than its reader.
+func (d *debugState) drop(n int) {
+	if raceEnabled {
+		return
+	}
+	id := goid()
+	order.mu.Lock()
+	defer order.mu.Unlock()
+	for ; n > 0; n-- {
+		if order.release(id, d) {
+			continue
+		}
+		for other := range order.held {
+			if order.release(other, d) {
+				break
+			}
+		}
+	}
+}
+
+// transfer records that the calling goroutine took over a read lock
+// of d from another goroutine that holds one, for TransferRead.
+func (d *debugState) transfer() {
+	if raceEnabled {
+		return
+	}
+	id := goid()
+	order.mu.Lock()
+	defer order.mu.Unlock()
+	for other := range order.held {
+		if other != id && order.release(other, d) {
+			order.held[id] = append(order.held[id], d)
+			return
+		}
+	}
+	panic(fmt.Sprintf("lock: TransferRead of %v, which no other goroutine holds", d))
+}
+
+// release removes the latest acquisition of d from those goroutine id
+// holds, and reports whether there was one.
+func (o *lockOrder) release(id int64, d *debugState) bool {
+	held := o.held[id]
+	for i := len(held) - 1; i >= 0; i-- {
+		if held[i] == d {
+			held = append(held[:i], held[i+1:]...)
+			if len(held) == 0 {
+				delete(o.held, id)
+			} else {
+				o.held[id] = held
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// path returns the RWs along a chain of edges from a to b, both
+// included, or nil if there is none.
+func (o *lockOrder) path(a, b *debugState) []*debugState {
+	from := map[*debugState]*debugState{a: nil}
+	queue := []*debugState{a}
+	for len(queue) > 0 {
+		x := queue[0]
+		queue = queue[1:]
+		if x == b {
+			var path []*debugState
+			for ; x != nil; x = from[x] {
+				path = append([]*debugState{x}, path...)
+			}
+			return path
+		}
+		for y := range o.edges[x] {
+			if _, seen := from[y]; !seen {
+				from[y] = x
+				queue = append(queue, y)
+			}
+		}
+	}
+	return nil
+}
+
+// callSite returns the file and line of the first caller outside this
+// package, where the application acquires the lock.
+func callSite() string {
+	pc := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, pkgPrefix) || !more {
+			return fmt.Sprintf("%s:%d", f.File, f.Line)
+		}
+	}
+}
+
+// pkgPrefix prefixes the names of this package's functions.
+var pkgPrefix = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name() // path/to/lock.init.func1
+	i := strings.LastIndex(name, "/") + 1
+	return name[:i+strings.Index(name[i:], ".")+1]
+}()
+
+// registry records every RW made by New for DumpAll. It holds their
+// addresses as integers, which the garbage collector does not follow,
+// and a finalizer deletes an RW's entry before its memory is freed, so
+// that an address in the registry always points to a live RW.
+var registry struct {
+	sync.Mutex
+	last uint64
+	ids  map[uintptr]uint64
+}
+
+// register adds rw to the registry, and sets the finalizer that takes
+// it out. An object has one finalizer slot, so in lockdebug builds a
+// caller's runtime.SetFinalizer on an RW made by New fails, as it
+// does on any object that already has one, with a fatal error that
+// cannot be recovered.
+func register(rw *RW) {
+	registry.Lock()
+	if registry.ids == nil {
+		registry.ids = make(map[uintptr]uint64)
+	}
+	registry.last++
+	registry.ids[uintptr(unsafe.Pointer(rw))] = registry.last
+	registry.Unlock()
+	runtime.SetFinalizer(rw, unregister)
+}
+
+// unregister removes rw from the registry once it is unreachable.
+func unregister(rw *RW) {
+	registry.Lock()
+	delete(registry.ids, uintptr(unsafe.Pointer(rw)))
+	registry.Unlock()
+}
+
+func dumpAll() []LockState {
+	registry.Lock()
+	defer registry.Unlock()
+	states := make([]LockState, 0, len(registry.ids))
+	for addr, id := range registry.ids {
+		// Reading addr back as a pointer, rather than converting
+		// it, keeps vet's unsafeptr check quiet. It is safe only
+		// because of the finalizer: the collector does not move
+		// objects, and keeps one with a finalizer, and all it
+		// points to, until its finalizer has run, which waits for
+		// the lock held here to delete addr first. So every addr
+		// seen under the lock is a live RW, and rw only points to
+		// it until the lock is released.
+		rw := *(**RW)(unsafe.Pointer(&addr))
+		states = append(states, LockState{ID: id, Name: rw.Name(), State: rw.String(), Stats: rw.Stats()})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+	return states
+}
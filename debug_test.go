@@ -0,0 +1,221 @@
+//go:build lockdebug
+
+package lock_test
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestDebugDowngradeUnlocked(t *testing.T) {
+	var rw RW
+	if !mustPanic(rw.Downgrade) {
+		t.Fatalf("Downgrade of an idle RW did not panic")
+	}
+	rw.RLock()
+	if !mustPanic(rw.Downgrade) {
+		t.Fatalf("Downgrade of a read-locked RW did not panic")
+	}
+	if rw.IsWriteLocked() || rw.ReaderCount() != 1 {
+		t.Fatalf("failed Downgrade changed the lock: %v", &rw)
+	}
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.Fatalf("RW corrupted by the failed Downgrades")
+	}
+	rw.Unlock()
+}
+
+func TestDebugForeignUnlock(t *testing.T) {
+	var rw RW
+	rw.Lock()
+	panicked := make(chan bool)
+	go func() {
+		panicked <- mustPanic(rw.Unlock)
+	}()
+	if !<-panicked {
+		t.Fatalf("Unlock by a goroutine other than the writer did not panic")
+	}
+	go func() {
+		panicked <- mustPanic(rw.Downgrade)
+	}()
+	if !<-panicked {
+		t.Fatalf("Downgrade by a goroutine other than the writer did not panic")
+	}
+	go func() {
+		panicked <- mustPanic(func() { rw.TryDowngrade() })
+	}()
+	if !<-panicked {
+		t.Fatalf("TryDowngrade by a goroutine other than the writer did not panic")
+	}
+	if st := rw.Stats(); !st.WriteHeld || st.Readers != 0 {
+		t.Fatalf("foreign TryDowngrade changed the lock: %+v", st)
+	}
+	rw.Unlock()
+}
+
+func TestDebugReentrantLock(t *testing.T) {
+	var rw RW
+	rw.Lock()
+	if !mustPanic(rw.Lock) {
+		t.Fatalf("Lock by the goroutine holding the write lock did not panic")
+	}
+	rw.Unlock()
+	if !rw.TryLock() {
+		t.Fatalf("RW corrupted by the failed Lock")
+	}
+	// Another goroutine locking is not reentry, nor is Lock after
+	// Downgrade, which gave up the write lock.
+	rw.Downgrade()
+	done := make(chan bool)
+	go func() {
+		rw.Lock()
+		rw.Unlock()
+		done <- true
+	}()
+	rw.RUnlock()
+	<-done
+	rw.Lock()
+	rw.Unlock()
+}
+
+func TestDebugUnlockAfterDowngrade(t *testing.T) {
+	unlockMessage := func(rw *RW) (msg string) {
+		defer func() { msg = fmt.Sprint(recover()) }()
+		rw.Unlock()
+		return ""
+	}
+	var rw RW
+	for name, downgrade := range map[string]func(){
+		"Downgrade":    rw.Downgrade,
+		"TryDowngrade": func() { rw.TryDowngrade() },
+	} {
+		rw.Lock()
+		downgrade()
+		if msg := unlockMessage(&rw); !strings.Contains(msg, "after Downgrade") || !strings.Contains(msg, "RUnlock") {
+			t.Fatalf("Unlock after %s panicked with %q, want guidance to RUnlock", name, msg)
+		}
+		if rw.IsWriteLocked() || rw.ReaderCount() != 1 {
+			t.Fatalf("mis-paired Unlock after %s changed the lock: %v", name, &rw)
+		}
+		rw.RUnlock()
+		// Released properly, the read lock is no longer the
+		// caller's, and a stray Unlock is just of an unlocked RW.
+		if msg := unlockMessage(&rw); !strings.Contains(msg, "Unlock of unlocked") {
+			t.Fatalf("Unlock of an idle RW after %s panicked with %q", name, msg)
+		}
+	}
+
+	// A read lock released by the goroutine it was handed to leaves
+	// its downgrader a stale mark, which the next writer clears.
+	rw.Lock()
+	rw.Downgrade()
+	done := make(chan bool)
+	go func() {
+		rw.RUnlock()
+		rw.Lock()
+		rw.Unlock()
+		done <- true
+	}()
+	<-done
+	if msg := unlockMessage(&rw); !strings.Contains(msg, "Unlock of unlocked") {
+		t.Fatalf("Unlock of an idle RW panicked with %q", msg)
+	}
+}
+
+func TestDebugAssertWriteHeld(t *testing.T) {
+	var rw RW
+	rw.Lock()
+	panicked := make(chan bool)
+	go func() {
+		panicked <- mustPanic(rw.AssertWriteHeld)
+	}()
+	if !<-panicked {
+		t.Fatalf("AssertWriteHeld by a goroutine other than the writer did not panic")
+	}
+	rw.AssertWriteHeld()
+	rw.Unlock()
+}
+
+func TestDebugMaxReaders(t *testing.T) {
+	rw := New(WithMaxReaders(3))
+	rw.RLock()
+	rw.RLockN(2)
+	if !mustPanic(rw.RLock) {
+		t.Fatalf("RLock over the maximum readers did not panic")
+	}
+	if !mustPanic(func() { rw.TryRLock() }) {
+		t.Fatalf("TryRLock over the maximum readers did not panic")
+	}
+	if n := rw.ReaderCount(); n != 3 {
+		t.Fatalf("readers over the maximum left in: %d readers, want 3", n)
+	}
+	rw.RUnlockN(3)
+	if !mustPanic(func() { rw.RLockN(4) }) {
+		t.Fatalf("RLockN over the maximum readers did not panic")
+	}
+	rw.RLockN(3)
+	rw.RUnlockN(3)
+	if !rw.TryLock() {
+		t.Fatalf("lock not idle after readers under the maximum left")
+	}
+	rw.Unlock()
+}
+
+func TestDebugDoWriteTrackedMisreport(t *testing.T) {
+	var rw RW
+	if !mustPanic(func() { rw.DoWriteTracked(func() bool { return true }) }) {
+		t.Fatalf("DoWriteTracked accepted a downgrade fn did not make")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("DoWriteTracked left rw held after the misreport")
+	}
+	rw.Unlock()
+}
+
+func TestDebugDumpAll(t *testing.T) {
+	find := func(id uint64) *LockState {
+		for _, st := range DumpAll() {
+			if st.ID == id {
+				return &st
+			}
+		}
+		return nil
+	}
+	a, b := New(), New(WithWriterPreference(), WithName("b"))
+	all := DumpAll() // the newest RWs come last
+	if len(all) < 2 {
+		t.Fatalf("DumpAll listed %d RWs after New made two", len(all))
+	}
+	idA, idB := all[len(all)-2].ID, all[len(all)-1].ID
+	if idB <= idA {
+		t.Fatalf("DumpAll not in the order the RWs were made: ids %d then %d", idA, idB)
+	}
+	// They are not held together, which would record their order and
+	// keep them reachable from the order checks.
+	a.Lock()
+	if st := find(idA); st == nil || st.State != "RW{write-locked}" || !st.Stats.WriteHeld {
+		t.Fatalf("DumpAll listed the first new RW as %+v, want it write-locked", st)
+	}
+	a.Unlock()
+	b.RLock()
+	b.RLock()
+	if st := find(idB); st == nil || st.Name != "b" || st.State != `RW("b"){readers: 2}` || st.Stats.Readers != 2 {
+		t.Fatalf("DumpAll listed the second new RW as %+v, want two readers", st)
+	}
+	b.RUnlockN(2)
+
+	// Now that a and b are unreachable, they drop out of the registry.
+	for i := 0; i < 10 && (find(idA) != nil || find(idB) != nil); i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	if find(idA) != nil || find(idB) != nil {
+		t.Fatalf("DumpAll still lists RWs that are no longer reachable")
+	}
+}
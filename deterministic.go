@@ -0,0 +1,126 @@
+package lock
+
+import "sync"
+
+// A Scheduler decides when a goroutine waiting for a Deterministic lock
+// tries again. A simulation that runs one goroutine at a time switches
+// to another in Yield, in an order it controls, so that every run with
+// the same choices takes and releases its locks in the same order.
+type Scheduler interface {
+	// Yield is called by a goroutine that failed to acquire the lock
+	// and returns when it should try again. It must let the holder
+	// run, or the goroutine never gets the lock.
+	Yield()
+}
+
+// Deterministic is a Locker for deterministic simulation tests. It
+// never spins, parks or reads the clock: a goroutine that cannot get
+// the lock calls its Scheduler's Yield and tries again when that
+// returns, so which goroutine gets the lock when is up to the
+// scheduler alone. Its state is guarded by a sync.Mutex that is only
+// held for a few instructions, and never contended when the scheduler
+// runs one goroutine at a time.
+//
+// Like RW, it admits any number of readers or one writer, a writer may
+// Downgrade, and a sole reader may Upgrade; Unlock and RUnlock panic if
+// it is not held that way. It has no notion of preference: waiting
+// readers and writers are let in as the scheduler has them try.
+type Deterministic struct {
+	sched Scheduler
+
+	mu      sync.Mutex
+	readers int
+	writer  bool
+}
+
+var _ Locker = (*Deterministic)(nil)
+
+// NewDeterministic returns an unlocked Deterministic whose waiters
+// yield to s.
+func NewDeterministic(s Scheduler) *Deterministic {
+	return &Deterministic{sched: s}
+}
+
+// Lock locks d for writing, yielding until it is free.
+func (d *Deterministic) Lock() {
+	for !d.TryLock() {
+		d.sched.Yield()
+	}
+}
+
+// TryLock locks d for writing if it is free and reports whether it
+// did.
+func (d *Deterministic) TryLock() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.writer || d.readers != 0 {
+		return false
+	}
+	d.writer = true
+	return true
+}
+
+// Unlock unlocks d for writing.
+func (d *Deterministic) Unlock() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.writer {
+		panic("lock: Unlock of unlocked Deterministic")
+	}
+	d.writer = false
+}
+
+// RLock locks d for reading, yielding while it is write-locked.
+func (d *Deterministic) RLock() {
+	for !d.TryRLock() {
+		d.sched.Yield()
+	}
+}
+
+// TryRLock locks d for reading if it is not write-locked and reports
+// whether it did.
+func (d *Deterministic) TryRLock() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.writer {
+		return false
+	}
+	d.readers++
+	return true
+}
+
+// RUnlock unlocks d for reading.
+func (d *Deterministic) RUnlock() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.readers == 0 {
+		panic("lock: RUnlock of unlocked Deterministic")
+	}
+	d.readers--
+}
+
+// Downgrade turns the caller's write lock into a read lock, with no
+// other writer able to get in between.
+func (d *Deterministic) Downgrade() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.writer {
+		panic("lock: Downgrade of Deterministic not write-locked")
+	}
+	d.writer = false
+	d.readers++
+}
+
+// Upgrade turns the caller's read lock into the write lock if it is
+// the only reader, and reports whether it did. On failure the caller
+// still holds its read lock.
+func (d *Deterministic) Upgrade() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.readers != 1 {
+		return false
+	}
+	d.readers = 0
+	d.writer = true
+	return true
+}
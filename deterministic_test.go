@@ -0,0 +1,126 @@
+package lock_test
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+// sim is a Scheduler that runs tasks one at a time, switching only
+// when one yields or returns, to the next runnable task drawn from a
+// seeded source, so that a seed fixes the whole interleaving.
+type sim struct {
+	rng   *rand.Rand
+	tasks []chan bool // each task waits on its own to run
+	cur   int
+	back  chan bool // true when the running task returned
+}
+
+func newSim(seed int64) *sim {
+	return &sim{rng: rand.New(rand.NewSource(seed)), back: make(chan bool)}
+}
+
+// Go adds fn as a task, to start once Run is called.
+func (s *sim) Go(fn func()) {
+	run := make(chan bool)
+	s.tasks = append(s.tasks, run)
+	go func() {
+		<-run
+		fn()
+		s.back <- true
+	}()
+}
+
+// Run runs the tasks until all of them have returned.
+func (s *sim) Run() {
+	live := make([]int, len(s.tasks))
+	for i := range live {
+		live[i] = i
+	}
+	for len(live) > 0 {
+		k := s.rng.Intn(len(live))
+		s.cur = live[k]
+		s.tasks[s.cur] <- true
+		if <-s.back {
+			live = append(live[:k], live[k+1:]...)
+		}
+	}
+}
+
+func (s *sim) Yield() {
+	run := s.tasks[s.cur]
+	s.back <- false
+	<-run
+}
+
+// simulate runs two writers and two readers of a Deterministic lock
+// under a sim seeded with seed, checking exclusion, and returns the
+// order in which they held the lock.
+func simulate(t *testing.T, seed int64) []string {
+	s := newSim(seed)
+	d := NewDeterministic(s)
+	var trace []string
+	readers, writing := 0, false
+	for w := 0; w < 2; w++ {
+		w := w
+		s.Go(func() {
+			for i := 0; i < 3; i++ {
+				d.Lock()
+				if writing || readers != 0 {
+					t.Errorf("writer %d in with writer %v and %d readers", w, writing, readers)
+				}
+				writing = true
+				trace = append(trace, fmt.Sprint("w", w))
+				s.Yield() // let the others find the lock held
+				writing = false
+				if i == 1 {
+					d.Downgrade()
+					readers++
+					s.Yield()
+					readers--
+					d.RUnlock()
+				} else {
+					d.Unlock()
+				}
+			}
+		})
+	}
+	for r := 0; r < 2; r++ {
+		r := r
+		s.Go(func() {
+			for i := 0; i < 3; i++ {
+				d.RLock()
+				if writing {
+					t.Errorf("reader %d in with a writer", r)
+				}
+				readers++
+				trace = append(trace, fmt.Sprint("r", r))
+				s.Yield()
+				readers--
+				d.RUnlock()
+			}
+		})
+	}
+	s.Run()
+	return trace
+}
+
+func TestDeterministic(t *testing.T) {
+	distinct := map[string]bool{}
+	for seed := int64(1); seed <= 20; seed++ {
+		trace := simulate(t, seed)
+		if len(trace) != 12 {
+			t.Fatalf("seed %d: %d acquisitions, want 12: %v", seed, len(trace), trace)
+		}
+		if again := simulate(t, seed); !reflect.DeepEqual(again, trace) {
+			t.Fatalf("seed %d: runs differ:\n%v\n%v", seed, trace, again)
+		}
+		distinct[fmt.Sprint(trace)] = true
+	}
+	if len(distinct) < 2 {
+		t.Fatalf("every seed gave the same interleaving")
+	}
+}
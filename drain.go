@@ -0,0 +1,52 @@
+package lock
+
+import (
+	"time"
+	"unsafe"
+)
+
+// WaitForReaders blocks until rw has no readers, for quiescence before
+// freeing what readers might still be using. It waits for readers only:
+// a writer holding rw does not delay it, but readers waiting for that
+// writer count as readers, as does a writer that downgraded. The caller
+// must therefore hold neither half of rw, and new readers may arrive
+// as soon as it returns unless something else keeps them out.
+func (rw *RW) WaitForReaders() {
+	s := spinner{cfg: rw.cfg}
+	rw.waitForReaders(&s)
+}
+
+// WaitForReadersTimeout is like WaitForReaders, but gives up after d
+// and reports whether rw had no readers by then. If d <= 0, it checks
+// once.
+func (rw *RW) WaitForReadersTimeout(d time.Duration) bool {
+	s := spinner{cfg: rw.cfg, bounded: true}
+	if d > 0 {
+		deadline := time.Now().Add(d)
+		s = spinner{cfg: rw.cfg, stop: func() bool { return !time.Now().Before(deadline) }}
+	}
+	return rw.waitForReaders(&s)
+}
+
+// waitForReaders waits until rw has no readers, pacing checks with s,
+// and reports whether it did before s gave up.
+func (rw *RW) waitForReaders(s *spinner) bool {
+	if raceEnabled {
+		raceDisable()
+	}
+	ok := true
+	for readersOf(rw.state.Load()) != 0 {
+		if !s.spin() {
+			ok = false
+			break
+		}
+	}
+	if raceEnabled {
+		raceEnable()
+		if ok {
+			// Everything the readers did happens before the wait ends.
+			raceAcquire(unsafe.Pointer(&rw.cfg))
+		}
+	}
+	return ok
+}
@@ -0,0 +1,62 @@
+package lock_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestWaitForReaders(t *testing.T) {
+	var rw RW
+	rw.WaitForReaders()
+	rw.RLock()
+	rw.RLock()
+	var released int32
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt32(&released, 1)
+		rw.RUnlock()
+		rw.RUnlock()
+	}()
+	rw.WaitForReaders()
+	if atomic.LoadInt32(&released) == 0 {
+		t.Fatalf("WaitForReaders returned while readers held rw")
+	}
+}
+
+func TestWaitForReadersWriter(t *testing.T) {
+	var rw RW
+	rw.Lock()
+	if !rw.WaitForReadersTimeout(time.Second) {
+		t.Fatalf("WaitForReadersTimeout waited for a writer")
+	}
+	rw.Downgrade()
+	if rw.WaitForReadersTimeout(0) {
+		t.Fatalf("WaitForReadersTimeout ignored a downgraded writer")
+	}
+	rw.RUnlock()
+}
+
+func TestWaitForReadersTimeout(t *testing.T) {
+	var rw RW
+	if !rw.WaitForReadersTimeout(0) {
+		t.Fatalf("WaitForReadersTimeout(0) on idle lock: got false")
+	}
+	rw.RLock()
+	start := time.Now()
+	if rw.WaitForReadersTimeout(10 * time.Millisecond) {
+		t.Fatalf("WaitForReadersTimeout while read-locked: got true")
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatalf("WaitForReadersTimeout gave up early")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rw.RUnlock()
+	}()
+	if !rw.WaitForReadersTimeout(5 * time.Second) {
+		t.Fatalf("WaitForReadersTimeout missed the last reader leaving")
+	}
+}
@@ -0,0 +1,2 @@
+// This file allows the bodyless function declarations in noportable.go,
+// which are implemented by the runtime.
@@ -0,0 +1,106 @@
+package lock
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// epochs counts the readers inside an RW that entered it with
+// RLockEpoch, by the generation they entered in. The generation
+// advances from g to g+1 only once every reader of generation g-1 has
+// left, so at most two generations are ever inside and the parity of a
+// generation picks its counter.
+type epochs struct {
+	gen     atomic.Uint64
+	readers [2]atomic.Int32
+}
+
+// ReaderEpoch returns rw's current reader epoch, for WaitForEpoch.
+func (rw *RW) ReaderEpoch() uint64 {
+	return rw.epochs.gen.Load()
+}
+
+// RLockEpoch locks rw for reading, like RLock, and registers the
+// reader in the current epoch, which it returns. The reader must
+// release rw with RUnlockEpoch, passing it that epoch.
+func (rw *RW) RLockEpoch() (epoch uint64) {
+	rw.RLock()
+	if raceEnabled {
+		raceDisable()
+	}
+	epoch = rw.epochs.enter()
+	if raceEnabled {
+		raceEnable()
+	}
+	return epoch
+}
+
+// RUnlockEpoch unlocks rw for a reader that locked it with
+// RLockEpoch, which returned epoch.
+func (rw *RW) RUnlockEpoch(epoch uint64) {
+	if raceEnabled {
+		raceReleaseMerge(unsafe.Pointer(&rw.epochs))
+		raceDisable()
+	}
+	n := rw.epochs.readers[epoch&1].Add(-1)
+	if raceEnabled {
+		raceEnable()
+	}
+	if debug && n < 0 {
+		rw.epochs.readers[epoch&1].Add(1)
+		panic("lock: RUnlockEpoch with an epoch no reader entered in")
+	}
+	rw.RUnlock()
+}
+
+// WaitForEpoch blocks until every reader that entered rw with
+// RLockEpoch in epoch or earlier has left it with RUnlockEpoch. Since
+// the epoch never decreases, this includes every such reader that
+// held rw when ReaderEpoch returned epoch: to reclaim memory that
+// readers reach through rw, unpublish it, note e := rw.ReaderEpoch(),
+// and free it once WaitForEpoch(e) returns.
+//
+// Unlike WaitForReaders, WaitForEpoch does not wait for readers that
+// entered in a later epoch, which it moves on from as soon as the
+// readers before them allow. New readers are not held back, so it
+// returns even while rw is never free of readers. Readers that lock rw
+// with RLock are not counted. The caller must not itself be a reader
+// of epoch or an earlier one, which would wait for itself.
+func (rw *RW) WaitForEpoch(epoch uint64) {
+	s := spinner{cfg: rw.cfg}
+	if raceEnabled {
+		raceDisable()
+	}
+	for {
+		g := rw.epochs.gen.Load()
+		if g > epoch+1 {
+			// Moving on to g required every reader of g-2 to leave.
+			break
+		}
+		if rw.epochs.readers[(g+1)&1].Load() == 0 {
+			rw.epochs.gen.CompareAndSwap(g, g+1)
+			continue
+		}
+		s.spin()
+	}
+	if raceEnabled {
+		raceEnable()
+		// Everything the readers did happens before the wait ends.
+		raceAcquire(unsafe.Pointer(&rw.epochs))
+	}
+}
+
+// enter registers a reader in the current generation and returns it.
+// A reader that counts itself in a generation which has since moved
+// on leaves again and retries, so it cannot slip into a counter that
+// WaitForEpoch has already seen drained.
+func (e *epochs) enter() uint64 {
+	for {
+		g := e.gen.Load()
+		e.readers[g&1].Add(1)
+		if e.gen.Load() == g {
+			return g
+		}
+		e.readers[g&1].Add(-1)
+	}
+}
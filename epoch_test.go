@@ -0,0 +1,108 @@
+package lock_test
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestWaitForEpoch(t *testing.T) {
+	var rw RW
+	r1 := rw.RLockEpoch()
+	r2 := rw.RLockEpoch()
+	if rw.TryLock() {
+		t.Fatalf("TryLock succeeded with epoch readers inside")
+	}
+	e := rw.ReaderEpoch()
+	done := make(chan bool)
+	go func() {
+		rw.WaitForEpoch(e)
+		done <- true
+	}()
+
+	// The waiter moves on to the next epoch at once, since nothing
+	// from before e is inside, so a reader arriving now is a later one.
+	deadline := time.Now().Add(5 * time.Second)
+	for rw.ReaderEpoch() == e {
+		if time.Now().After(deadline) {
+			t.Fatalf("WaitForEpoch did not advance the epoch")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	r3 := rw.RLockEpoch()
+	if r3 == e {
+		t.Fatalf("reader entered in epoch %d it was waited for", e)
+	}
+
+	rw.RUnlockEpoch(r1)
+	select {
+	case <-done:
+		t.Fatalf("WaitForEpoch returned with a reader of epoch %d inside", r2)
+	case <-time.After(10 * time.Millisecond):
+	}
+	rw.RUnlockEpoch(r2)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("WaitForEpoch waited for a reader of later epoch %d", r3)
+	}
+
+	go func() {
+		rw.WaitForEpoch(rw.ReaderEpoch())
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatalf("WaitForEpoch returned with a reader of epoch %d inside", r3)
+	case <-time.After(10 * time.Millisecond):
+	}
+	rw.RUnlockEpoch(r3)
+	<-done
+	if !rw.TryLock() {
+		t.Fatalf("rw still held after RUnlockEpoch")
+	}
+}
+
+// TestWaitForEpochReclaim frees objects that readers reach through rw
+// while readers keep arriving, checking that no reader ever sees one
+// freed and that reclamation never waits for rw to empty.
+func TestWaitForEpochReclaim(t *testing.T) {
+	type object struct{ freed atomic.Bool }
+	var (
+		rw   RW
+		cur  atomic.Pointer[object]
+		stop atomic.Bool
+	)
+	cur.Store(new(object))
+	const readers = 4
+	done := make(chan bool)
+	for r := 0; r < readers; r++ {
+		go func() {
+			for !stop.Load() {
+				e := rw.RLockEpoch()
+				o := cur.Load()
+				for i := 0; i < 10; i++ {
+					runtime.Gosched()
+					if o.freed.Load() {
+						t.Errorf("reader of epoch %d used a freed object", e)
+					}
+				}
+				rw.RUnlockEpoch(e)
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		old := cur.Swap(new(object))
+		runtime.Gosched()
+		rw.WaitForEpoch(rw.ReaderEpoch())
+		old.freed.Store(true)
+	}
+	stop.Store(true)
+	for r := 0; r < readers; r++ {
+		<-done
+	}
+}
@@ -0,0 +1,33 @@
+package lock_test
+
+import (
+	"expvar"
+	"fmt"
+
+	"github.com/as/lock"
+)
+
+func ExampleRW_IsWriteLocked() {
+	var rw lock.RW
+	rw.Lock()
+	fmt.Println(rw.IsWriteLocked())
+	rw.Downgrade()
+	fmt.Println(rw.IsWriteLocked())
+	rw.RUnlock()
+	// Output:
+	// true
+	// false
+}
+
+func ExampleRW_Stats() {
+	var rw lock.RW
+	expvar.Publish("lock", expvar.Func(func() any { return rw.Stats() }))
+
+	rw.RLock()
+	rw.RLock()
+	fmt.Println(expvar.Get("lock"))
+	rw.RUnlock()
+	rw.RUnlock()
+	// Output:
+	// {"Readers":2,"WriteHeld":false,"WaitingWriters":0}
+}
@@ -0,0 +1,62 @@
+package lock
+
+import "sync/atomic"
+
+// FairRW is a read/write spinlock that grants the lock in the order it
+// was requested. Each Lock or RLock draws a ticket and waits for its
+// turn, so a writer is never overtaken by a later reader or writer.
+// Readers whose tickets are consecutive hold the lock together, but a
+// reader arriving after a waiting writer waits for that writer.
+//
+// Compared to RW, FairRW trades throughput for predictable latency:
+// every acquisition is a contended increment, and a waiter that is
+// descheduled holds up everyone behind it. Like RW, a writer may
+// Downgrade to a reader.
+//
+// The zero value is an unlocked FairRW. A FairRW must not be copied
+// after first use.
+type FairRW struct {
+	noCopy noCopy
+	next   uint32 // next ticket to hand out
+	read   uint32 // tickets before read have been admitted
+	write  uint32 // tickets before write have released
+}
+
+// Lock locks f for writing once all earlier tickets have released.
+func (f *FairRW) Lock() {
+	t := atomic.AddUint32(&f.next, 1) - 1
+	var s spinner
+	for atomic.LoadUint32(&f.write) != t {
+		s.spin()
+	}
+}
+
+// Unlock unlocks f for writing, admitting the next ticket.
+func (f *FairRW) Unlock() {
+	atomic.AddUint32(&f.read, 1)
+	atomic.AddUint32(&f.write, 1)
+}
+
+// RLock locks f for reading once all earlier tickets have been
+// admitted and no earlier writer holds f.
+func (f *FairRW) RLock() {
+	t := atomic.AddUint32(&f.next, 1) - 1
+	var s spinner
+	for atomic.LoadUint32(&f.read) != t {
+		s.spin()
+	}
+	// Let the next reader in alongside this one.
+	atomic.AddUint32(&f.read, 1)
+}
+
+// RUnlock unlocks f for reading.
+func (f *FairRW) RUnlock() {
+	atomic.AddUint32(&f.write, 1)
+}
+
+// Downgrade transitions f from a write-locked state to a read-locked
+// state, admitting any readers queued directly behind it. The caller
+// must hold the write lock, and releases the read lock with RUnlock.
+func (f *FairRW) Downgrade() {
+	atomic.AddUint32(&f.read, 1)
+}
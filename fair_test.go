@@ -0,0 +1,104 @@
+package lock_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestFairRWOrder(t *testing.T) {
+	const n = 8
+	var (
+		f     FairRW
+		order = make(chan int, n)
+	)
+	f.Lock()
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			f.Lock()
+			order <- i
+			f.Unlock()
+		}(i)
+		// Let writer i draw its ticket before the next arrives.
+		time.Sleep(time.Millisecond)
+	}
+	f.Unlock()
+	for i := 0; i < n; i++ {
+		if got := <-order; got != i {
+			t.Fatalf("writer %d acquired in position %d", got, i)
+		}
+	}
+}
+
+func TestFairRWReaders(t *testing.T) {
+	var (
+		f      FairRW
+		active int32
+		peak   int32
+		done   = make(chan bool)
+	)
+	const n = 4
+	f.Lock()
+	for i := 0; i < n; i++ {
+		go func() {
+			f.RLock()
+			a := atomic.AddInt32(&active, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if a <= p || atomic.CompareAndSwapInt32(&peak, p, a) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&active, -1)
+			f.RUnlock()
+			done <- true
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	f.Downgrade()
+	for i := 0; i < n; i++ {
+		<-done
+	}
+	f.RUnlock()
+	if peak != n {
+		t.Fatalf("at most %d queued readers held the lock together, want %d", peak, n)
+	}
+	f.Lock()
+	f.Unlock()
+}
+
+func TestFairRWHammer(t *testing.T) {
+	var (
+		f        FairRW
+		activity int32
+		done     = make(chan bool)
+	)
+	for i := 0; i < 10; i++ {
+		go func(write bool) {
+			for j := 0; j < 500; j++ {
+				if write {
+					f.Lock()
+					if n := atomic.AddInt32(&activity, 10000); n != 10000 {
+						panic("writer not exclusive")
+					}
+					atomic.AddInt32(&activity, -10000)
+					f.Unlock()
+				} else {
+					f.RLock()
+					if n := atomic.AddInt32(&activity, 1); n < 1 || n >= 10000 {
+						panic("reader overlapped a writer")
+					}
+					atomic.AddInt32(&activity, -1)
+					f.RUnlock()
+				}
+			}
+			done <- true
+		}(i%3 == 0)
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+}
@@ -0,0 +1,40 @@
+package lock
+
+import (
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const futexSupported = true
+
+// Private futex operations, for words not shared with other processes.
+const (
+	futexWaitPrivate = 0 | 128
+	futexWakePrivate = 1 | 128
+)
+
+// futexAcquire takes a token from the semaphore counted by *addr,
+// sleeping in the kernel while there is none. The thread sleeps with
+// the goroutine, and the runtime starts another to run the rest.
+func futexAcquire(addr *uint32) {
+	for {
+		v := atomic.LoadUint32(addr)
+		if v == 0 {
+			// Returns at once if *addr is no longer 0, and may wake
+			// spuriously, so either way check again.
+			syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWaitPrivate, 0, 0, 0, 0)
+			continue
+		}
+		if atomic.CompareAndSwapUint32(addr, v, v-1) {
+			return
+		}
+	}
+}
+
+// futexRelease posts a token on the semaphore counted by *addr and
+// wakes a thread sleeping on it.
+func futexRelease(addr *uint32) {
+	atomic.AddUint32(addr, 1)
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWakePrivate, 1, 0, 0, 0)
+}
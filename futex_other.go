@@ -0,0 +1,11 @@
+//go:build !linux
+
+package lock
+
+// There is no futex outside Linux, and WithFutex has no effect there,
+// so these are never called.
+
+const futexSupported = false
+
+func futexAcquire(addr *uint32) { panic("lock: futex outside Linux") }
+func futexRelease(addr *uint32) { panic("lock: futex outside Linux") }
@@ -1,3 +1,3 @@
 module github.com/as/lock
 
-go 1.18
+go 1.19
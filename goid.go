@@ -0,0 +1,26 @@
+package lock
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+// goid returns the id of the calling goroutine. The runtime does not
+// expose it, so it is parsed from the header of the goroutine's stack
+// trace ("goroutine 42 [running]:"), which costs on the order of a
+// microsecond. It is only used where goroutine identity is part of
+// the contract and never on the paths of a plain RW.
+func goid() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		panic("lock: cannot parse goroutine id: " + err.Error())
+	}
+	return id
+}
@@ -0,0 +1,357 @@
+package lock
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// Guarded holds a value of type T that is only reachable through
+// its lock. Read passes a copy of the value to a closure under the
+// read lock, and Write passes a pointer to it under the write lock.
+// Both release the lock even if the closure panics.
+//
+// The zero value is an idle Guarded holding the zero T. A Guarded
+// must not be copied after first use.
+type Guarded[T any] struct {
+	rw   RW
+	v    T
+	next atomic.Pointer[Cond] // readers waiting in ReadNext, made on first use
+
+	// stale is a copy of v as of the last write, for ReadOrStale, or
+	// nil until the first ReadOrStale. Writers replace it under the
+	// write lock once it is set.
+	stale atomic.Pointer[T]
+
+	// observed is closed by the first read to end after a
+	// DowngradePublishAwait publishes, or is nil when none waits.
+	observed atomic.Pointer[chan struct{}]
+
+	// pending is the stack of mutations given to SubmitMutation and
+	// not yet taken by the leader of their batch, newest first.
+	pending atomic.Pointer[mutation[T]]
+}
+
+// NewGuarded returns a Guarded holding v.
+func NewGuarded[T any](v T) *Guarded[T] {
+	return &Guarded[T]{v: v}
+}
+
+// Read calls fn with a copy of the value under the read lock.
+// Note that a copy of T may still share memory with the guarded
+// value (through pointers, slices or maps), which fn must not
+// modify.
+func (g *Guarded[T]) Read(fn func(T)) {
+	g.rw.RLock()
+	defer g.rUnlock()
+	fn(g.v)
+}
+
+// rUnlock releases a read lock of g taken by a reader of the value,
+// first telling a waiting DowngradePublishAwait that the value it
+// published was read.
+func (g *Guarded[T]) rUnlock() {
+	if c := g.observed.Load(); c != nil && g.observed.CompareAndSwap(c, nil) {
+		close(*c)
+	}
+	g.rw.RUnlock()
+}
+
+// Write calls fn with a pointer to the value under the write lock.
+// The pointer must not be retained after fn returns.
+func (g *Guarded[T]) Write(fn func(*T)) {
+	g.rw.Lock()
+	defer g.rw.Unlock()
+	fn(&g.v)
+	g.published()
+}
+
+// WriteDowngrade calls write under the write lock, downgrades to a
+// read lock, and calls read with a copy of the value written. No
+// other writer can run between the two calls, so read observes
+// exactly what write left behind.
+func (g *Guarded[T]) WriteDowngrade(write func(*T), read func(T)) {
+	g.rw.WithLockDowngrade(
+		func() { write(&g.v); g.published() },
+		func() { read(g.v) },
+	)
+}
+
+// DowngradePublishAwait calls write under the write lock, downgrades to
+// a read lock, and waits up to d for another reader to read the value
+// written, through Read, ReadNext or a fresh ReadOrStale, reporting
+// whether one did. Since the read lock is held throughout, no writer
+// can replace the value first, so a reader that ends the wait saw
+// exactly what write left behind. The read lock is released before
+// DowngradePublishAwait returns, and the lock entirely if write
+// panics.
+//
+// It is meant for handshakes, such as a test that must know a reader
+// has seen a new value before going on; a reader that is already
+// waiting when it is called takes the read lock as soon as it can.
+func (g *Guarded[T]) DowngradePublishAwait(write func(*T), d time.Duration) (observed bool) {
+	c := make(chan struct{})
+	g.rw.Lock()
+	wrote := false
+	defer func() {
+		if !wrote {
+			g.rw.Unlock()
+		}
+	}()
+	write(&g.v)
+	g.published()
+	// Set under the write lock, so only readers that come after the
+	// write, and so see it, can close c.
+	g.observed.Store(&c)
+	wrote = true
+	g.rw.Downgrade()
+	defer g.rw.RUnlock()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-c:
+		return true
+	case <-t.C:
+		if g.observed.CompareAndSwap(&c, nil) {
+			return false
+		}
+		// A reader took c just now and is closing it.
+		return true
+	}
+}
+
+// ReadOrStale returns a copy of the value, as Read would pass it, and
+// true if it can get the read lock within d. If a writer holds g for
+// longer, it returns instead the value as of the last write to g and
+// false, without waiting further, so that readers under load can
+// serve a slightly stale value rather than queue behind a slow write.
+//
+// Writes only keep that copy once ReadOrStale has been called, so that
+// a Guarded read only through Read does not pay for copying on every
+// write. Until then there is no stale value to return, and the first
+// ReadOrStale waits for the read lock however long it takes.
+func (g *Guarded[T]) ReadOrStale(d time.Duration) (value T, fresh bool) {
+	if g.stale.Load() == nil {
+		g.rw.RLock()
+	} else if !g.rw.TryRLockTimeout(d) {
+		return *g.stale.Load(), false
+	}
+	defer g.rUnlock()
+	if g.stale.Load() == nil {
+		// No writer can run until the read lock is released, so
+		// every write from here on sees the copy and replaces it.
+		v := g.v
+		g.stale.CompareAndSwap(nil, &v)
+	}
+	return g.v, true
+}
+
+// published replaces the copy of the value kept for ReadOrStale, if
+// there is one, after a write. g must be write-locked.
+func (g *Guarded[T]) published() {
+	if g.stale.Load() != nil {
+		v := g.v
+		g.stale.Store(&v)
+	}
+}
+
+// Swap replaces the value with new under the write lock and returns
+// the value it replaced. Once Swap returns, no Read or Write that could
+// see old is still running, so old may be reclaimed, as in read-copy-
+// update, unless one of them kept a copy of it.
+func (g *Guarded[T]) Swap(new T) (old T) {
+	g.rw.Lock()
+	defer g.rw.Unlock()
+	old, g.v = g.v, new
+	g.published()
+	return old
+}
+
+// Publish replaces the value of g in the read-copy-update style:
+// produce computes the new value from the old one under the write
+// lock, and may return a cleanup function that reclaims the old one.
+// Once the new value is installed, Publish downgrades to a read lock,
+// so that readers get in on the new value while verify, if not nil,
+// checks it alongside them with no writer able to replace it. Publish
+// then releases g and only after that calls cleanup, outside the lock.
+//
+// Cleanup cannot race with a Read of the old value, so there is no
+// separate wait for readers to drain: the write lock is granted only
+// once every Read that could see the old value has returned, which is
+// the wait WaitForReaders would do, and every Read after it sees the
+// new one. Waiting for readers again before cleanup would only wait
+// for readers of the new value, and never end under a steady stream of
+// them. As with Swap, that does not cover copies a reader kept.
+//
+// If produce panics, g is unlocked and left as it was. If verify
+// panics, g is unlocked with the new value in place and cleanup is not
+// called.
+func Publish[T any](g *Guarded[T], produce func(old T) (new T, cleanup func()), verify func(new T)) {
+	if cleanup := g.publish(produce, verify); cleanup != nil {
+		cleanup()
+	}
+}
+
+// publish does the locked part of Publish and returns the cleanup
+// function produce returned.
+func (g *Guarded[T]) publish(produce func(old T) (new T, cleanup func()), verify func(new T)) func() {
+	g.rw.Lock()
+	downgraded := false
+	defer func() {
+		if downgraded {
+			g.rw.RUnlock()
+		} else {
+			g.rw.Unlock()
+		}
+	}()
+	v, cleanup := produce(g.v)
+	g.v = v
+	g.published()
+	g.rw.Downgrade()
+	downgraded = true
+	if verify != nil {
+		verify(g.v)
+	}
+	return cleanup
+}
+
+// BroadcastWrite replaces the value of g with the one produce computes
+// from the old value under the write lock, and wakes every goroutine
+// waiting in ReadNext to read it. The wakeups are sent while g is still
+// write-locked, so no woken reader can get in ahead of the new value;
+// BroadcastWrite then downgrades, releasing the readers blocked behind
+// it as one batch, and leaves g. A woken reader that only reaches the
+// lock after another write reads that write's value instead, which is
+// newer still. If produce panics, g is unlocked and left as it was, and
+// nobody is woken.
+func BroadcastWrite[T any](g *Guarded[T], produce func(old T) T) {
+	g.rw.Lock()
+	downgraded := false
+	defer func() {
+		if downgraded {
+			g.rw.RUnlock()
+		} else {
+			g.rw.Unlock()
+		}
+	}()
+	g.v = produce(g.v)
+	g.published()
+	if c := g.next.Load(); c != nil {
+		c.Broadcast()
+	}
+	g.rw.Downgrade()
+	downgraded = true
+}
+
+// ReadNext waits for the next BroadcastWrite to g and calls fn with a
+// copy of the value under the read lock, as Read does. It starts
+// waiting under the read lock, so the write it waits for is one that
+// could not have begun before ReadNext was called, and fn never sees a
+// value from before that write. Other writes do not end the wait.
+func (g *Guarded[T]) ReadNext(fn func(T)) {
+	c := g.next.Load()
+	if c == nil {
+		g.next.CompareAndSwap(nil, NewCond(&g.rw))
+		c = g.next.Load()
+	}
+	g.rw.RLock()
+	c.RWait()
+	defer g.rUnlock()
+	fn(g.v)
+}
+
+// WriteBoth calls fn with pointers to the values of ga and gb under
+// both write locks, so that readers of either see both updates or
+// neither. The locks are taken in the same order as LockAll, whatever
+// the order of the arguments, so goroutines writing the same pair
+// cannot deadlock on each other. Both are released even if fn panics.
+func WriteBoth[A, B any](ga *Guarded[A], gb *Guarded[B], fn func(*A, *B)) {
+	LockAll(&ga.rw, &gb.rw)
+	defer UnlockAll(&ga.rw, &gb.rw)
+	fn(&ga.v, &gb.v)
+	ga.published()
+	gb.published()
+}
+
+// ReadBoth calls fn with copies of the values of ga and gb under both
+// read locks, so that it sees the two as of the same instant with
+// respect to WriteBoth. The locks are taken in the same order as
+// WriteBoth takes them, and released even if fn panics.
+func ReadBoth[A, B any](ga *Guarded[A], gb *Guarded[B], fn func(A, B)) {
+	RLockAll(&ga.rw, &gb.rw)
+	defer RUnlockAll(&ga.rw, &gb.rw)
+	fn(ga.v, gb.v)
+}
+
+// CompareAndWrite calls mutate under the write lock with a pointer to
+// a copy of the value, if the value is deeply equal to expected as
+// reported by reflect.DeepEqual, and reports whether it did and mutate
+// returned true. Only then is the copy stored. If mutate returns false
+// or panics, the value is left as it was, except for changes made
+// through memory the copy shares with it, and Version is unchanged.
+func (g *Guarded[T]) CompareAndWrite(expected T, mutate func(*T) bool) bool {
+	return g.CompareAndWriteFunc(expected, func(a, b T) bool {
+		return reflect.DeepEqual(a, b)
+	}, mutate)
+}
+
+// CompareAndWriteFunc is like CompareAndWrite, but compares the value
+// with expected using equal, which must not use g.
+func (g *Guarded[T]) CompareAndWriteFunc(expected T, equal func(a, b T) bool, mutate func(*T) bool) bool {
+	g.rw.Lock()
+	wrote := false
+	defer func() {
+		if wrote {
+			g.rw.Unlock()
+		} else {
+			g.rw.abort()
+		}
+	}()
+	if !equal(g.v, expected) {
+		return false
+	}
+	v := g.v
+	if !mutate(&v) {
+		return false
+	}
+	g.v = v
+	g.published()
+	wrote = true
+	return true
+}
+
+// WriteCompareDowngrade installs new as the value of g under the write
+// lock if the value is deeply equal to expected, as CompareAndWrite
+// compares them, then downgrades and calls read with the new value
+// under the read lock, so that read sees exactly what was installed
+// and no other writer can run in between. It reports whether it did.
+// If the value is not expected, g is unlocked unchanged, Version
+// included, read is not called, and WriteCompareDowngrade returns
+// false. g is released even if read panics.
+func (g *Guarded[T]) WriteCompareDowngrade(expected, new T, read func(T)) bool {
+	g.rw.Lock()
+	downgraded := false
+	defer func() {
+		if downgraded {
+			g.rw.RUnlock()
+		} else {
+			g.rw.abort()
+		}
+	}()
+	if !reflect.DeepEqual(g.v, expected) {
+		return false
+	}
+	g.v = new
+	g.published()
+	g.rw.Downgrade()
+	downgraded = true
+	read(g.v)
+	return true
+}
+
+// Version returns the number of writes to g so far, counting Write
+// and WriteDowngrade whether or not they changed the value, and
+// CompareAndWrite only when it stored a new one.
+func (g *Guarded[T]) Version() uint64 {
+	return g.rw.Version()
+}
@@ -0,0 +1,521 @@
+package lock_test
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestGuarded(t *testing.T) {
+	g := NewGuarded(1)
+	g.Write(func(v *int) { *v++ })
+	var got int
+	g.Read(func(v int) { got = v })
+	if got != 2 {
+		t.Fatalf("Read got %d, want 2", got)
+	}
+	g.WriteDowngrade(func(v *int) { *v *= 10 }, func(v int) { got = v })
+	if got != 20 {
+		t.Fatalf("WriteDowngrade read %d, want 20", got)
+	}
+}
+
+func TestGuardedConcurrent(t *testing.T) {
+	const n, loops = 8, 1000
+	var g Guarded[int]
+	done := make(chan bool)
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := 0; j < loops; j++ {
+				g.Write(func(v *int) { *v++ })
+				g.Read(func(v int) {
+					if v < 1 {
+						panic("lost write")
+					}
+				})
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < n; i++ {
+		<-done
+	}
+	g.Read(func(v int) {
+		if v != n*loops {
+			t.Fatalf("got %d, want %d", v, n*loops)
+		}
+	})
+}
+
+func TestGuardedSwap(t *testing.T) {
+	const n, loops = 8, 1000
+	g := NewGuarded(-1)
+	olds := make(chan []int)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			var got []int
+			for j := 0; j < loops; j++ {
+				got = append(got, g.Swap(i*loops+j))
+			}
+			olds <- got
+		}(i)
+	}
+	// Every value stored is returned by exactly one Swap, except the
+	// last, which is still held.
+	seen := make(map[int]bool)
+	for i := 0; i < n; i++ {
+		for _, v := range <-olds {
+			if seen[v] {
+				t.Fatalf("Swap returned %d twice", v)
+			}
+			seen[v] = true
+		}
+	}
+	g.Read(func(v int) {
+		if seen[v] {
+			t.Fatalf("Swap returned the value still held, %d", v)
+		}
+		seen[v] = true
+	})
+	for v := -1; v < n*loops; v++ {
+		if !seen[v] {
+			t.Fatalf("value %d lost: no Swap returned it", v)
+		}
+	}
+}
+
+// TestPublish runs readers against a stream of published values and
+// checks that none of them ever sees a value its cleanup reclaimed.
+func TestPublish(t *testing.T) {
+	type buffer struct {
+		gen   int
+		freed atomic.Bool
+	}
+	g := NewGuarded(new(buffer))
+	const readers, publishers, loops = 4, 2, 500
+	done := make(chan bool)
+	for r := 0; r < readers; r++ {
+		go func() {
+			// Readers overlapping without end would starve the
+			// publishers, so they stop after as many rounds.
+			for i := 0; i < loops; i++ {
+				g.Read(func(b *buffer) {
+					for i := 0; i < 3; i++ {
+						if b.freed.Load() {
+							t.Errorf("reader saw generation %d after its cleanup", b.gen)
+						}
+						runtime.Gosched()
+					}
+				})
+			}
+			done <- true
+		}()
+	}
+	for p := 0; p < publishers; p++ {
+		go func() {
+			for i := 0; i < loops; i++ {
+				var want *buffer
+				Publish(g, func(old *buffer) (*buffer, func()) {
+					want = &buffer{gen: old.gen + 1}
+					return want, func() { old.freed.Store(true) }
+				}, func(b *buffer) {
+					// Under the downgraded lock, readers may
+					// come in but no writer may replace b.
+					g.Read(func(cur *buffer) {
+						if b != want || cur != b {
+							t.Errorf("verify saw generation %d, readers %d, want %d", b.gen, cur.gen, want.gen)
+						}
+					})
+				})
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < readers+publishers; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Publish deadlocked")
+		}
+	}
+	g.Read(func(b *buffer) {
+		if b.gen != publishers*loops || b.freed.Load() {
+			t.Fatalf("current value: generation %d, freed %v, want generation %d live", b.gen, b.freed.Load(), publishers*loops)
+		}
+	})
+
+	// Cleanup runs with g released, so it may use g itself.
+	var cleaned bool
+	Publish(g, func(old *buffer) (*buffer, func()) {
+		return old, func() {
+			g.Write(func(**buffer) {})
+			cleaned = true
+		}
+	}, nil)
+	if !cleaned {
+		t.Fatalf("Publish did not run cleanup")
+	}
+}
+
+func TestBroadcastWrite(t *testing.T) {
+	g := NewGuarded(0)
+	const readers = 16
+	seen := make(chan int, readers)
+	for r := 0; r < readers; r++ {
+		go g.ReadNext(func(v int) { seen <- v })
+	}
+	for deadline := time.Now().Add(10 * time.Second); !parked(readers); {
+		if time.Now().After(deadline) {
+			t.Fatalf("readers never parked in ReadNext")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	g.Write(func(v *int) { *v = 1 })
+	select {
+	case v := <-seen:
+		t.Fatalf("a reader woke after a Write, reading %d", v)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Readers arriving during the write block behind it and are let
+	// in by the same downgrade as the woken ones.
+	BroadcastWrite(g, func(old int) int {
+		for r := 0; r < readers; r++ {
+			go g.Read(func(v int) { seen <- v })
+		}
+		return old + 1
+	})
+	for r := 0; r < 2*readers; r++ {
+		select {
+		case v := <-seen:
+			if v != 2 {
+				t.Fatalf("reader saw %d after BroadcastWrite, want 2", v)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("only %d of %d readers returned", r, 2*readers)
+		}
+	}
+}
+
+func TestReadOrStale(t *testing.T) {
+	g := NewGuarded(1)
+	if v, fresh := g.ReadOrStale(0); v != 1 || !fresh {
+		t.Fatalf("ReadOrStale = %d, %v on an idle Guarded, want 1, true", v, fresh)
+	}
+	// Every way of writing g replaces the stale copy.
+	for _, w := range []struct {
+		name  string
+		write func(v int)
+	}{
+		{"Write", func(v int) { g.Write(func(p *int) { *p = v }) }},
+		{"WriteDowngrade", func(v int) { g.WriteDowngrade(func(p *int) { *p = v }, func(int) {}) }},
+		{"Swap", func(v int) { g.Swap(v) }},
+		{"Publish", func(v int) { Publish(g, func(int) (int, func()) { return v, nil }, nil) }},
+		{"BroadcastWrite", func(v int) { BroadcastWrite(g, func(int) int { return v }) }},
+		{"CompareAndWrite", func(v int) { g.CompareAndWrite(v-1, func(p *int) bool { *p = v; return true }) }},
+		{"WriteBoth", func(v int) { WriteBoth(g, new(Guarded[int]), func(p, _ *int) { *p = v }) }},
+	} {
+		want, _ := g.ReadOrStale(0)
+		want++
+		w.write(want)
+
+		writing, release := make(chan bool), make(chan bool)
+		go g.Write(func(v *int) {
+			*v++
+			writing <- true
+			<-release
+		})
+		<-writing
+		if v, fresh := g.ReadOrStale(10 * time.Millisecond); v != want || fresh {
+			t.Fatalf("%s: ReadOrStale = %d, %v behind a held write, want the stale %d, false", w.name, v, fresh, want)
+		}
+		close(release)
+		if v, fresh := g.ReadOrStale(10 * time.Second); v != want+1 || !fresh {
+			t.Fatalf("%s: ReadOrStale = %d, %v after the write, want %d, true", w.name, v, fresh, want+1)
+		}
+	}
+}
+
+func TestWriteBoth(t *testing.T) {
+	a, b := NewGuarded(0), NewGuarded("")
+	const loops = 2000
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < loops; i++ {
+			WriteBoth(a, b, func(n *int, s *string) {
+				*n++
+				*s = fmt.Sprint(*n)
+			})
+		}
+		done <- true
+	}()
+	go func() {
+		for i := 0; i < loops; i++ {
+			WriteBoth(b, a, func(s *string, n *int) {
+				*n++
+				*s = fmt.Sprint(*n)
+			})
+		}
+		done <- true
+	}()
+	go func() {
+		for i := 0; i < loops; i++ {
+			ReadBoth(a, b, func(n int, s string) {
+				if n != 0 && s != fmt.Sprint(n) {
+					t.Errorf("ReadBoth saw %d and %q, written together", n, s)
+				}
+			})
+		}
+		done <- true
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("deadlock writing the same pair in opposite order")
+		}
+	}
+	ReadBoth(a, b, func(n int, s string) {
+		if n != 2*loops || s != fmt.Sprint(2*loops) {
+			t.Fatalf("after %d writes: %d, %q", 2*loops, n, s)
+		}
+	})
+}
+
+func TestGuardedCompareAndWrite(t *testing.T) {
+	g := NewGuarded([]int{1})
+	if g.CompareAndWrite([]int{2}, func(v *[]int) bool {
+		t.Fatalf("mutate called with a mismatched value")
+		return true
+	}) {
+		t.Fatalf("CompareAndWrite with a mismatched value succeeded")
+	}
+	if g.CompareAndWrite([]int{1}, func(v *[]int) bool {
+		*v = append(*v, 2)
+		return false
+	}) {
+		t.Fatalf("CompareAndWrite succeeded with mutate aborting")
+	}
+	if v := g.Version(); v != 0 {
+		t.Fatalf("version %d after failed CompareAndWrites, want 0", v)
+	}
+	if !g.CompareAndWrite([]int{1}, func(v *[]int) bool {
+		*v = append(*v, 2)
+		return true
+	}) {
+		t.Fatalf("CompareAndWrite failed")
+	}
+	g.Read(func(v []int) {
+		if len(v) != 2 {
+			t.Fatalf("got %v, want [1 2]", v)
+		}
+	})
+	if v := g.Version(); v != 1 {
+		t.Fatalf("version %d after a CompareAndWrite, want 1", v)
+	}
+}
+
+func TestGuardedCompareAndWriteContended(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		var g Guarded[int]
+		var ran int32
+		start := make(chan bool)
+		done := make(chan bool)
+		for id := 1; id <= 2; id++ {
+			go func(id int) {
+				<-start
+				g.CompareAndWrite(0, func(v *int) bool {
+					atomic.AddInt32(&ran, 1)
+					*v = id
+					return true
+				})
+				done <- true
+			}(id)
+		}
+		close(start)
+		<-done
+		<-done
+		if ran != 1 {
+			t.Fatalf("mutate ran %d times, want once", ran)
+		}
+	}
+}
+
+// mustPanic calls fn and reports whether it panicked.
+func mustPanic(fn func()) (panicked bool) {
+	defer func() {
+		panicked = recover() != nil
+	}()
+	fn()
+	return false
+}
+
+func TestWriteCompareDowngrade(t *testing.T) {
+	g := NewGuarded(0)
+	v := g.Version()
+	if g.WriteCompareDowngrade(1, 2, func(int) { t.Errorf("read called after a failed compare") }) {
+		t.Fatalf("WriteCompareDowngrade succeeded with the wrong expected value")
+	}
+	if g.Version() != v {
+		t.Fatalf("failed WriteCompareDowngrade changed the version")
+	}
+
+	const n, loops = 4, 200
+	var commits int32
+	done := make(chan bool)
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := 0; j < loops; j++ {
+				var old int
+				g.Read(func(v int) { old = v })
+				if g.WriteCompareDowngrade(old, old+1, func(v int) {
+					if v != old+1 {
+						t.Errorf("read %d after installing %d", v, old+1)
+					}
+				}) {
+					atomic.AddInt32(&commits, 1)
+				}
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < n; i++ {
+		<-done
+	}
+	g.Read(func(v int) {
+		if v != int(commits) || commits == 0 {
+			t.Fatalf("value %d after %d commits", v, commits)
+		}
+	})
+	if got := g.Version() - v; got != uint64(commits) {
+		t.Fatalf("version advanced %d times for %d commits", got, commits)
+	}
+}
+
+func TestGuardedPanic(t *testing.T) {
+	var g Guarded[int]
+	for _, tc := range []struct {
+		name string
+		fn   func()
+	}{
+		{"Read", func() { g.Read(func(int) { panic("read") }) }},
+		{"Write", func() { g.Write(func(*int) { panic("write") }) }},
+		{"WriteDowngrade/write", func() { g.WriteDowngrade(func(*int) { panic("write") }, func(int) {}) }},
+		{"WriteDowngrade/read", func() { g.WriteDowngrade(func(*int) {}, func(int) { panic("read") }) }},
+		{"WriteBoth", func() { WriteBoth(&g, &g, func(*int, *int) { panic("write") }) }},
+		{"ReadBoth", func() { ReadBoth(&g, &g, func(int, int) { panic("read") }) }},
+		{"Publish/produce", func() { Publish(&g, func(int) (int, func()) { panic("produce") }, nil) }},
+		{"WriteCompareDowngrade", func() {
+			g.Swap(0)
+			g.WriteCompareDowngrade(0, 0, func(int) { panic("read") })
+		}},
+		{"BroadcastWrite", func() { BroadcastWrite(&g, func(int) int { panic("produce") }) }},
+		{"SubmitMutation", func() { g.SubmitMutation(func(*int) { panic("write") }) }},
+		{"DowngradePublishAwait", func() { g.DowngradePublishAwait(func(*int) { panic("write") }, time.Second) }},
+		{"Publish/verify", func() {
+			Publish(&g, func(v int) (int, func()) { return v, nil }, func(int) { panic("verify") })
+		}},
+	} {
+		if !mustPanic(tc.fn) {
+			t.Fatalf("%s: closure panic was swallowed", tc.name)
+		}
+		done := make(chan bool, 1)
+		go func() {
+			g.Write(func(*int) {})
+			done <- true
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: lock still held after panic", tc.name)
+		}
+	}
+}
+
+func TestDowngradePublishAwait(t *testing.T) {
+	g := NewGuarded(0)
+	stop := make(chan bool)
+	var saw atomic.Int64
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			g.Read(func(v int) {
+				if int64(v) > saw.Load() {
+					saw.Store(int64(v))
+				}
+			})
+			runtime.Gosched()
+		}
+	}()
+	for i := 1; i <= 10; i++ {
+		if !g.DowngradePublishAwait(func(v *int) { *v = i }, 10*time.Second) {
+			t.Fatalf("publish %d: no reader observed it", i)
+		}
+		if got := saw.Load(); got != int64(i) {
+			t.Fatalf("publish %d confirmed, but the reader saw %d", i, got)
+		}
+	}
+	close(stop)
+	<-done
+
+	start := time.Now()
+	if g.DowngradePublishAwait(func(v *int) { *v = 11 }, 20*time.Millisecond) {
+		t.Fatalf("publish observed with no readers")
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("gave up after %v, want at least 20ms", d)
+	}
+	g.Write(func(*int) {}) // hangs if the lock is still held
+
+	// A read after the timeout must not be taken for an observation
+	// of a later publish that nobody reads.
+	g.Read(func(int) {})
+	if g.DowngradePublishAwait(func(v *int) { *v = 12 }, 10*time.Millisecond) {
+		t.Fatalf("publish observed by a read that came before it")
+	}
+}
+
+func TestDowngradePublishAwaitReadOrStale(t *testing.T) {
+	g := NewGuarded(0)
+	g.ReadOrStale(time.Second) // keep a stale copy from here on
+	stop, done := make(chan bool), make(chan bool)
+	var fresh atomic.Int64
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if v, ok := g.ReadOrStale(time.Second); ok {
+				fresh.Store(int64(v))
+			}
+			runtime.Gosched()
+		}
+	}()
+	for i := 1; i <= 5; i++ {
+		if !g.DowngradePublishAwait(func(v *int) { *v = i }, 10*time.Second) {
+			t.Fatalf("publish %d: a fresh ReadOrStale did not confirm it", i)
+		}
+		// The reader stores what it read only after ReadOrStale
+		// returns, which is after it confirmed the publish.
+		for start := time.Now(); fresh.Load() != int64(i); runtime.Gosched() {
+			if time.Since(start) > 10*time.Second {
+				t.Fatalf("publish %d confirmed, but ReadOrStale returned %d", i, fresh.Load())
+			}
+		}
+	}
+	close(stop)
+	<-done
+}
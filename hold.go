@@ -0,0 +1,69 @@
+package lock
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HoldStats is the time spent holding the write lock of an RW, as
+// returned by RW.HoldStats.
+type HoldStats struct {
+	Holds int64         // write locks released so far
+	Total time.Duration // time they were held, summed
+	Max   time.Duration // time the longest of them was held
+}
+
+// holdTimes accumulates the HoldStats of an RW made WithHoldStats.
+type holdTimes struct {
+	start atomic.Int64 // since holdEpoch, or 0 if the lock is not timed
+	holds atomic.Int64
+	total atomic.Int64
+	max   atomic.Int64
+}
+
+// holdEpoch is the origin of holdTimes.start, so that it is read from
+// the monotonic clock.
+var holdEpoch = time.Now()
+
+// HoldStats returns the time write locks of rw were held, from when
+// Lock, TryLock or an upgrade acquired them to when Unlock or a
+// downgrade released them, if rw was made WithHoldStats. For any other
+// RW it returns the zero HoldStats. A write lock still held is not
+// counted.
+func (rw *RW) HoldStats() HoldStats {
+	if rw.cfg == nil || rw.cfg.hold == nil {
+		return HoldStats{}
+	}
+	h := rw.cfg.hold
+	return HoldStats{
+		Holds: h.holds.Load(),
+		Total: time.Duration(h.total.Load()),
+		Max:   time.Duration(h.max.Load()),
+	}
+}
+
+// holdBegin starts timing the write lock just acquired, if rw keeps
+// HoldStats.
+func (rw *RW) holdBegin() {
+	if rw.cfg != nil && rw.cfg.hold != nil {
+		rw.cfg.hold.start.Store(int64(time.Since(holdEpoch)) | 1)
+	}
+}
+
+// holdEnd adds the write lock about to be released to rw's HoldStats,
+// if it keeps them and the lock was timed. rw must be write-locked.
+func (rw *RW) holdEnd() {
+	if rw.cfg == nil || rw.cfg.hold == nil {
+		return
+	}
+	h := rw.cfg.hold
+	start := h.start.Swap(0)
+	if start == 0 {
+		return // stored by UnsafeStoreState rather than acquired
+	}
+	d := int64(time.Since(holdEpoch)) - start
+	h.holds.Add(1)
+	h.total.Add(d)
+	for m := h.max.Load(); d > m && !h.max.CompareAndSwap(m, d); m = h.max.Load() {
+	}
+}
@@ -0,0 +1,49 @@
+package lock_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestHoldStats(t *testing.T) {
+	const hold = 5 * time.Millisecond
+	rw := New(WithHoldStats())
+	rw.Lock()
+	time.Sleep(hold)
+	if st := rw.HoldStats(); st != (HoldStats{}) {
+		t.Fatalf("HoldStats = %+v with the first write lock still held, want zero", st)
+	}
+	rw.Unlock()
+	st := rw.HoldStats()
+	if st.Holds != 1 || st.Total < hold || st.Max != st.Total {
+		t.Fatalf("HoldStats = %+v after one hold of %v", st, hold)
+	}
+
+	// A short hold ending in a downgrade adds to the total but leaves
+	// the maximum, and read locks are not timed.
+	rw.Lock()
+	rw.Downgrade()
+	time.Sleep(hold)
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.Fatalf("TryLock failed on an idle RW")
+	}
+	rw.Unlock()
+	next := rw.HoldStats()
+	if next.Holds != 3 || next.Total <= st.Total || next.Total >= st.Total+hold || next.Max != st.Max {
+		t.Fatalf("HoldStats = %+v after two short holds following %+v", next, st)
+	}
+}
+
+func TestHoldStatsOff(t *testing.T) {
+	for _, rw := range []*RW{new(RW), New()} {
+		rw.Lock()
+		time.Sleep(time.Millisecond)
+		rw.Unlock()
+		if st := rw.HoldStats(); st != (HoldStats{}) {
+			t.Fatalf("HoldStats = %+v without WithHoldStats, want zero", st)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package lock
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LockLease locks rw for at most d. It returns a function that
+// releases the lock, and a channel that is closed if the lease lapses
+// first: after d, a lock its holder has not released is released for
+// it, so that one stuck or buggy holder cannot deadlock the program
+// forever. Whichever of the two comes first releases the lock, and the
+// other does nothing, so release may be called, once or more, at any
+// time, including after the lease lapsed.
+//
+// A holder cannot know that it still holds rw: the lease may lapse
+// right after it checks expired. After d, its writes to what rw
+// protects may overlap those of the next holder, and data it left
+// halfway through a change is seen as it was left.
+func (rw *RW) LockLease(d time.Duration) (release func(), expired <-chan struct{}) {
+	rw.Lock()
+	var done atomic.Bool
+	lapsed := make(chan struct{})
+	t := time.AfterFunc(d, func() {
+		if done.CompareAndSwap(false, true) {
+			// Take the lock over from its holder, as its writer
+			// in lockdebug builds, to release it.
+			rw.dbg.locked()
+			rw.Unlock()
+			close(lapsed)
+		}
+	})
+	release = func() {
+		if done.CompareAndSwap(false, true) {
+			t.Stop()
+			rw.Unlock()
+		}
+	}
+	return release, lapsed
+}
@@ -0,0 +1,62 @@
+package lock_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestLockLease(t *testing.T) {
+	var rw RW
+	release, expired := rw.LockLease(time.Hour)
+	if !rw.IsWriteLocked() {
+		t.Fatalf("LockLease returned without the lock")
+	}
+	release()
+	release() // does nothing
+	select {
+	case <-expired:
+		t.Fatalf("lease released in time reported expired")
+	default:
+	}
+	if !rw.TryLock() {
+		t.Fatalf("lock still held after release: %v", &rw)
+	}
+	rw.Unlock()
+}
+
+func TestLockLeaseExpires(t *testing.T) {
+	var rw RW
+	release, expired := rw.LockLease(10 * time.Millisecond)
+	select {
+	case <-expired:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("lease held too long never expired")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("lock still held after the lease expired: %v", &rw)
+	}
+	// The lapsed holder's release leaves the new holder's lock alone.
+	release()
+	if !rw.IsWriteLocked() {
+		t.Fatalf("release after expiry unlocked the next holder's lock")
+	}
+	rw.Unlock()
+}
+
+func TestLockLeaseReleaseRace(t *testing.T) {
+	var rw RW
+	for i := 0; i < 200; i++ {
+		// The lease lapses about when it is released, and the lock is
+		// released once either way, or the next Unlock would panic.
+		release, _ := rw.LockLease(time.Duration(i%4) * time.Microsecond)
+		release()
+		rw.Lock()
+		rw.Unlock()
+	}
+	if !rw.TryLock() {
+		t.Fatalf("lock held after the leases: %v", &rw)
+	}
+	rw.Unlock()
+}
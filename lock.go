@@ -8,6 +8,23 @@
 //   value currently protected by the lock or the value currently
 //  being written by a writer.
 // - Writer can become a reader, releasing the write half of the lock
+// - New(WithWriterPreference()) trades reader priority for bounded
+//   writer latency: new readers wait while a writer is waiting.
+// - New(WithAlternation()) bounds both: write turns alternate with
+//   turns for the readers that waited, each read turn still grouped.
+// - Waiters spin briefly, then park until a release wakes them, so a
+//   long hold does not cost the waiters' processors (see SpinBudget).
+//
+// Building with -tags lockdebug enables checks that catch misuse of
+// the locks, such as a Reset of a lock still in use, at some cost in
+// speed. These builds also tie the write lock of an RW to the goroutine
+// that acquired it, which alone may Unlock or Downgrade it and which
+// must not Lock it again, and panic when a goroutine blocks acquiring
+// RWs in an order that could deadlock with the order others acquired
+// them in.
+//
+// Building with -tags lockportable makes waiters park through the
+// standard library alone, without go:linkname (see WithPortableParking).
 //
 // Implementation details:
 // Reader:
@@ -26,42 +43,629 @@
 // - Downgrade unlock: add -2 (same as reader).
 package lock
 
-import "sync/atomic"
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// RW is a downgradeable read/write spinlock. Its write half
+// satisfies sync.Locker. The zero value is an unlocked RW.
+//
+// The methods of RW include every method of sync.RWMutex, with the
+// same signatures, so a sync.RWMutex used only through its methods can
+// be replaced by an RW without other changes, gaining Downgrade and
+// the rest.
+//
+// In the terms of the Go memory model, as for sync.RWMutex, the n'th
+// release of the write lock, by Unlock or Downgrade, is synchronized
+// before every acquisition that returns after it, of either half, and
+// every RUnlock is synchronized before the acquisition of the write
+// lock that follows it, by Lock or Upgrade. Readers are not ordered
+// with each other. LoadAcquire and StoreRelease extend these edges to
+// code that does not take the lock.
+//
+// An RW must not be copied after first use; go vet reports copies.
+// Use New to create an RW with settings other than the defaults.
+// Its 64-bit words align themselves, so an RW may be placed anywhere
+// in a struct, even on 32-bit platforms.
+//
+// An RW takes up 112 bytes on 64-bit platforms, 8 more in lockdebug
+// builds, and never more than a cache line of 128 bytes on any. Code
+// that packs locks tightly may rely on this, and the package checks
+// it at compile time.
+type RW struct {
+	noCopy  noCopy
+	dbg     debugState
+	state   atomic.Int64
+	cfg     *config
+	seq     atomic.Uint64  // odd while a writer holds the lock, see Seq
+	epochs  epochs         // readers that entered with RLockEpoch
+	writers int32          // writers waiting for the lock
+	readerq waitq          // readers parked until the writer leaves
+	writerq waitq          // writers parked until rw is idle
+	idle    unsafe.Pointer // channel of Notify, or nil
+	nudges  unsafe.Pointer // *nudge of OnWriterWaiting, or nil
+}
+
+var _ sync.Locker = (*RW)(nil)
+
+// The size of an RW, as documented, checked on 64-bit platforms, where
+// a length other than 0 fails to compile. It fits a cache line on all.
+const rwSize = 112 + int(unsafe.Sizeof(debugState{}))
+
+var (
+	_ [0]struct{} = [int(unsafe.Sizeof(uintptr(0))) / 8 * (int(unsafe.Sizeof(RW{})) - rwSize)]struct{}{}
+	_ [cacheLine - unsafe.Sizeof(RW{})]struct{}
+)
 
-// RW is a downgradeable read/write spinlock.
-type RW int64
+// fastPaths is whether Lock and RLock try the lock inline, for a zero
+// RW, before calling the functions that do all the rest. Race and
+// lockdebug builds have work to do around every acquisition.
+const fastPaths = !raceEnabled && !debug
 
 // Lock locks rw. If the lock is already in use, the calling goroutine
-// spins until the rw is available.
+// spins until the rw is available, and blocks once it has spun for
+// SpinBudget attempts. A goroutine that already holds the write lock
+// deadlocks if it calls Lock again; lockdebug builds panic instead.
+//
+// Lock is small enough to inline into its callers, where an RW with
+// the default settings is locked by a single CAS if it is free.
 func (rw *RW) Lock() {
-	for !atomic.CompareAndSwapInt64((*int64)(rw), 0, 1) {
+	// The atomic functions on the words of state and seq cost the
+	// inliner less than the methods of their types, which would take
+	// Lock over its budget.
+	if fastPaths && rw.cfg == nil && atomic.CompareAndSwapInt64((*int64)(unsafe.Pointer(&rw.state)), 0, 1) {
+		atomic.AddUint64((*uint64)(unsafe.Pointer(&rw.seq)), 1)
+		return
+	}
+	rw.lockDefault()
+}
+
+// lockDefault locks rw as Lock does, after the fast path of Lock
+// failed or was not taken.
+func (rw *RW) lockDefault() {
+	s := spinner{cfg: rw.cfg}
+	if !rw.lock(&s) {
+		rw.panicClosed()
+	}
+}
+
+// LockSlow locks rw like Lock and reports whether it had to wait,
+// because the lock was in use when first tried. It lets callers count
+// contended acquisitions without a contention observer.
+func (rw *RW) LockSlow() (spun bool) {
+	s := spinner{cfg: rw.cfg}
+	if !rw.lock(&s) {
+		rw.panicClosed()
+	}
+	return s.n > 0
+}
+
+// LockCounting locks rw like Lock and returns the number of failed
+// attempts it made first, zero if the lock was free. Together with
+// RLockCounting it gives callers finer contention data than LockSlow,
+// for histograms, at the cost of nothing but the count.
+func (rw *RW) LockCounting() (spins uint64) {
+	s := spinner{cfg: rw.cfg}
+	if !rw.lock(&s) {
+		rw.panicClosed()
 	}
+	return uint64(s.n)
 }
 
-// Unlock unlocks rw. It is undefined if rw is not locked on entry
-// to Unlock.
+// LockBlocking locks rw like Lock, but parks as soon as its first
+// attempt fails instead of spinning, whatever the spin budget and
+// WithYield say. It trades the latency of a handoff for no processor
+// time spent waiting, for callers where spinning only steals time from
+// the holder, such as oversubscribed containers. To have every Lock of
+// an RW behave this way, create it WithSpinBudget(0).
+func (rw *RW) LockBlocking() {
+	s := spinner{cfg: rw.cfg, block: true}
+	if !rw.lock(&s) {
+		rw.panicClosed()
+	}
+}
+
+// lock locks rw, pacing failed attempts with s, and reports whether
+// it succeeded before s gave up.
+func (rw *RW) lock(s *spinner) bool {
+	if !s.bounded && s.stop == nil {
+		// Only an acquisition that cannot give up hangs for good.
+		rw.dbg.checkReentry()
+	}
+	rw.dbg.checkOrder()
+	if raceEnabled {
+		raceDisable()
+	}
+	ok := rw.state.CompareAndSwap(0, 1) || rw.lockSlow(s)
+	if ok {
+		rw.seq.Add(1)
+		rw.dbg.locked()
+		rw.holdBegin()
+	}
+	if raceEnabled {
+		raceEnable()
+		if ok {
+			rw.raceAcquireWrite()
+		}
+	}
+	if ok {
+		s.acquired(Write)
+		rw.counted(Write, 1, s.n > 0)
+		rw.dbg.hold(1)
+	}
+	return ok
+}
+
+// lockSlow spins for the write lock after a failed first attempt,
+// counting as a waiting writer while it does.
+func (rw *RW) lockSlow(s *spinner) bool {
+	if atomic.AddInt32(&rw.writers, 1) == 1 {
+		rw.resetOvertakes()
+	}
+	defer atomic.AddInt32(&rw.writers, -1)
+	if atomic.LoadPointer(&rw.nudges) != nil {
+		if raceEnabled {
+			raceEnable()
+		}
+		rw.writerWaiting()
+		if raceEnabled {
+			raceDisable()
+		}
+	}
+	for {
+		if rw.IsClosed() {
+			return false
+		}
+		if s.parks() {
+			// A closed rw is never idle again, so the writer must
+			// not sleep once it is closed, whether or not the wakeup
+			// of Close came before it parked.
+			var got bool
+			rw.writerq.park(s, func() bool {
+				got = rw.tryWrite()
+				return got || rw.IsClosed()
+			})
+			if got {
+				rw.resetOvertakes()
+				return true
+			}
+		} else if !s.spin() {
+			return false
+		}
+		if rw.tryWrite() {
+			rw.resetOvertakes()
+			return true
+		}
+	}
+}
+
+// tryWrite makes one attempt to lock rw for writing.
+func (rw *RW) tryWrite() bool {
+	return rw.state.CompareAndSwap(0, 1)
+}
+
+// TryLock tries to lock rw for writing and reports whether it
+// succeeded. It never spins: a single attempt is made and rw is
+// left untouched on failure. A successful TryLock must be released
+// with Unlock or Downgrade.
+func (rw *RW) TryLock() bool {
+	if raceEnabled {
+		raceDisable()
+	}
+	ok := rw.state.CompareAndSwap(0, 1)
+	if ok {
+		rw.seq.Add(1)
+		rw.dbg.locked()
+		rw.holdBegin()
+	}
+	if raceEnabled {
+		raceEnable()
+		if ok {
+			rw.raceAcquireWrite()
+		}
+	}
+	if ok {
+		rw.counted(Write, 1, false)
+		rw.dbg.hold(1)
+	}
+	return ok
+}
+
+// Unlock unlocks rw. It panics if rw is not write-locked on entry,
+// rather than corrupt rw's state and leave it to deadlock later, unless
+// rw was made by New with WithoutUnlockChecks.
+//
+// Readers that arrived while rw was write-locked counted themselves
+// in rw before they began to wait, so Unlock admits them as one batch:
+// clearing the writer bit lets them all in at once, and it wakes the
+// parked ones together. None of them writes to rw again to get in,
+// so a writer's release does not set off a stampede on rw's cache
+// line. Readers held back by WithWriterPreference are the exception:
+// they count themselves only once no writer is waiting. Under
+// WithAlternation, Unlock counts in the readers it held back itself.
 func (rw *RW) Unlock() {
-	atomic.AddInt64((*int64)(rw), -1)
+	rw.unlock(1)
+}
+
+// abort unlocks rw for a writer that changed nothing. It restores the
+// sequence number rather than advance it, so that stamps taken before
+// the write lock still validate and Version does not change.
+func (rw *RW) abort() {
+	rw.unlock(^uint64(0))
+}
+
+// unlock unlocks rw, adding seq to its sequence number.
+func (rw *RW) unlock(seq uint64) {
+	if rw.state.Load()&1 == 0 && rw.checksUnlock() {
+		rw.dbg.checkDowngraded()
+		panic("lock: Unlock of unlocked " + rw.named())
+	}
+	rw.dbg.unlocked()
+	rw.holdEnd()
+	if raceEnabled {
+		rw.raceReleaseWrite()
+		raceDisable()
+	}
+	rw.seq.Add(seq)
+	rw.endTurn()
+	rw.leave(-1)
+	rw.readerq.wake()
+	if raceEnabled {
+		raceEnable()
+	}
+	rw.dbg.drop(1)
 }
 
-// Lock locks rw for reading. If there is a concurrent writer
+// RLock locks rw for reading. If there is a concurrent writer
 // the calling goroutine spins until the rw is available for
 // reading.
+//
+// Like Lock, RLock inlines into its callers, where an RW with the
+// default settings adds its reader to rw with a single atomic add, and
+// has the read lock if no writer was in.
+//
+// Whatever its other options and the build tags, every RW takes the
+// read lock with that add, except one made WithLoadFirstRLock, which
+// reads rw first.
 func (rw *RW) RLock() {
-	if atomic.AddInt64((*int64)(rw), 2)&1 != 0 {
-		for atomic.LoadInt64((*int64)(rw))&1 != 0 {
+	if fastPaths && rw.cfg == nil && rw.state.Add(2)&(1|closedBit) == 0 {
+		return
+	}
+	rw.rlockDefault()
+}
+
+// rlockDefault locks rw for reading as RLock does, after the fast path
+// of RLock failed or was not taken.
+func (rw *RW) rlockDefault() {
+	if fastPaths && rw.cfg == nil {
+		// The fast path added the reader, which now waits for
+		// the writer as any reader that added itself does.
+		s := spinner{}
+		if !rw.rlockSlow(&s, 1) {
+			rw.panicClosed()
+		}
+		return
+	}
+	if rw.cfg != nil && rw.cfg.loadFirst && rw.rlockLoadFirst() {
+		return
+	}
+	s := spinner{cfg: rw.cfg}
+	if !rw.rlock(&s, 1) {
+		rw.panicClosed()
+	}
+}
+
+// rlockLoadFirst makes the single attempt that an RW made
+// WithLoadFirstRLock starts a read lock with, and reports whether it
+// got the lock.
+func (rw *RW) rlockLoadFirst() bool {
+	rw.dbg.checkOrder()
+	if raceEnabled {
+		raceDisable()
+	}
+	ok := rw.tryRead()
+	if raceEnabled {
+		raceEnable()
+		if ok {
+			rw.raceAcquireRead()
+		}
+	}
+	if ok {
+		rw.counted(Read, 1, false)
+		rw.dbg.hold(1)
+		if debug {
+			rw.checkMaxReaders(1)
+		}
+	}
+	return ok
+}
+
+// RLockCounting locks rw for reading like RLock and returns the number
+// of failed attempts it made first, zero if no writer was in the way.
+func (rw *RW) RLockCounting() (spins uint64) {
+	s := spinner{cfg: rw.cfg}
+	if !rw.rlock(&s, 1) {
+		rw.panicClosed()
+	}
+	return uint64(s.n)
+}
+
+// RLockUnlessWriterWaiting locks rw for reading like RLock, unless a
+// writer is waiting for rw, in which case it returns false at once and
+// the caller holds nothing. It lets read-heavy code give way to an
+// occasional writer, bounding its wait, without making every reader of
+// rw do so as WithWriterPreference does. Only writers waiting when it
+// is called count: a writer holding rw is waited for, as by RLock, and
+// the reader is let in after it even if other writers queue meanwhile.
+func (rw *RW) RLockUnlessWriterWaiting() bool {
+	if atomic.LoadInt32(&rw.writers) != 0 {
+		return false
+	}
+	rw.RLock()
+	return true
+}
+
+// tryRead makes one attempt to lock rw for reading, with a CAS only
+// if no writer holds rw, for WithLoadFirstRLock. Unlike the add in
+// rlock, it leaves rw's cache line alone while a writer holds it.
+func (rw *RW) tryRead() bool {
+	a, ok := rw.admit()
+	if !ok {
+		return false
+	}
+	v := rw.state.Load()
+	if v&(1|closedBit) == 0 && rw.state.CompareAndSwap(v, v+2) {
+		return true
+	}
+	rw.unadmit(a)
+	return false
+}
+
+// rlock locks rw for n readers, pacing failed attempts with s, and
+// reports whether it succeeded before s gave up. It adds the readers
+// to rw without looking first, as RLock does unless rw was made
+// WithLoadFirstRLock.
+func (rw *RW) rlock(s *spinner, n int64) bool {
+	rw.dbg.checkOrder()
+	if raceEnabled {
+		raceDisable()
+	}
+	var ok bool
+	if rw.alternates() {
+		ok = rw.rlockTurn(s, n)
+	} else {
+		a, admitted := rw.admitReader(s)
+		ok = admitted && (rw.state.Add(2*n)&(1|closedBit) == 0 || rw.rlockSlow(s, n))
+		if admitted && !ok {
+			rw.unadmit(a)
+		}
+	}
+	if raceEnabled {
+		raceEnable()
+		if ok {
+			rw.raceAcquireRead()
+		}
+	}
+	if ok {
+		s.acquired(Read)
+		rw.counted(Read, n, s.n > 0)
+		rw.dbg.hold(int(n))
+		if debug {
+			rw.checkMaxReaders(n)
+		}
+	}
+	return ok
+}
+
+// admitReader waits until rw admits a new reader, which it always
+// does unless it holds back readers for a waiting writer, and returns
+// the places admit took for it. It reports whether the reader was
+// admitted before s gave up.
+func (rw *RW) admitReader(s *spinner) (admission, bool) {
+	for {
+		if a, ok := rw.admit(); ok {
+			return a, true
+		}
+		if !s.spin() {
+			return 0, false
+		}
+	}
+}
+
+// rlockTurn locks rw for n readers when rw alternates turns, pacing
+// failed attempts with s, and reports whether it succeeded before s
+// gave up. While a writer holds rw or waits for it, the readers are
+// held back for the read turn that follows the write turn, and the
+// writer that ends the turn counts them in on their behalf. If the
+// write turn they wait for never comes, because every waiting writer
+// gave up, they take themselves off and count themselves in.
+func (rw *RW) rlockTurn(s *spinner, n int64) bool {
+	t := &rw.cfg.turn
+	var h uint64
+	for {
+		if atomic.LoadInt32(&rw.writers) == 0 && rw.writerGone() {
+			return rw.state.Add(2*n)&(1|closedBit) == 0 || rw.rlockSlow(s, n)
+		}
+		h = t.Load()
+		if t.CompareAndSwap(h, h+uint64(n)) {
+			break
+		}
+	}
+	for {
+		v := t.Load()
+		if v>>32 != h>>32 {
+			// Counted in by the writer that ended the turn,
+			// which may not have left yet.
+			return rw.rlockSlow(s, n)
+		}
+		idle := atomic.LoadInt32(&rw.writers) == 0 && rw.writerGone()
+		if idle || !s.spin() {
+			if !t.CompareAndSwap(v, v-uint64(n)) {
+				continue
+			}
+			return idle && (rw.state.Add(2*n)&(1|closedBit) == 0 || rw.rlockSlow(s, n))
+		}
+	}
+}
+
+// endTurn ends a write turn on an RW that alternates turns, by counting
+// the readers held back during it in rw before the writer releases it.
+func (rw *RW) endTurn() {
+	if !rw.alternates() {
+		return
+	}
+	t := &rw.cfg.turn
+	for {
+		h := t.Load()
+		n := int64(uint32(h))
+		if n == 0 {
+			return
 		}
+		// The readers must be in rw before they learn that they
+		// are, or one of them could leave it idle under another.
+		rw.state.Add(2 * n)
+		if t.CompareAndSwap(h, (h>>32+1)<<32) {
+			return
+		}
+		rw.state.Add(-2 * n)
+	}
+}
+
+// rlockSlow spins until the writer holding rw releases it, after n
+// readers have added themselves to rw. If s gives up first, or rw is
+// closed, they are removed again.
+func (rw *RW) rlockSlow(s *spinner, n int64) bool {
+	for {
+		v := rw.state.Load()
+		if v&closedBit != 0 {
+			rw.leave(-2 * n)
+			return false
+		}
+		if v&1 == 0 {
+			return true
+		}
+		if s.parks() {
+			rw.readerq.park(s, rw.writerGoneOrClosed)
+		} else if !s.spin() {
+			rw.leave(-2 * n)
+			return false
+		}
+	}
+}
+
+// writerGoneOrClosed reports whether a reader parked in rlockSlow
+// should look at rw again.
+func (rw *RW) writerGoneOrClosed() bool {
+	v := rw.state.Load()
+	return v&1 == 0 || v&closedBit != 0
+}
+
+// writerGone reports whether no writer holds rw, letting in a reader
+// that has already added itself.
+func (rw *RW) writerGone() bool {
+	return rw.state.Load()&1 == 0
+}
+
+// TryRLock tries to lock rw for reading and reports whether it
+// succeeded. Unlike RLock, it never adds to rw speculatively: the
+// read lock is taken with a CAS only when no writer holds rw (or,
+// if rw prefers writers, waits for it), and rw is left untouched
+// on failure.
+func (rw *RW) TryRLock() bool {
+	if raceEnabled {
+		raceDisable()
 	}
+	ok := rw.tryRLock()
+	if raceEnabled {
+		raceEnable()
+		if ok {
+			rw.raceAcquireRead()
+		}
+	}
+	if ok {
+		rw.counted(Read, 1, false)
+		rw.dbg.hold(1)
+		if debug {
+			rw.checkMaxReaders(1)
+		}
+	}
+	return ok
+}
+
+// checkMaxReaders panics if locking rw for n more readers took it over
+// the limit set by WithMaxReaders, after releasing them again.
+func (rw *RW) checkMaxReaders(n int64) {
+	if rw.cfg == nil || rw.cfg.maxReaders == 0 || readersOf(rw.state.Load()) <= rw.cfg.maxReaders {
+		return
+	}
+	rw.runlock(n)
+	panic("lock: RLock of " + rw.named() + " over the maximum readers set by WithMaxReaders")
 }
 
-// Unlock unlocks rw for reading. The operation is undefined if
-// the read lock isn't held.
+func (rw *RW) tryRLock() bool {
+	a, ok := rw.admit()
+	if !ok {
+		return false
+	}
+	for {
+		v := rw.state.Load()
+		if v&(1|closedBit) != 0 {
+			rw.unadmit(a)
+			return false
+		}
+		if rw.state.CompareAndSwap(v, v+2) {
+			return true
+		}
+	}
+}
+
+// RUnlock unlocks rw for reading. It panics if rw has no readers on
+// entry, as Unlock does if it has no writer.
 func (rw *RW) RUnlock() {
-	atomic.AddInt64((*int64)(rw), -2)
+	rw.runlock(1)
+}
+
+// runlock unlocks rw for n readers.
+func (rw *RW) runlock(n int64) {
+	if raceEnabled {
+		rw.raceReleaseRead()
+		raceDisable()
+	}
+	v := rw.leave(-2 * n)
+	if raceEnabled {
+		raceEnable()
+	}
+	if v&underflowBit != 0 && rw.checksUnlock() {
+		rw.state.Add(2 * n)
+		panic("lock: RUnlock of unlocked " + rw.named())
+	}
+	rw.dbg.rUnlocked()
+	rw.dbg.drop(int(n))
+}
+
+// leave adds delta to rw's state on release. If that leaves rw idle,
+// it wakes the parked writers, notifies Notify's channel and drops the
+// nudges of OnWriterWaiting. It returns the new state.
+func (rw *RW) leave(delta int64) int64 {
+	v := rw.state.Add(delta)
+	if v&^closedBit == 0 {
+		rw.writerq.wake()
+		if c := rw.idleChan(); c != nil {
+			notify(c)
+		}
+		rw.dropNudges()
+	}
+	return v
 }
 
 // Downgrade transitions rw from a write-locked state to a read-locked
-// state. The caller must hold the write-locked state.
+// state. The caller must hold the write-locked state; lockdebug builds
+// panic if rw is not write-locked. Downgrade releases the write lock as
+// Unlock does: a reader that acquires rw after it, including one that
+// was waiting for the writer, sees everything the writer did before
+// Downgrade.
 //
 // Proper usage:
 //
@@ -75,5 +679,285 @@ func (rw *RW) RUnlock() {
 //  /* release */
 //
 func (rw *RW) Downgrade() {
-	atomic.AddInt64((*int64)(rw), 1)
+	if debug && rw.state.Load()&1 == 0 {
+		panic("lock: Downgrade of " + rw.named() + " not write-locked")
+	}
+	rw.dbg.unlocked()
+	rw.dbg.downgraded()
+	rw.holdEnd()
+	if raceEnabled {
+		rw.raceReleaseWrite()
+		raceDisable()
+	}
+	rw.seq.Add(1)
+	rw.endTurn()
+	rw.state.Add(1)
+	rw.readerq.wake()
+	if raceEnabled {
+		raceEnable()
+	}
+}
+
+// TryDowngrade is a stricter Downgrade, which downgrades rw only from
+// the exact state of a write lock that no reader is waiting for, and
+// reports whether it did. Otherwise rw is left untouched and the caller
+// still holds whatever it held, so a false result points either at
+// readers waiting, which a plain Downgrade would let in, or at an
+// unexpected state, such as a caller that does not hold the write lock.
+func (rw *RW) TryDowngrade() bool {
+	v := rw.state.Load()
+	if v&^closedBit != 1 {
+		return false
+	}
+	// Only a writer is in, and the caller should be it.
+	rw.dbg.checkOwner()
+	if raceEnabled {
+		raceDisable()
+	}
+	// Take the caller's read lock but keep the writer bit until the
+	// release is recorded, so that no reader gets in before it. A
+	// reader that arrived since the Load makes the CAS fail, and the
+	// caller keeps the write lock with nothing else changed.
+	ok := rw.state.CompareAndSwap(v, v+2)
+	if raceEnabled {
+		raceEnable()
+	}
+	if !ok {
+		return false
+	}
+	rw.dbg.unlocked()
+	rw.dbg.downgraded()
+	rw.holdEnd()
+	if raceEnabled {
+		rw.raceReleaseWrite()
+		raceDisable()
+	}
+	rw.seq.Add(1)
+	rw.endTurn()
+	rw.state.Add(-1)
+	rw.readerq.wake()
+	if raceEnabled {
+		raceEnable()
+	}
+	return true
+}
+
+// Upgrade tries to transition rw from a read-locked state to a
+// write-locked state and reports whether it succeeded. Only the
+// sole reader can upgrade, since no writer may coexist with other
+// readers. On failure the caller still holds its read lock and
+// may RUnlock and Lock instead.
+//
+// Two readers must never wait on each other's upgrade: each holds
+// the read lock the other needs released, so a loop retrying
+// Upgrade in both of them deadlocks.
+func (rw *RW) Upgrade() bool {
+	return rw.TryUpgrade(0)
+}
+
+// TryUpgrade is like Upgrade, but spins up to spins times waiting
+// for other readers to release before giving up. As with Upgrade,
+// the caller holds its read lock whenever TryUpgrade returns false.
+func (rw *RW) TryUpgrade(spins int) bool {
+	s := spinner{cfg: rw.cfg, bounded: true, limit: spins}
+	return rw.upgrade(&s)
+}
+
+// upgrade upgrades the caller's read lock to the write lock, pacing
+// failed attempts with s, and reports whether it succeeded before s
+// gave up.
+func (rw *RW) upgrade(s *spinner) bool {
+	if raceEnabled {
+		raceDisable()
+	}
+	ok := rw.state.CompareAndSwap(2, 1)
+	for !ok && !rw.IsClosed() && s.spin() {
+		ok = rw.state.CompareAndSwap(2, 1)
+	}
+	if ok {
+		rw.seq.Add(1)
+		rw.dbg.locked()
+		rw.holdBegin()
+	}
+	if raceEnabled {
+		raceEnable()
+		if ok {
+			rw.raceAcquireWrite()
+		}
+	}
+	if ok {
+		s.acquired(Write)
+		rw.counted(Write, 1, s.n > 0)
+	}
+	return ok
+}
+
+// prefersWriters reports whether rw holds back new readers while a
+// writer is waiting.
+func (rw *RW) prefersWriters() bool {
+	return rw.cfg != nil && (rw.cfg.preferWriters || rw.cfg.alternate)
+}
+
+// An admission records the places a reader that rw admitted took, in
+// the current read group and among the readers let in ahead of the
+// waiting writers, so that it can give them back if it does not get
+// the read lock after all.
+type admission uint8
+
+const (
+	inGroup   admission = 1 << iota // under WithMaxReadGroupSize
+	overtaker                       // under WithWriterStarvationBound
+)
+
+// admit reports whether rw admits a new reader, which it does unless
+// it holds back readers for the writers waiting for it, if there are
+// any, or for the next read group under WithMaxReadGroupSize. A reader
+// it admits is counted into the current read group and, while a writer
+// waits under WithWriterStarvationBound, among the readers let in
+// ahead of it, and must give those places back with unadmit unless it
+// gets the read lock. A reader held back is counted nowhere.
+func (rw *RW) admit() (a admission, ok bool) {
+	c := rw.cfg
+	if c == nil {
+		return 0, true
+	}
+	if c.groupSize != 0 && !c.alternate {
+		if rw.groupClosed() {
+			return 0, false
+		}
+		a |= inGroup
+	}
+	if atomic.LoadInt32(&rw.writers) == 0 {
+		return a, true
+	}
+	if c.preferWriters || c.alternate {
+		rw.unadmit(a)
+		return 0, false
+	}
+	if c.starveBound != 0 {
+		if c.overtakes.Add(1) > c.starveBound {
+			giveBack(&c.overtakes)
+			rw.unadmit(a)
+			return 0, false
+		}
+		a |= overtaker
+	}
+	return a, true
+}
+
+// unadmit gives back the places a reader took when rw admitted it,
+// for a reader that did not get the read lock after all. If the group
+// or count it was in has restarted since, giving back the place only
+// lets one more reader into the new one than its bound.
+func (rw *RW) unadmit(a admission) {
+	if a&inGroup != 0 {
+		giveBack(&rw.cfg.group)
+	}
+	if a&overtaker != 0 {
+		giveBack(&rw.cfg.overtakes)
+	}
+}
+
+// giveBack decrements n unless it is already zero.
+func giveBack(n *atomic.Int64) {
+	for v := n.Load(); v > 0 && !n.CompareAndSwap(v, v-1); v = n.Load() {
+	}
 }
+
+// groupClosed counts a new reader into the current read group under
+// WithMaxReadGroupSize and reports false, or reports true if the group
+// is full. A full group stays closed until its readers have all left
+// and a writer waiting for rw, if there is one, has got in; the reader
+// that finds it so opens the next group.
+func (rw *RW) groupClosed() bool {
+	c := rw.cfg
+	for {
+		g := c.group.Load()
+		if g < c.groupSize {
+			if c.group.CompareAndSwap(g, g+1) {
+				return false
+			}
+			continue
+		}
+		v := rw.state.Load()
+		if readersOf(v) != 0 || v&^closedBit == 0 && atomic.LoadInt32(&rw.writers) != 0 {
+			return true
+		}
+		if c.group.CompareAndSwap(g, 1) {
+			return false
+		}
+	}
+}
+
+// resetOvertakes restarts the count of readers let in ahead of a
+// waiting writer under WithWriterStarvationBound, for a writer that
+// starts waiting with none before it or that has just got in.
+func (rw *RW) resetOvertakes() {
+	if rw.cfg != nil && rw.cfg.starveBound != 0 {
+		rw.cfg.overtakes.Store(0)
+	}
+}
+
+// checksUnlock reports whether an Unlock or RUnlock that finds rw in a
+// state it cannot be in panics, as it does unless WithoutUnlockChecks
+// turned it off outside lockdebug builds.
+func (rw *RW) checksUnlock() bool {
+	return debug || rw.cfg == nil || !rw.cfg.noUnlockCheck
+}
+
+// alternates reports whether rw alternates write turns with read turns.
+func (rw *RW) alternates() bool {
+	return rw.cfg != nil && rw.cfg.alternate
+}
+
+// RLocker returns a sync.Locker that implements the Lock and
+// Unlock methods by calling rw.RLock and rw.RUnlock.
+func (rw *RW) RLocker() sync.Locker {
+	return (*rlocker)(rw)
+}
+
+type rlocker RW
+
+func (r *rlocker) Lock()   { (*RW)(r).RLock() }
+func (r *rlocker) Unlock() { (*RW)(r).RUnlock() }
+
+// The race detector cannot be shown the atomic operations on rw's
+// state, since they would make every reader appear to synchronize with
+// the readers before it. They are hidden from it with raceDisable, and
+// the edges rw really provides are annotated instead, as sync.RWMutex
+// does: readers acquire from the last writer, and writers from the
+// last writer and every reader since. The addresses of rw's state and
+// cfg fields serve as the two synchronization objects.
+
+func (rw *RW) raceAcquireRead() {
+	raceAcquire(unsafe.Pointer(&rw.state))
+}
+
+func (rw *RW) raceAcquireWrite() {
+	raceAcquire(unsafe.Pointer(&rw.state))
+	raceAcquire(unsafe.Pointer(&rw.cfg))
+}
+
+func (rw *RW) raceReleaseRead() {
+	raceReleaseMerge(unsafe.Pointer(&rw.cfg))
+}
+
+// raceReleaseWrite merges rather than replaces what was released before,
+// which the writer acquired, so as to keep the releases of StoreRelease
+// calls made while it held rw.
+func (rw *RW) raceReleaseWrite() {
+	raceReleaseMerge(unsafe.Pointer(&rw.state))
+}
+
+// noCopy may be added to structs which must not be copied
+// after the first use.
+//
+// See https://golang.org/issues/8005#issuecomment-190753527
+// for details.
+//
+// Note that it must not be embedded, due to the Lock and Unlock methods.
+type noCopy struct{}
+
+// Lock is a no-op used by -copylocks checker from `go vet`.
+func (*noCopy) Lock()   {}
+func (*noCopy) Unlock() {}
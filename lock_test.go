@@ -7,11 +7,14 @@
 package lock_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -187,6 +190,54 @@ func BenchmarkMutexUncontended(b *testing.B) {
 	})
 }
 
+// TestInlining checks that the fast paths of Lock and RLock inline
+// into their callers, as their documentation promises.
+func TestInlining(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	out, err := exec.Command(testenv.GoToolPath(t), "build", "-gcflags=-m", ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build -gcflags=-m: %v\n%s", err, out)
+	}
+	inlined := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if i := strings.Index(line, ": can inline "); i >= 0 {
+			inlined[line[i+len(": can inline "):]] = true
+		}
+	}
+	for _, fn := range []string{"Lock", "RLock", "Unlock", "RUnlock"} {
+		if !inlined["(*RW)."+fn] {
+			t.Errorf("(*RW).%s does not inline", fn)
+		}
+	}
+}
+
+// BenchmarkRWUncontended measures an uncontended write lock and read
+// lock of an RW with the default settings, which take the inlined fast
+// paths, and of one made by New, which does not.
+func BenchmarkRWUncontended(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		rw   *RW
+	}{
+		{"Default", new(RW)},
+		{"New", New()},
+	} {
+		rw := bm.rw
+		b.Run(bm.name+"/Lock", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rw.Lock()
+				rw.Unlock()
+			}
+		})
+		b.Run(bm.name+"/RLock", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rw.RLock()
+				rw.RUnlock()
+			}
+		})
+	}
+}
+
 func benchmarkMutex(b *testing.B, slack, work bool) {
 	var mu Mutex
 	if slack {
@@ -278,3 +329,745 @@ func BenchmarkMutexSpin(b *testing.B) {
 		}
 	})
 }
+
+func TestTryLock(t *testing.T) {
+	var rw RW
+	if !rw.TryLock() {
+		t.Fatalf("TryLock failed on idle lock")
+	}
+	if rw.TryLock() {
+		t.Fatalf("TryLock succeeded on write-locked lock")
+	}
+	rw.Unlock()
+	rw.RLock()
+	if rw.TryLock() {
+		t.Fatalf("TryLock succeeded on read-locked lock")
+	}
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.Fatalf("TryLock failed after release")
+	}
+	rw.Downgrade()
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.Fatalf("TryLock failed after downgrade and release")
+	}
+	rw.Unlock()
+}
+
+func TestLockSlow(t *testing.T) {
+	for _, rw := range []*RW{new(RW), New(WithSpinBudget(0))} {
+		if rw.LockSlow() {
+			t.Fatalf("LockSlow of an idle lock reported spinning")
+		}
+		rw.Unlock()
+		locked := make(chan bool)
+		go func() {
+			rw.Lock()
+			locked <- true
+			time.Sleep(10 * time.Millisecond)
+			rw.Unlock()
+		}()
+		<-locked
+		if !rw.LockSlow() {
+			t.Fatalf("LockSlow of a held lock reported no spinning")
+		}
+		rw.Unlock()
+	}
+}
+
+func TestLockCounting(t *testing.T) {
+	var rw RW
+	if n := rw.LockCounting(); n != 0 {
+		t.Fatalf("LockCounting of an idle lock: %d spins, want 0", n)
+	}
+	rw.Unlock()
+	if n := rw.RLockCounting(); n != 0 {
+		t.Fatalf("RLockCounting of an idle lock: %d spins, want 0", n)
+	}
+	if n := rw.RLockCounting(); n != 0 {
+		t.Fatalf("RLockCounting of a read-locked lock: %d spins, want 0", n)
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	for _, tc := range []struct {
+		name string
+		lock func() uint64
+		done func()
+	}{
+		{"LockCounting", rw.LockCounting, rw.Unlock},
+		{"RLockCounting", rw.RLockCounting, rw.RUnlock},
+	} {
+		locked := make(chan bool)
+		go func() {
+			rw.Lock()
+			locked <- true
+			time.Sleep(10 * time.Millisecond)
+			rw.Unlock()
+		}()
+		<-locked
+		if n := tc.lock(); n == 0 {
+			t.Fatalf("%s of a write-locked lock counted no spins", tc.name)
+		}
+		tc.done()
+	}
+}
+
+func TestTryRLock(t *testing.T) {
+	var rw RW
+	if !rw.TryRLock() {
+		t.Fatalf("TryRLock failed on idle lock")
+	}
+	if !rw.TryRLock() {
+		t.Fatalf("TryRLock failed on read-locked lock")
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	rw.Lock()
+	if rw.TryRLock() {
+		t.Fatalf("TryRLock succeeded on write-locked lock")
+	}
+	rw.Unlock()
+	if !rw.TryLock() {
+		t.Fatalf("failed TryRLock left the lock held")
+	}
+	rw.Unlock()
+}
+
+func TestUpgrade(t *testing.T) {
+	var rw RW
+	rw.RLock()
+	rw.RLock()
+	if rw.Upgrade() {
+		t.Fatalf("Upgrade succeeded with two readers")
+	}
+	rw.RUnlock()
+	if !rw.Upgrade() {
+		t.Fatalf("Upgrade failed for the sole reader")
+	}
+	if rw.TryRLock() {
+		t.Fatalf("TryRLock succeeded after Upgrade")
+	}
+	rw.Downgrade()
+	if !rw.Upgrade() {
+		t.Fatalf("Upgrade failed after Downgrade")
+	}
+	rw.Unlock()
+	if !rw.TryLock() {
+		t.Fatalf("lock not idle after Upgrade and Unlock")
+	}
+	rw.Unlock()
+}
+
+func TestTryUpgrade(t *testing.T) {
+	const n = 8
+	var (
+		rw      RW
+		won     int32
+		ready   sync.WaitGroup
+		losers  sync.WaitGroup
+		results = make(chan bool, n)
+	)
+	ready.Add(n)
+	losers.Add(n - 1)
+	for i := 0; i < n; i++ {
+		spins := 1000
+		if i == n-1 {
+			// One reader outlasts the others so that someone wins.
+			spins = 1 << 24
+		}
+		go func(spins int) {
+			rw.RLock()
+			ready.Done()
+			ready.Wait()
+			if rw.TryUpgrade(spins) {
+				atomic.AddInt32(&won, 1)
+				losers.Wait()
+				rw.Unlock()
+				results <- true
+				return
+			}
+			rw.RUnlock()
+			losers.Done()
+			results <- false
+		}(spins)
+	}
+	for i := 0; i < n; i++ {
+		<-results
+	}
+	if won != 1 {
+		t.Fatalf("%d readers upgraded, want 1", won)
+	}
+	if !rw.TryLock() {
+		t.Fatalf("lock not idle after all readers finished")
+	}
+	rw.Unlock()
+}
+
+// TestDowngradePublishes checks that readers which get in after a
+// Downgrade see every word the writer wrote before it. Readers learn
+// that a downgrade happened from Version, which the race detector does
+// not see as synchronization, so under -race only the read lock orders
+// their reads after the writes.
+func TestDowngradePublishes(t *testing.T) {
+	const readers = 2
+	n := 10000
+	if testing.Short() {
+		n = 1000
+	}
+	var (
+		rw   RW
+		data [8]uint64
+		stop = make(chan bool)
+		done = make(chan bool)
+	)
+	for r := 0; r < readers; r++ {
+		go func() {
+			defer func() { done <- true }()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				v := rw.Version()
+				rw.RLock()
+				d := data
+				rw.RUnlock()
+				for _, w := range d {
+					if w != d[0] || w < v {
+						t.Errorf("reader after the downgrade of version %d saw %v", v, d)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 1; i <= n; i++ {
+		rw.Lock()
+		for j := range data {
+			data[j] = uint64(i)
+		}
+		rw.Downgrade()
+		rw.RUnlock()
+	}
+	close(stop)
+	for r := 0; r < readers; r++ {
+		<-done
+	}
+}
+
+func TestTryDowngrade(t *testing.T) {
+	var rw RW
+	if rw.TryDowngrade() {
+		t.Fatalf("TryDowngrade succeeded on an idle lock")
+	}
+	rw.RLock()
+	if rw.TryDowngrade() {
+		t.Fatalf("TryDowngrade succeeded on a read-locked lock")
+	}
+	if rw.IsWriteLocked() || rw.ReaderCount() != 1 {
+		t.Fatalf("refused TryDowngrade changed the lock: %v", &rw)
+	}
+	rw.RUnlock()
+
+	rw.Lock()
+	if !rw.TryDowngrade() {
+		t.Fatalf("TryDowngrade of a clean write lock failed")
+	}
+	if rw.TryLock() || !rw.TryRLock() {
+		t.Fatalf("TryDowngrade left %v, want two readers possible", &rw)
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+
+	rw.Lock()
+	done := make(chan bool)
+	go func() {
+		rw.RLock()
+		rw.RUnlock()
+		done <- true
+	}()
+	for rw.ReaderCount() == 0 {
+		runtime.Gosched()
+	}
+	if rw.TryDowngrade() {
+		t.Fatalf("TryDowngrade succeeded with a reader waiting")
+	}
+	if !rw.IsWriteLocked() {
+		t.Fatalf("refused TryDowngrade released the write lock")
+	}
+	rw.Unlock()
+	<-done
+	if !rw.TryLock() {
+		t.Fatalf("lock not idle after TryDowngrade tests")
+	}
+	rw.Unlock()
+}
+
+func TestLockerCond(t *testing.T) {
+	var (
+		rw    RW
+		ready bool
+		c     = sync.NewCond(&rw)
+		done  = make(chan bool)
+	)
+	go func() {
+		rw.Lock()
+		for !ready {
+			c.Wait()
+		}
+		rw.Unlock()
+		done <- true
+	}()
+	rw.Lock()
+	ready = true
+	c.Signal()
+	rw.Unlock()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("waiter not woken through sync.Cond")
+	}
+}
+
+func TestRLocker(t *testing.T) {
+	var rw RW
+	l := rw.RLocker()
+	l.Lock()
+	l.Lock()
+	if rw.TryLock() {
+		t.Fatalf("TryLock succeeded while read-locked through RLocker")
+	}
+	if !rw.TryRLock() {
+		t.Fatalf("TryRLock failed while read-locked through RLocker")
+	}
+	rw.RUnlock()
+	l.Unlock()
+	l.Unlock()
+	if !rw.TryLock() {
+		t.Fatalf("lock not idle after RLocker released")
+	}
+	rw.Unlock()
+	if n := testing.AllocsPerRun(100, func() { rw.RLocker() }); n != 0 {
+		t.Fatalf("RLocker allocated %v times, want 0", n)
+	}
+}
+
+func TestIsWriteLocked(t *testing.T) {
+	var rw RW
+	if rw.IsWriteLocked() {
+		t.Fatalf("idle lock reported write-locked")
+	}
+	rw.RLock()
+	if rw.IsWriteLocked() {
+		t.Fatalf("read-locked lock reported write-locked")
+	}
+	rw.RUnlock()
+	rw.Lock()
+	if !rw.IsWriteLocked() {
+		t.Fatalf("write-locked lock not reported write-locked")
+	}
+	rw.Unlock()
+}
+
+func TestReaderCount(t *testing.T) {
+	var rw RW
+	if n := rw.ReaderCount(); n != 0 {
+		t.Fatalf("idle lock has %d readers", n)
+	}
+	rw.Lock()
+	if n := rw.ReaderCount(); n != 0 {
+		t.Fatalf("write-locked lock has %d readers", n)
+	}
+	rw.Downgrade()
+	if n := rw.ReaderCount(); n != 1 {
+		t.Fatalf("downgraded lock has %d readers, want 1", n)
+	}
+	rw.RLock()
+	rw.RLock()
+	if n := rw.ReaderCount(); n != 3 {
+		t.Fatalf("got %d readers, want 3", n)
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	rw.RUnlock()
+	if n := rw.ReaderCount(); n != 0 {
+		t.Fatalf("drained lock has %d readers", n)
+	}
+}
+
+func TestHasPendingWriters(t *testing.T) {
+	var rw RW
+	if rw.HasPendingWriters() {
+		t.Fatalf("idle lock has pending writers")
+	}
+	rw.RLock()
+	locked := make(chan bool)
+	go func() {
+		rw.Lock()
+		locked <- true
+		<-locked
+		rw.Unlock()
+		locked <- true
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !rw.HasPendingWriters() {
+		if time.Now().After(deadline) {
+			t.Fatalf("writer blocked behind a reader not reported as pending")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	rw.RUnlock()
+	<-locked
+	if rw.HasPendingWriters() {
+		t.Fatalf("writer holding the lock still reported as pending")
+	}
+	locked <- true
+	<-locked
+}
+
+func TestRLockUnlessWriterWaiting(t *testing.T) {
+	var rw RW
+	if !rw.RLockUnlessWriterWaiting() {
+		t.Fatalf("RLockUnlessWriterWaiting failed on idle lock")
+	}
+	locked := make(chan bool)
+	go func() {
+		rw.Lock()
+		locked <- true
+		<-locked
+		rw.Unlock()
+		locked <- true
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !rw.HasPendingWriters() {
+		if time.Now().After(deadline) {
+			t.Fatalf("writer blocked behind a reader not reported as pending")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if rw.RLockUnlessWriterWaiting() {
+		t.Fatalf("RLockUnlessWriterWaiting succeeded with a writer waiting")
+	}
+	if n := rw.ReaderCount(); n != 1 {
+		t.Fatalf("refused RLockUnlessWriterWaiting left %d readers, want 1", n)
+	}
+	rw.RUnlock()
+	<-locked
+	// A writer holding the lock is waited for rather than refused.
+	done := make(chan bool)
+	go func() {
+		done <- rw.RLockUnlessWriterWaiting()
+	}()
+	locked <- true
+	if !<-done {
+		t.Fatalf("RLockUnlessWriterWaiting refused behind a writer holding the lock")
+	}
+	<-locked
+	rw.RUnlock()
+}
+
+func TestStats(t *testing.T) {
+	var rw RW
+	if s := rw.Stats(); s != (Stats{}) {
+		t.Fatalf("idle lock: %+v", s)
+	}
+	rw.Lock()
+	if s := rw.Stats(); s != (Stats{WriteHeld: true}) {
+		t.Fatalf("write-locked: %+v", s)
+	}
+	rw.Downgrade()
+	rw.RLock()
+	if s := rw.Stats(); s != (Stats{Readers: 2}) {
+		t.Fatalf("two readers: %+v", s)
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+}
+
+func TestLoadStoreState(t *testing.T) {
+	var rw RW
+	if v := rw.LoadState(); v != 0 {
+		t.Fatalf("LoadState of idle RW = %d, want 0", v)
+	}
+	rw.Lock()
+	locked := rw.LoadState()
+	rw.Unlock()
+	rw.RLock()
+	rw.RLock()
+	read := rw.LoadState()
+	rw.RUnlock()
+	rw.RUnlock()
+	if locked != 1 || read != 4 {
+		t.Fatalf("LoadState = %d write-locked, %d with two readers, want 1, 4", locked, read)
+	}
+
+	rw.UnsafeStoreState(locked)
+	if !rw.IsWriteLocked() || rw.Seq()%2 != 1 || rw.TryRLock() {
+		t.Fatalf("after storing a write lock: %v, Seq %d", &rw, rw.Seq())
+	}
+	rw.Unlock()
+	rw.UnsafeStoreState(read)
+	if rw.ReaderCount() != 2 || rw.Seq()%2 != 0 || rw.TryLock() {
+		t.Fatalf("after storing two readers: %v, Seq %d", &rw, rw.Seq())
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	if v := rw.LoadState(); v != 0 || !rw.TryLock() {
+		t.Fatalf("stored readers released: LoadState = %d, want idle", v)
+	}
+	rw.Unlock()
+}
+
+func TestLoadAcquireStoreRelease(t *testing.T) {
+	var rw RW
+	rw.StoreRelease()
+	if v := rw.LoadAcquire(); v != 0 || !rw.TryLock() {
+		t.Fatalf("StoreRelease changed an idle RW: LoadAcquire = %d, %v", v, &rw)
+	}
+	rw.StoreRelease()
+	if v := rw.LoadAcquire(); v != rw.LoadState() || v != 1 {
+		t.Fatalf("LoadAcquire = %d write-locked, want 1", v)
+	}
+	rw.Downgrade()
+	if v := rw.LoadAcquire(); v != 2 {
+		t.Fatalf("LoadAcquire = %d with one reader, want 2", v)
+	}
+	rw.RUnlock()
+
+	// Data handed through an atomic pointer by a reader, and back by
+	// StoreRelease, is seen by the other side.
+	var p atomic.Pointer[int]
+	x := 1
+	rw.Lock()
+	x = 2
+	rw.Unlock()
+	rw.RLock()
+	p.Store(&x)
+	rw.RUnlock()
+	done := make(chan bool)
+	go func() {
+		rw.LoadAcquire()
+		if *p.Load() != 2 {
+			t.Errorf("lock-free reader saw %d, want 2", *p.Load())
+		}
+		*p.Load() = 3
+		rw.StoreRelease()
+		done <- true
+	}()
+	<-done
+	rw.RLock()
+	if x != 3 {
+		t.Fatalf("reader saw %d after StoreRelease, want 3", x)
+	}
+	rw.RUnlock()
+}
+
+func TestVetCopyLock(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	out, err := exec.Command(testenv.GoToolPath(t), "vet", "./testdata/copylock").CombinedOutput()
+	if err == nil {
+		t.Fatalf("go vet accepted a copied RW:\n%s", out)
+	}
+	if !strings.Contains(string(out), "lock.RW") {
+		t.Fatalf("go vet did not report the copied RW:\n%s", out)
+	}
+}
+
+func TestLockOversubscribed(t *testing.T) {
+	hammerOversubscribed(t, new(RW))
+}
+
+// hammerOversubscribed mixes reads and writes on rw from many more
+// goroutines than processors, yielding while the lock is held, and
+// fails if the waiters never let the holders run.
+func hammerOversubscribed(t *testing.T, rw *RW) {
+	t.Helper()
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	const n, loops = 20, 50
+	done := make(chan bool)
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := 0; j < loops; j++ {
+				rw.Lock()
+				runtime.Gosched()
+				rw.Unlock()
+				rw.RLock()
+				runtime.Gosched()
+				rw.RUnlock()
+			}
+			done <- true
+		}()
+	}
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case <-done:
+		case <-timeout:
+			t.Fatalf("lock handoff stalled with GOMAXPROCS=1")
+		}
+	}
+}
+
+// TestLockHandoffOneProc hands the lock between goroutines sharing a
+// single processor while the holder blocks, which only works if the
+// waiter lets the holder run: on js and wasip1 there is no preemption
+// to interrupt a waiter that spins, even one configured never to yield.
+func TestLockHandoffOneProc(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	for _, rw := range []*RW{new(RW), New(WithYield(false))} {
+		locked := make(chan bool)
+		go func() {
+			rw.Lock()
+			locked <- true
+			time.Sleep(time.Millisecond)
+			rw.Unlock()
+		}()
+		<-locked
+		rw.Lock()
+		rw.Unlock()
+		rw.RLock()
+		rw.RUnlock()
+	}
+}
+
+func TestString(t *testing.T) {
+	var rw RW
+	check := func(want string) {
+		t.Helper()
+		if got := rw.String(); got != want {
+			t.Fatalf("String() = %q, want %q", got, want)
+		}
+		if got := fmt.Sprint(&rw); got != want {
+			t.Fatalf("fmt.Sprint = %q, want %q", got, want)
+		}
+	}
+	check("RW{idle}")
+	rw.Lock()
+	check("RW{write-locked}")
+	rw.Downgrade()
+	check("RW{readers: 1}")
+	rw.RLock()
+	rw.RLock()
+	check("RW{readers: 3}")
+	rw.RUnlock()
+	rw.RUnlock()
+	rw.RUnlock()
+	rw.Lock()
+	done := make(chan bool)
+	go func() {
+		rw.RLock()
+		rw.RUnlock()
+		done <- true
+	}()
+	for rw.ReaderCount() == 0 {
+		runtime.Gosched()
+	}
+	check("RW{write-locked, readers: 1}")
+	rw.Unlock()
+	<-done
+	check("RW{idle}")
+}
+
+func TestWithName(t *testing.T) {
+	var zero RW
+	if got := zero.Name(); got != "" {
+		t.Fatalf("Name() of a zero RW = %q, want none", got)
+	}
+	rw := New(WithName("cache"))
+	if got := rw.Name(); got != "cache" {
+		t.Fatalf("Name() = %q, want %q", got, "cache")
+	}
+	if got, want := rw.String(), `RW("cache"){idle}`; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+	rw.Lock()
+	if got, want := rw.String(), `RW("cache"){write-locked}`; got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+	rw.Unlock()
+	msg := func() (msg string) {
+		defer func() { msg = fmt.Sprint(recover()) }()
+		rw.Unlock()
+		return ""
+	}()
+	if !strings.Contains(msg, `RW("cache")`) {
+		t.Fatalf("Unlock of an unlocked named RW panicked with %q, want the name in it", msg)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	var s struct{ Mu RW }
+	check := func(want string) {
+		t.Helper()
+		b, err := json.Marshal(&s)
+		if err != nil {
+			t.Fatalf("json.Marshal: %v", err)
+		}
+		if got := string(b); got != `{"Mu":"`+want+`"}` {
+			t.Fatalf("json.Marshal = %s, want state %q", got, want)
+		}
+		if text, _ := s.Mu.MarshalText(); string(text) != want {
+			t.Fatalf("MarshalText = %q, want %q", text, want)
+		}
+	}
+	check("idle")
+	s.Mu.Lock()
+	check("write-locked")
+	s.Mu.Downgrade()
+	s.Mu.RLock()
+	check("readers=2")
+	s.Mu.RUnlock()
+	s.Mu.RUnlock()
+	s.Mu.Lock()
+	done := make(chan bool)
+	go func() {
+		s.Mu.RLock()
+		s.Mu.RUnlock()
+		done <- true
+	}()
+	for s.Mu.ReaderCount() == 0 {
+		runtime.Gosched()
+	}
+	check("write-locked,readers=1")
+	s.Mu.Unlock()
+	<-done
+}
+
+func TestUnlockUnlocked(t *testing.T) {
+	var rw RW
+	if !mustPanic(rw.Unlock) {
+		t.Fatalf("Unlock of an idle RW did not panic")
+	}
+	rw.RLock()
+	if !mustPanic(rw.Unlock) {
+		t.Fatalf("Unlock of a read-locked RW did not panic")
+	}
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.Fatalf("RW corrupted by the failed Unlocks")
+	}
+	rw.Unlock()
+}
+
+func TestRUnlockUnlocked(t *testing.T) {
+	var rw RW
+	if !mustPanic(rw.RUnlock) {
+		t.Fatalf("RUnlock of an idle RW did not panic")
+	}
+	rw.RLock()
+	rw.RUnlock()
+	if !mustPanic(rw.RUnlock) {
+		t.Fatalf("RUnlock after the last reader left did not panic")
+	}
+	rw.Lock()
+	if !mustPanic(rw.RUnlock) {
+		t.Fatalf("RUnlock of a write-locked RW did not panic")
+	}
+	rw.Unlock()
+	if !rw.TryLock() {
+		t.Fatalf("RW corrupted by the failed RUnlocks")
+	}
+	rw.Unlock()
+}
@@ -0,0 +1,207 @@
+// Package lockbench drives benchmarks of read/write locks with a
+// configurable mix of reads and writes, so that applications can
+// compare the locks of package lock, and sync.RWMutex, under the
+// conditions they expect rather than the package's own benchmarks.
+//
+// A benchmark passes its *testing.B and a lock to BenchMix, or to the
+// Run method of a Mix:
+//
+//	func BenchmarkCache(b *testing.B) {
+//		lockbench.ReadMostly.Run(b, lock.New(lock.WithWriterPreference()))
+//	}
+//
+// Every goroutine of the benchmark draws each operation at random, a
+// read with probability Reads and otherwise a write, and does a fixed
+// amount of work on shared data while holding the lock and on its own
+// between operations. The lock is acquired through every path it
+// offers: reads and writes that block, try-locks that fall back to
+// blocking when they fail, and writes that downgrade to a read.
+package lockbench
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/as/lock"
+)
+
+// A Locker is a read/write lock that a Mix can drive, such as *lock.RW,
+// *lock.FairRW or *sync.RWMutex. A Locker that also has the methods of
+// TryLocker or Downgrader is acquired through them too, as the Mix
+// says.
+type Locker interface {
+	Lock()
+	Unlock()
+	RLock()
+	RUnlock()
+}
+
+// A TryLocker can try to lock without blocking, as *lock.RW and
+// *sync.RWMutex can.
+type TryLocker interface {
+	TryLock() bool
+	TryRLock() bool
+}
+
+// A Downgrader can turn its write lock into a read lock, as *lock.RW
+// and *lock.FairRW can.
+type Downgrader interface {
+	Downgrade()
+}
+
+// A Mix describes the operations of a benchmark. The zero Mix is all
+// writes with no work either side of them.
+type Mix struct {
+	// Reads is the fraction of operations that read, from 0 to 1.
+	Reads float64
+
+	// Hold is the number of shared words read, or written, while
+	// holding the lock, and Think the number of iterations of local
+	// work between operations.
+	Hold, Think int
+
+	// Try is the fraction of operations that first try to lock
+	// without blocking, on a TryLocker. Downgrades is the fraction
+	// of writes that downgrade and read what they wrote before
+	// releasing, on a Downgrader.
+	Try, Downgrades float64
+}
+
+// Ready-made mixes, from read-mostly data such as configuration to
+// data that is mostly written, such as counters read now and then.
+var (
+	ReadMostly = Mix{Reads: 0.99, Hold: 8, Think: 32, Try: 0.1, Downgrades: 0.5}
+	ReadHeavy  = Mix{Reads: 0.9, Hold: 8, Think: 32, Try: 0.1, Downgrades: 0.5}
+	Balanced   = Mix{Reads: 0.5, Hold: 8, Think: 32, Try: 0.1, Downgrades: 0.5}
+	WriteHeavy = Mix{Reads: 0.1, Hold: 8, Think: 32, Try: 0.1, Downgrades: 0.5}
+)
+
+// BenchMix runs b against rw with readFrac of the operations reads, and
+// the work and acquisition paths of ReadHeavy otherwise.
+func BenchMix(rw *lock.RW, readFrac float64, b *testing.B) {
+	m := ReadHeavy
+	m.Reads = readFrac
+	m.Run(b, rw)
+}
+
+// Run runs b.N operations of m against l, spread over the benchmark's
+// parallel goroutines, and reports the fraction that read as the
+// "reads/op" metric.
+func (m Mix) Run(b *testing.B, l Locker) {
+	tl, _ := l.(TryLocker)
+	dl, _ := l.(Downgrader)
+	rlock := func(try bool) {
+		if !try || !tl.TryRLock() {
+			l.RLock()
+		}
+	}
+	m.run(b, func() (func(bool), func()) { return rlock, l.RUnlock }, func(try bool) {
+		if !try || !tl.TryLock() {
+			l.Lock()
+		}
+	}, l.Unlock, tl != nil, dl)
+}
+
+// RunSharded is like Run for a ShardedRW, whose read locks are not a
+// Locker's. It never tries or downgrades, having no way to.
+func (m Mix) RunSharded(b *testing.B, s *lock.ShardedRW) {
+	// The shard a read lock went to must be kept for its release,
+	// so each goroutine gets its own read closures.
+	m.run(b, func() (rlock func(bool), runlock func()) {
+		var shard int
+		return func(bool) { shard = s.RLock() }, func() { s.RUnlock(shard) }
+	}, func(bool) { s.Lock() }, s.Unlock, false, nil)
+}
+
+// run runs m against b, calling readers once per goroutine for the
+// read lock functions that goroutine uses. Try-locks are only asked
+// for if canTry, and downgrades only if dl is not nil.
+func (m Mix) run(b *testing.B, readers func() (rlock func(try bool), runlock func()), wlock func(try bool), wunlock func(), canTry bool, dl Downgrader) {
+	var (
+		shared [64]uint64 // what the lock protects
+		seed   uint64
+		reads  int64
+		sink   uint64
+	)
+	readBelow := threshold(m.Reads)
+	tryBelow := uint64(0)
+	if canTry {
+		tryBelow = threshold(m.Try)
+	}
+	downBelow := uint64(0)
+	if dl != nil {
+		downBelow = threshold(m.Downgrades)
+	}
+	hold := m.Hold
+	if hold > len(shared) {
+		hold = len(shared)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rlock, runlock := readers()
+		r := rng(atomic.AddUint64(&seed, 1) * 0x9e3779b97f4a7c15)
+		var n int64
+		var local uint64
+		for pb.Next() {
+			try := r.next() < tryBelow
+			if r.next() < readBelow {
+				n++
+				rlock(try)
+				for i := 0; i < hold; i++ {
+					local += shared[i]
+				}
+				runlock()
+			} else {
+				wlock(try)
+				for i := 0; i < hold; i++ {
+					shared[i]++
+				}
+				if r.next() < downBelow {
+					dl.Downgrade()
+					for i := 0; i < hold; i++ {
+						local += shared[i]
+					}
+					runlock()
+				} else {
+					wunlock()
+				}
+			}
+			for i := 0; i < m.Think; i++ {
+				local = local*6364136223846793005 + 1
+			}
+		}
+		atomic.AddInt64(&reads, n)
+		atomic.AddUint64(&sink, local)
+	})
+	b.StopTimer()
+	if b.N > 0 {
+		b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+	}
+}
+
+// threshold returns the value below which a draw of rng falls with
+// probability p.
+func threshold(p float64) uint64 {
+	const max = 1 << 64
+	switch t := p * max; {
+	case t <= 0:
+		return 0
+	case t >= max:
+		return ^uint64(0)
+	default:
+		return uint64(t)
+	}
+}
+
+// rng is a xorshift generator, cheap enough not to distort the lock
+// operations it chooses between.
+type rng uint64
+
+func (r *rng) next() uint64 {
+	x := uint64(*r)
+	x ^= x << 13
+	x ^= x >> 7
+	x ^= x << 17
+	*r = rng(x)
+	return x
+}
@@ -0,0 +1,97 @@
+package lockbench_test
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/as/lock"
+	. "github.com/as/lock/lockbench"
+)
+
+// counted is an RW that counts the ways it is acquired.
+type counted struct {
+	lock.RW
+	locks, rlocks, tries, downgrades int64
+}
+
+func (c *counted) Lock()  { atomic.AddInt64(&c.locks, 1); c.RW.Lock() }
+func (c *counted) RLock() { atomic.AddInt64(&c.rlocks, 1); c.RW.RLock() }
+func (c *counted) TryLock() bool {
+	atomic.AddInt64(&c.tries, 1)
+	if c.RW.TryLock() {
+		atomic.AddInt64(&c.locks, 1)
+		return true
+	}
+	return false
+}
+func (c *counted) TryRLock() bool {
+	atomic.AddInt64(&c.tries, 1)
+	if c.RW.TryRLock() {
+		atomic.AddInt64(&c.rlocks, 1)
+		return true
+	}
+	return false
+}
+func (c *counted) Downgrade() { atomic.AddInt64(&c.downgrades, 1); c.RW.Downgrade() }
+
+func TestMixRun(t *testing.T) {
+	c := new(counted)
+	var n int
+	r := testing.Benchmark(func(b *testing.B) {
+		*c = counted{}
+		n = b.N
+		Balanced.Run(b, c)
+	})
+	if r.N == 0 {
+		t.Fatalf("benchmark did not run")
+	}
+	if got := c.locks + c.rlocks; got != int64(n) {
+		t.Fatalf("%d acquisitions for b.N = %d", got, n)
+	}
+	if frac := float64(c.rlocks) / float64(n); math.Abs(frac-Balanced.Reads) > 0.05 {
+		t.Fatalf("read fraction %.3f, want about %.2f", frac, Balanced.Reads)
+	}
+	if c.tries == 0 || c.downgrades == 0 {
+		t.Fatalf("%d tries and %d downgrades, want some of each", c.tries, c.downgrades)
+	}
+	if got := r.Extra["reads/op"]; math.Abs(got-float64(c.rlocks)/float64(n)) > 1e-9 {
+		t.Fatalf("reads/op metric %v, want %v", got, float64(c.rlocks)/float64(n))
+	}
+	if !c.RW.TryLock() {
+		t.Fatalf("lock still held after the benchmark: %v", &c.RW)
+	}
+}
+
+func TestMixRunSharded(t *testing.T) {
+	s := lock.NewShardedRW(4)
+	testing.Benchmark(func(b *testing.B) {
+		ReadHeavy.RunSharded(b, s)
+	})
+	s.Lock()
+	s.Unlock()
+}
+
+func BenchmarkMix(b *testing.B) {
+	for _, m := range []struct {
+		name string
+		mix  Mix
+	}{
+		{"ReadMostly", ReadMostly},
+		{"ReadHeavy", ReadHeavy},
+		{"Balanced", Balanced},
+		{"WriteHeavy", WriteHeavy},
+	} {
+		b.Run(m.name+"/RW", func(b *testing.B) { m.mix.Run(b, new(lock.RW)) })
+		b.Run(m.name+"/WriterPreference", func(b *testing.B) { m.mix.Run(b, lock.New(lock.WithWriterPreference())) })
+		b.Run(m.name+"/Alternation", func(b *testing.B) { m.mix.Run(b, lock.New(lock.WithAlternation())) })
+		b.Run(m.name+"/FairRW", func(b *testing.B) { m.mix.Run(b, new(lock.FairRW)) })
+		b.Run(m.name+"/ShardedRW", func(b *testing.B) { m.mix.RunSharded(b, lock.NewShardedRW(0)) })
+		b.Run(m.name+"/RWMutex", func(b *testing.B) { m.mix.Run(b, new(sync.RWMutex)) })
+	}
+}
+
+func BenchmarkBenchMix(b *testing.B) {
+	BenchMix(new(lock.RW), 0.75, b)
+}
@@ -0,0 +1,87 @@
+package lock
+
+// LockedMap is a map guarded by an RW. Get, Len, Range and Snapshot
+// take the read lock, so any number of them run concurrently, while
+// Set and Delete take the write lock.
+//
+// The zero value is an empty LockedMap. A LockedMap must not be
+// copied after first use.
+type LockedMap[K comparable, V any] struct {
+	rw RW
+	m  map[K]V
+}
+
+// Get returns the value stored under key, and whether there was one.
+func (m *LockedMap[K, V]) Get(key K) (v V, ok bool) {
+	m.rw.RLock()
+	v, ok = m.m[key]
+	m.rw.RUnlock()
+	return v, ok
+}
+
+// Set stores v under key.
+func (m *LockedMap[K, V]) Set(key K, v V) {
+	m.rw.Lock()
+	if m.m == nil {
+		m.m = make(map[K]V)
+	}
+	m.m[key] = v
+	m.rw.Unlock()
+}
+
+// Delete removes the value stored under key, if any.
+func (m *LockedMap[K, V]) Delete(key K) {
+	m.rw.Lock()
+	delete(m.m, key)
+	m.rw.Unlock()
+}
+
+// Len returns the number of keys in m.
+func (m *LockedMap[K, V]) Len() int {
+	m.rw.RLock()
+	n := len(m.m)
+	m.rw.RUnlock()
+	return n
+}
+
+// A KV is a key and its value, as copied out of a LockedMap by
+// Snapshot.
+type KV[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// Snapshot copies the keys and values of m, in no particular order,
+// into buf's storage and returns them, allocating only if buf is too
+// small. Unlike Range, it holds the read lock just for the copy, so
+// writers are not held up while the caller goes through the entries
+// and the caller may Set and Delete as it does. The entries are those
+// of a single instant: later changes to m do not show in them. Passing
+// the previous result back in as buf keeps repeated snapshots free of
+// allocation once it is large enough.
+func (m *LockedMap[K, V]) Snapshot(buf []KV[K, V]) []KV[K, V] {
+	m.rw.RLock()
+	if n := len(m.m); cap(buf) < n {
+		buf = make([]KV[K, V], 0, n)
+	}
+	buf = buf[:0]
+	for k, v := range m.m {
+		buf = append(buf, KV[K, V]{k, v})
+	}
+	m.rw.RUnlock()
+	return buf
+}
+
+// Range calls fn for each key and value in m, in no particular order,
+// until fn returns false. It holds the read lock throughout, so fn can
+// Get but must not Set or Delete, and writers wait until Range returns.
+// The lock is released even if fn panics.
+func (m *LockedMap[K, V]) Range(fn func(key K, v V) bool) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+	for k, v := range m.m {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
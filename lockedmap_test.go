@@ -0,0 +1,112 @@
+package lock_test
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestLockedMap(t *testing.T) {
+	var m LockedMap[string, int]
+	if _, ok := m.Get("a"); ok {
+		t.Fatalf("Get on an empty map found a value")
+	}
+	m.Delete("a")
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("a", 3)
+	if v, ok := m.Get("a"); !ok || v != 3 {
+		t.Fatalf("Get(a) = %d, %v, want 3, true", v, ok)
+	}
+	if n := m.Len(); n != 2 {
+		t.Fatalf("Len = %d, want 2", n)
+	}
+	sum, calls := 0, 0
+	m.Range(func(k string, v int) bool {
+		sum += v
+		calls++
+		return true
+	})
+	if sum != 5 || calls != 2 {
+		t.Fatalf("Range visited %d entries summing to %d, want 2 and 5", calls, sum)
+	}
+	calls = 0
+	m.Range(func(string, int) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Fatalf("Range continued after fn returned false")
+	}
+	m.Delete("a")
+	if _, ok := m.Get("a"); ok || m.Len() != 1 {
+		t.Fatalf("Delete left the key behind")
+	}
+}
+
+func TestLockedMapSnapshot(t *testing.T) {
+	var m LockedMap[int, int]
+	for i := 0; i < 10; i++ {
+		m.Set(i, i)
+	}
+	snap := m.Snapshot(nil)
+	if len(snap) != 10 {
+		t.Fatalf("Snapshot has %d entries, want 10", len(snap))
+	}
+	for _, kv := range snap {
+		// Mutating while going through a snapshot must neither
+		// deadlock nor change the snapshot.
+		m.Set(kv.Key, -1)
+		m.Delete((kv.Key + 1) % 10)
+		m.Set(kv.Key+100, 0)
+	}
+	for _, kv := range snap {
+		if kv.Value != kv.Key {
+			t.Fatalf("snapshot entry %d changed to %d", kv.Key, kv.Value)
+		}
+	}
+	buf := make([]KV[int, int], 1, m.Len())
+	if snap = m.Snapshot(buf); len(snap) != m.Len() || &snap[0] != &buf[0] {
+		t.Fatalf("Snapshot into a large enough buffer: %d entries in new storage, want %d in buf", len(snap), m.Len())
+	}
+}
+
+func TestLockedMapConcurrent(t *testing.T) {
+	var m LockedMap[int, int]
+	const writers, readers, keys = 4, 4, 100
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for k := w; k < keys; k += writers {
+				m.Set(k, k)
+				if k%2 == 0 {
+					m.Delete(k)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				if v, ok := m.Get(i); ok && v != i {
+					t.Errorf("Get(%d) = %d", i, v)
+				}
+				m.Range(func(k, v int) bool {
+					if k != v {
+						t.Errorf("Range saw %d => %d", k, v)
+					}
+					return true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if n := m.Len(); n != keys/2 {
+		t.Fatalf("Len = %d, want %d", n, keys/2)
+	}
+}
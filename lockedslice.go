@@ -0,0 +1,72 @@
+package lock
+
+// LockedSlice is a slice guarded by an RW. At and Len take the read
+// lock, so any number of them run concurrently, while Append, Truncate
+// and Replace take the write lock.
+//
+// Elements are copied in and out, so that no caller is left holding a
+// reference into the backing array that a later write could change
+// under it; what an element itself points to is shared as usual.
+//
+// The zero value is an empty LockedSlice. A LockedSlice must not be
+// copied after first use.
+type LockedSlice[T any] struct {
+	rw RW
+	s  []T
+}
+
+// Append adds vs to the end of s.
+func (s *LockedSlice[T]) Append(vs ...T) {
+	s.rw.Lock()
+	s.s = append(s.s, vs...)
+	s.rw.Unlock()
+}
+
+// At returns a copy of the element at index i, and whether there was
+// one.
+func (s *LockedSlice[T]) At(i int) (v T, ok bool) {
+	s.rw.RLock()
+	if i >= 0 && i < len(s.s) {
+		v, ok = s.s[i], true
+	}
+	s.rw.RUnlock()
+	return v, ok
+}
+
+// Len returns the number of elements in s.
+func (s *LockedSlice[T]) Len() int {
+	s.rw.RLock()
+	n := len(s.s)
+	s.rw.RUnlock()
+	return n
+}
+
+// Truncate removes every element from index n on, if s has more than
+// n. It panics if n is negative.
+func (s *LockedSlice[T]) Truncate(n int) {
+	if n < 0 {
+		panic("lock: LockedSlice.Truncate with negative length")
+	}
+	s.rw.Lock()
+	if n < len(s.s) {
+		// Zero the removed elements, so that they do not keep
+		// what they point to alive.
+		var zero T
+		for i := n; i < len(s.s); i++ {
+			s.s[i] = zero
+		}
+		s.s = s.s[:n]
+	}
+	s.rw.Unlock()
+}
+
+// Replace replaces the elements of s with those fn returns, given the
+// current ones, under the write lock, for changes that Append and
+// Truncate cannot make. fn may modify the slice it is given in place
+// and return it, or return another, but must not keep either once it
+// returns. The lock is released even if fn panics.
+func (s *LockedSlice[T]) Replace(fn func([]T) []T) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+	s.s = fn(s.s)
+}
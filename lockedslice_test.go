@@ -0,0 +1,109 @@
+package lock_test
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestLockedSlice(t *testing.T) {
+	var s LockedSlice[int]
+	if _, ok := s.At(0); ok || s.Len() != 0 {
+		t.Fatalf("empty slice has an element")
+	}
+	s.Append(1, 2, 3)
+	s.Append(4)
+	if v, ok := s.At(3); !ok || v != 4 {
+		t.Fatalf("At(3) = %d, %v, want 4, true", v, ok)
+	}
+	if _, ok := s.At(-1); ok {
+		t.Fatalf("At(-1) found an element")
+	}
+	if _, ok := s.At(4); ok {
+		t.Fatalf("At(4) found an element past the end")
+	}
+	s.Truncate(10)
+	if n := s.Len(); n != 4 {
+		t.Fatalf("Truncate past the end changed Len to %d", n)
+	}
+	s.Truncate(2)
+	if _, ok := s.At(2); ok || s.Len() != 2 {
+		t.Fatalf("Truncate(2) left %d elements", s.Len())
+	}
+	s.Replace(func(v []int) []int {
+		for i := range v {
+			v[i] *= 10
+		}
+		return append(v, 30)
+	})
+	for i, want := range []int{10, 20, 30} {
+		if v, ok := s.At(i); !ok || v != want {
+			t.Fatalf("after Replace, At(%d) = %d, %v, want %d, true", i, v, ok, want)
+		}
+	}
+	if !mustPanic(func() { s.Truncate(-1) }) {
+		t.Fatalf("Truncate(-1) did not panic")
+	}
+	if !mustPanic(func() { s.Replace(func([]int) []int { panic("fn") }) }) {
+		t.Fatalf("panic in Replace not propagated")
+	}
+	s.Append(40) // the lock was released
+}
+
+func TestLockedSliceCopies(t *testing.T) {
+	var s LockedSlice[[2]int]
+	s.Append([2]int{1, 2})
+	v, _ := s.At(0)
+	v[0] = 99
+	if w, _ := s.At(0); w[0] != 1 {
+		t.Fatalf("changing the result of At changed the element to %v", w)
+	}
+	s.Replace(func(e [][2]int) [][2]int {
+		e[0][0] = 3
+		return e
+	})
+	if v[0] != 99 {
+		t.Fatalf("Replace changed an element copied out by At")
+	}
+}
+
+func TestLockedSliceConcurrent(t *testing.T) {
+	const readers, appends = 4, 1000
+	var (
+		s  LockedSlice[int]
+		wg sync.WaitGroup
+	)
+	s.Append(0)
+	stop := make(chan bool)
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Elements hold their index, whatever the appends
+				// do to the backing array meanwhile.
+				n := s.Len()
+				for _, i := range []int{0, n / 2, n - 1} {
+					if v, ok := s.At(i); !ok || v != i {
+						t.Errorf("At(%d) = %d, %v during appends, want %d, true", i, v, ok, i)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 1; i < appends; i++ {
+		s.Append(i)
+	}
+	close(stop)
+	wg.Wait()
+	if n := s.Len(); n != appends {
+		t.Fatalf("Len = %d after %d appends", n, appends)
+	}
+}
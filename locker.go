@@ -0,0 +1,81 @@
+package lock
+
+import "sync"
+
+// A Locker is a downgradeable read/write lock with the core methods of
+// RW, for code that should run against other implementations too, such
+// as a Deterministic lock in simulation tests. *RW satisfies it.
+type Locker interface {
+	Lock()
+	Unlock()
+	TryLock() bool
+	RLock()
+	RUnlock()
+	TryRLock() bool
+	Downgrade()
+	Upgrade() bool
+}
+
+var _ Locker = (*RW)(nil)
+
+// rwMutex is the method set of sync.RWMutex, which RW's includes.
+type rwMutex interface {
+	Lock()
+	Unlock()
+	TryLock() bool
+	RLock()
+	RUnlock()
+	TryRLock() bool
+	RLocker() sync.Locker
+}
+
+var (
+	_ rwMutex = (*sync.RWMutex)(nil)
+	_ rwMutex = (*RW)(nil)
+)
+
+// FromStdRWMutex returns a Locker that locks mu, so that code written
+// against Locker can run on objects still guarded by a sync.RWMutex
+// while a program moves to RW one subsystem at a time. Every method
+// but two is mu's own.
+//
+// Downgrade is emulated with mu.Unlock followed by mu.RLock, and is
+// not atomic: in the gap between the two, another writer may get in
+// and change what the caller just wrote, so code that relies on
+// reading back its own write after a Downgrade is only correct on a
+// real RW. Upgrade always fails, as the Locker contract allows, leaving
+// the caller's read lock held; callers fall back to RUnlock and Lock,
+// as they must whenever another reader is in.
+func FromStdRWMutex(mu *sync.RWMutex) Locker {
+	return stdRWMutex{mu}
+}
+
+// stdRWMutex is the Locker of FromStdRWMutex.
+type stdRWMutex struct {
+	*sync.RWMutex
+}
+
+func (m stdRWMutex) Downgrade() {
+	m.Unlock()
+	m.RLock()
+}
+
+func (m stdRWMutex) Upgrade() bool { return false }
+
+// NoOp is a Locker that does nothing, for code that takes a Locker
+// but runs where no synchronization is needed, such as on a single
+// goroutine or under a lock the caller already holds. Every lock and
+// try-lock succeeds at once, Upgrade included, and releases are never
+// checked. It takes up no space.
+type NoOp struct{}
+
+var _ Locker = NoOp{}
+
+func (NoOp) Lock()          {}
+func (NoOp) Unlock()        {}
+func (NoOp) TryLock() bool  { return true }
+func (NoOp) RLock()         {}
+func (NoOp) RUnlock()       {}
+func (NoOp) TryRLock() bool { return true }
+func (NoOp) Downgrade()     {}
+func (NoOp) Upgrade() bool  { return true }
@@ -0,0 +1,221 @@
+package lock_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"unsafe"
+
+	. "github.com/as/lock"
+)
+
+// yielder is a Scheduler that lets nothing else run, for tests that
+// never wait.
+type yielder struct{ t *testing.T }
+
+func (y yielder) Yield() { y.t.Fatalf("Yield called by a goroutine that should not wait") }
+
+func TestLocker(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		l    Locker
+	}{
+		{"RW", new(RW)},
+		{"Deterministic", NewDeterministic(yielder{t})},
+	} {
+		l := tc.l
+		l.Lock()
+		if l.TryLock() || l.TryRLock() {
+			t.Fatalf("%s: try-locked while write-locked", tc.name)
+		}
+		l.Downgrade()
+		if l.TryLock() || !l.TryRLock() {
+			t.Fatalf("%s: downgraded lock did not admit exactly readers", tc.name)
+		}
+		if l.Upgrade() {
+			t.Fatalf("%s: upgraded with another reader in", tc.name)
+		}
+		l.RUnlock()
+		if !l.Upgrade() {
+			t.Fatalf("%s: sole reader failed to upgrade", tc.name)
+		}
+		l.Unlock()
+		l.RLock()
+		l.RUnlock()
+		if !l.TryLock() {
+			t.Fatalf("%s: lock still held", tc.name)
+		}
+		l.Unlock()
+		if !mustPanic(l.Unlock) || !mustPanic(l.RUnlock) {
+			t.Fatalf("%s: unlock of an idle lock did not panic", tc.name)
+		}
+	}
+}
+
+func TestFromStdRWMutex(t *testing.T) {
+	var mu sync.RWMutex
+	l := FromStdRWMutex(&mu)
+	l.Lock()
+	if l.TryLock() || l.TryRLock() || mu.TryRLock() {
+		t.Fatalf("try-locked while write-locked")
+	}
+	l.Downgrade()
+	if mu.TryLock() {
+		t.Fatalf("write-locked the mutex after Downgrade")
+	}
+	if !l.TryRLock() {
+		t.Fatalf("downgraded lock did not admit readers")
+	}
+	l.RUnlock()
+	if l.Upgrade() {
+		t.Fatalf("Upgrade of a sync.RWMutex succeeded")
+	}
+	if mu.TryLock() {
+		t.Fatalf("failed Upgrade released the read lock")
+	}
+	l.RUnlock()
+
+	// The mutex is shared with code that uses it directly.
+	mu.Lock()
+	if l.TryRLock() {
+		t.Fatalf("adapter read-locked a mutex locked directly")
+	}
+	mu.Unlock()
+	l.RLock()
+	l.RUnlock()
+	if !mu.TryLock() {
+		t.Fatalf("mutex still held after the adapter released it")
+	}
+	mu.Unlock()
+
+	// A writer waiting when Downgrade releases the write lock may get
+	// in before the read lock is taken back, but no other state than
+	// the two is ever seen: the caller sees either its own write or,
+	// after the gap, a later one.
+	var v int
+	l.Lock()
+	v = 1
+	done := make(chan bool)
+	go func() {
+		l.Lock()
+		v = 2
+		l.Unlock()
+		done <- true
+	}()
+	l.Downgrade()
+	if v != 1 && v != 2 {
+		t.Fatalf("read %d after Downgrade", v)
+	}
+	l.RUnlock()
+	<-done
+}
+
+func TestNoOp(t *testing.T) {
+	var l Locker = NoOp{}
+	l.Lock()
+	if !l.TryLock() || !l.TryRLock() {
+		t.Fatalf("NoOp try-lock failed")
+	}
+	l.Downgrade()
+	if !l.Upgrade() {
+		t.Fatalf("NoOp upgrade failed")
+	}
+	l.Unlock()
+	l.RLock()
+	l.RUnlock()
+	l.RUnlock() // never checked
+	if n := testing.AllocsPerRun(100, func() { l.Lock(); l.Unlock() }); n != 0 {
+		t.Fatalf("NoOp allocated %v times per lock, want 0", n)
+	}
+	if s := unsafe.Sizeof(NoOp{}); s != 0 {
+		t.Fatalf("Sizeof(NoOp{}) = %d, want 0", s)
+	}
+}
+
+// rwMutex is the method set of sync.RWMutex, as code migrating from
+// one to an RW uses it.
+type rwMutex interface {
+	Lock()
+	Unlock()
+	TryLock() bool
+	RLock()
+	RUnlock()
+	TryRLock() bool
+	RLocker() sync.Locker
+}
+
+// table is guarded by a sync.RWMutex, or by whatever replaces it.
+type table struct {
+	mu rwMutex
+	m  map[string]int
+}
+
+func (t *table) get(k string) (int, bool) {
+	l := t.mu.RLocker()
+	l.Lock()
+	defer l.Unlock()
+	v, ok := t.m[k]
+	return v, ok
+}
+
+func (t *table) set(k string, v int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.m[k] = v
+}
+
+func TestRWMutexMigration(t *testing.T) {
+	// Every method of sync.RWMutex is a method of RW alike.
+	rt := reflect.TypeOf((*RW)(nil))
+	mt := reflect.TypeOf((*sync.RWMutex)(nil))
+	for i := 0; i < mt.NumMethod(); i++ {
+		m := mt.Method(i)
+		got, ok := rt.MethodByName(m.Name)
+		if !ok {
+			t.Fatalf("RW has no method %s of sync.RWMutex", m.Name)
+		}
+		if got.Type.NumIn() != m.Type.NumIn() || got.Type.NumOut() != m.Type.NumOut() {
+			t.Fatalf("RW.%s is %v, sync.RWMutex.%s is %v", m.Name, got.Type, m.Name, m.Type)
+		}
+		for j := 1; j < m.Type.NumIn(); j++ {
+			if got.Type.In(j) != m.Type.In(j) {
+				t.Fatalf("RW.%s is %v, sync.RWMutex.%s is %v", m.Name, got.Type, m.Name, m.Type)
+			}
+		}
+		for j := 0; j < m.Type.NumOut(); j++ {
+			if got.Type.Out(j) != m.Type.Out(j) {
+				t.Fatalf("RW.%s is %v, sync.RWMutex.%s is %v", m.Name, got.Type, m.Name, m.Type)
+			}
+		}
+	}
+
+	for _, mu := range []rwMutex{new(sync.RWMutex), new(RW)} {
+		tb := &table{mu: mu, m: map[string]int{}}
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					tb.set(fmt.Sprint(i), j)
+					tb.get(fmt.Sprint(i))
+				}
+			}(i)
+		}
+		wg.Wait()
+		for i := 0; i < 4; i++ {
+			if v, ok := tb.get(fmt.Sprint(i)); !ok || v != 99 {
+				t.Fatalf("%T: table[%d] = %d, %v, want 99, true", mu, i, v, ok)
+			}
+		}
+		if !mu.TryLock() {
+			t.Fatalf("%T still held after the table's use", mu)
+		}
+		mu.Unlock()
+		if !mu.TryRLock() {
+			t.Fatalf("%T: TryRLock of an idle lock failed", mu)
+		}
+		mu.RUnlock()
+	}
+}
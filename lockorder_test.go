@@ -0,0 +1,109 @@
+//go:build lockdebug && !race
+
+package lock_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestDebugLockOrder(t *testing.T) {
+	var a, b, c RW
+	a.Lock()
+	b.RLock()
+	b.RUnlock()
+	a.Unlock()
+
+	// The same order again, and a try that cannot block, are fine.
+	a.RLock()
+	b.Lock()
+	b.Unlock()
+	a.RUnlock()
+	b.Lock()
+	if !a.TryLock() {
+		t.Fatalf("TryLock of an idle RW failed")
+	}
+	a.Unlock()
+	b.Unlock()
+
+	b.Lock()
+	if !mustPanic(a.Lock) {
+		t.Fatalf("locking a while holding b, after b while holding a, did not panic")
+	}
+	if !a.TryLock() {
+		t.Fatalf("RW acquired despite the lock order panic")
+	}
+	a.Unlock()
+	b.Unlock()
+
+	// A cycle through a third lock, with each edge from another goroutine.
+	done := make(chan bool)
+	go func() {
+		b.Lock()
+		c.Lock()
+		c.Unlock()
+		b.Unlock()
+		done <- true
+	}()
+	<-done
+	c.Lock()
+	if !mustPanic(a.RLock) {
+		t.Fatalf("locking a while holding c, with a before b before c, did not panic")
+	}
+	c.Unlock()
+}
+
+func TestDebugLockOrderMessage(t *testing.T) {
+	var a, b RW
+	a.Lock()
+	b.Lock()
+	b.Unlock()
+	a.Unlock()
+	b.Lock()
+	defer b.Unlock()
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.Contains(msg, "lock order inversion") || strings.Count(msg, "lockorder_test.go:") != 2 {
+			t.Fatalf("panic does not name both acquisition sites: %q", msg)
+		}
+	}()
+	a.Lock()
+}
+
+func TestDebugTransferRead(t *testing.T) {
+	if !mustPanic(new(RW).TransferRead) {
+		t.Fatalf("TransferRead of an RW nobody holds did not panic")
+	}
+	var a, b, c RW
+	a.RLock()
+	if !mustPanic(a.TransferRead) {
+		t.Fatalf("TransferRead of a read lock the caller already holds did not panic")
+	}
+	taken, done := make(chan bool), make(chan bool)
+	go func() {
+		a.TransferRead()
+		taken <- true
+		// Counted as held here, so this orders a before b.
+		b.Lock()
+		b.Unlock()
+		a.RUnlock()
+		done <- true
+	}()
+	<-taken
+	// No longer counted as held here, so this orders nothing.
+	c.Lock()
+	c.Unlock()
+	<-done
+
+	b.Lock()
+	if !mustPanic(a.Lock) {
+		t.Fatalf("locking a while holding b, after b under a transferred read of a, did not panic")
+	}
+	b.Unlock()
+	c.Lock()
+	a.Lock()
+	a.Unlock()
+	c.Unlock()
+}
@@ -0,0 +1,149 @@
+package lock
+
+import "strconv"
+
+// Mode is the half of an RW that is acquired or held.
+type Mode int
+
+const (
+	Read  Mode = iota // shared, as by RLock
+	Write             // exclusive, as by Lock
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	}
+	return "Mode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// Acquire locks rw in mode m, as Lock does for Write and RLock for
+// Read, for code that chooses the mode at run time. It returns a Guard
+// that releases rw in whichever mode it is held by then:
+//
+//	g := rw.Acquire(lock.Write)
+//	defer g.Unlock()
+func (rw *RW) Acquire(m Mode) Guard {
+	switch m {
+	case Read:
+		rw.RLock()
+	case Write:
+		rw.Lock()
+	default:
+		panic("lock: Acquire with invalid " + m.String())
+	}
+	return Guard{rw: rw, mode: m}
+}
+
+// A Guard is a hold on an RW, as returned by Acquire, that remembers
+// the mode it is held in, so that Unlock releases it the right way
+// after an Upgrade or Downgrade changed it. Its methods take a pointer
+// receiver so that a deferred Unlock sees the mode as of when it runs,
+// and a Guard must not be copied once it is used through them.
+type Guard struct {
+	rw   *RW
+	mode Mode
+}
+
+// Mode returns the mode g holds its RW in.
+func (g *Guard) Mode() Mode {
+	return g.mode
+}
+
+// Unlock releases the RW held by g in the mode it is held in. It
+// panics if g is the zero Guard or was already unlocked.
+func (g *Guard) Unlock() {
+	if g.rw == nil {
+		panic("lock: Unlock of released Guard")
+	}
+	rw := g.rw
+	g.rw = nil
+	rw.Release(g.mode)
+}
+
+// Downgrade turns the write lock held by g into a read lock, as
+// RW.Downgrade does, and does nothing if g holds a read lock already.
+func (g *Guard) Downgrade() {
+	if g.rw == nil {
+		panic("lock: Downgrade of released Guard")
+	}
+	if g.mode == Write {
+		g.rw.Downgrade()
+		g.mode = Read
+	}
+}
+
+// Upgrade tries to turn the read lock held by g into a write lock, as
+// RW.Upgrade does, and reports whether g holds the write lock now. On
+// failure g still holds the read lock. If g holds the write lock
+// already, Upgrade just reports true.
+func (g *Guard) Upgrade() bool {
+	if g.rw == nil {
+		panic("lock: Upgrade of released Guard")
+	}
+	if g.mode == Read {
+		if !g.rw.Upgrade() {
+			return false
+		}
+		g.mode = Write
+	}
+	return true
+}
+
+// TryAcquire tries to lock rw in mode m, as TryLock does for Write and
+// TryRLock for Read, and reports whether it succeeded.
+func (rw *RW) TryAcquire(m Mode) bool {
+	switch m {
+	case Read:
+		return rw.TryRLock()
+	case Write:
+		return rw.TryLock()
+	}
+	panic("lock: TryAcquire with invalid " + m.String())
+}
+
+// Release unlocks rw held in mode m, as Unlock does for Write and
+// RUnlock for Read.
+func (rw *RW) Release(m Mode) {
+	switch m {
+	case Read:
+		rw.RUnlock()
+	case Write:
+		rw.Unlock()
+	default:
+		panic("lock: Release with invalid " + m.String())
+	}
+}
+
+// AcquirePreferRead locks rw for reading and calls needsWrite with the
+// version of the data, as returned by Version, to ask whether the
+// caller must write it after all. If not, the caller holds the read
+// lock and AcquirePreferRead returns Read. If so, it upgrades to the
+// write lock and returns Write, or, when other readers stop it from
+// upgrading, releases the read lock and locks rw for writing instead.
+// Another writer may get in while rw is released, so needsWrite is
+// asked again under the write lock with the new version, and if the
+// write is no longer needed, rw is downgraded and Read returned. The
+// mode returned says whether the caller must Unlock or RUnlock rw.
+//
+// needsWrite runs while rw is held and must not use it.
+func (rw *RW) AcquirePreferRead(needsWrite func(snapshot uint64) bool) Mode {
+	rw.RLock()
+	v := rw.Version()
+	if !needsWrite(v) {
+		return Read
+	}
+	if rw.Upgrade() {
+		return Write
+	}
+	rw.RUnlock()
+	rw.Lock()
+	if now := rw.Version(); now != v && !needsWrite(now) {
+		rw.Downgrade()
+		return Read
+	}
+	return Write
+}
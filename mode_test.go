@@ -0,0 +1,181 @@
+package lock_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestModeString(t *testing.T) {
+	for m, want := range map[Mode]string{Read: "read", Write: "write", Mode(7): "Mode(7)"} {
+		if got := m.String(); got != want {
+			t.Errorf("Mode(%d).String() = %q, want %q", int(m), got, want)
+		}
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	var rw RW
+	for _, m := range []Mode{Read, Write} {
+		rw.Acquire(m)
+		if got := rw.IsWriteLocked(); got != (m == Write) {
+			t.Fatalf("Acquire(%v): IsWriteLocked = %v", m, got)
+		}
+		if rw.TryAcquire(Write) {
+			t.Fatalf("TryAcquire(write) succeeded with rw held for %v", m)
+		}
+		if got := rw.TryAcquire(Read); got != (m == Read) {
+			t.Fatalf("TryAcquire(read) = %v with rw held for %v", got, m)
+		}
+		if m == Read {
+			rw.Release(Read)
+		}
+		rw.Release(m)
+		if !rw.TryAcquire(Write) {
+			t.Fatalf("Release(%v) left rw held", m)
+		}
+		rw.Release(Write)
+	}
+	for name, fn := range map[string]func(){
+		"Acquire":    func() { rw.Acquire(Mode(7)) },
+		"TryAcquire": func() { rw.TryAcquire(Mode(7)) },
+		"Release":    func() { rw.Release(Mode(7)) },
+	} {
+		if !mustPanic(fn) {
+			t.Errorf("%s with an invalid mode did not panic", name)
+		}
+	}
+}
+
+func TestAcquirePreferRead(t *testing.T) {
+	var rw RW
+	held := func(m Mode) {
+		t.Helper()
+		if got := rw.IsWriteLocked(); got != (m == Write) {
+			t.Fatalf("AcquirePreferRead returned %v, but IsWriteLocked = %v", m, got)
+		}
+		rw.Release(m)
+		if !rw.TryLock() {
+			t.Fatalf("lock still held after Release(%v): %v", m, &rw)
+		}
+		rw.Unlock()
+	}
+
+	if m := rw.AcquirePreferRead(func(uint64) bool { return false }); m != Read {
+		t.Fatalf("AcquirePreferRead = %v when no write was needed, want read", m)
+	} else {
+		held(m)
+	}
+	if m := rw.AcquirePreferRead(func(uint64) bool { return true }); m != Write {
+		t.Fatalf("AcquirePreferRead = %v with no other reader, want write", m)
+	} else {
+		held(m)
+	}
+
+	// With another reader in, the upgrade fails and the write lock is
+	// taken afresh, racing a writer that was already waiting for it.
+	entered, leave, wrote := make(chan bool), make(chan bool), make(chan bool)
+	go func() {
+		rw.RLock()
+		entered <- true
+		<-leave
+		rw.RUnlock()
+	}()
+	<-entered
+	var asks []uint64
+	m := rw.AcquirePreferRead(func(v uint64) bool {
+		asks = append(asks, v)
+		if len(asks) == 1 {
+			go func() {
+				rw.Lock()
+				rw.Unlock()
+				wrote <- true
+			}()
+			for rw.Stats().WaitingWriters == 0 {
+				runtime.Gosched()
+			}
+			go func() {
+				time.Sleep(5 * time.Millisecond)
+				close(leave)
+			}()
+		}
+		return v == asks[0]
+	})
+	switch {
+	case len(asks) == 1 && m == Write:
+		// Got the write lock ahead of the other writer.
+	case len(asks) == 2 && asks[1] == asks[0]+1 && m == Read:
+		// The other writer got in first and wrote, so no write
+		// was needed any more.
+	default:
+		t.Fatalf("AcquirePreferRead = %v after asking about versions %v", m, asks)
+	}
+	if got := rw.IsWriteLocked(); got != (m == Write) {
+		t.Fatalf("AcquirePreferRead returned %v, but IsWriteLocked = %v", m, got)
+	}
+	rw.Release(m)
+	<-wrote
+	if !rw.TryLock() {
+		t.Fatalf("lock still held after Release(%v): %v", m, &rw)
+	}
+	rw.Unlock()
+}
+
+func TestGuard(t *testing.T) {
+	var rw RW
+	func() {
+		g := rw.Acquire(Write)
+		defer g.Unlock()
+		if g.Mode() != Write || !rw.IsWriteLocked() {
+			t.Fatalf("Acquire(write) gave a %v guard, rw %v", g.Mode(), &rw)
+		}
+		g.Downgrade()
+		if g.Mode() != Read || rw.IsWriteLocked() || rw.ReaderCount() != 1 {
+			t.Fatalf("guard downgraded to %v, rw %v", g.Mode(), &rw)
+		}
+		g.Downgrade() // already a read lock
+		if rw.ReaderCount() != 1 {
+			t.Fatalf("second Downgrade changed rw: %v", &rw)
+		}
+		if !g.Upgrade() || g.Mode() != Write || !rw.IsWriteLocked() {
+			t.Fatalf("guard upgraded to %v, rw %v", g.Mode(), &rw)
+		}
+		if !g.Upgrade() {
+			t.Fatalf("Upgrade of a write guard failed")
+		}
+		g.Downgrade()
+		// The deferred Unlock must release the read lock.
+	}()
+	if !rw.TryLock() {
+		t.Fatalf("guard left rw held: %v", &rw)
+	}
+	rw.Unlock()
+
+	g := rw.Acquire(Read)
+	rw.RLock() // another reader stops the upgrade
+	if g.Upgrade() || g.Mode() != Read || rw.ReaderCount() != 2 {
+		t.Fatalf("guard upgraded past another reader to %v, rw %v", g.Mode(), &rw)
+	}
+	rw.RUnlock()
+	g.Unlock()
+	if !mustPanic(g.Unlock) {
+		t.Fatalf("second Unlock of a guard did not panic")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("guard left rw held: %v", &rw)
+	}
+	rw.Unlock()
+
+	var zero Guard
+	for name, fn := range map[string]func(){
+		"Unlock":    zero.Unlock,
+		"Downgrade": zero.Downgrade,
+		"Upgrade":   func() { zero.Upgrade() },
+	} {
+		if !mustPanic(fn) {
+			t.Errorf("%s of the zero Guard did not panic", name)
+		}
+	}
+}
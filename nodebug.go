@@ -0,0 +1,23 @@
+//go:build !lockdebug
+
+package lock
+
+const debug = false
+
+type debugState struct{}
+
+func (d *debugState) locked()          {}
+func (d *debugState) unlocked()        {}
+func (d *debugState) checkOwner()      {}
+func (d *debugState) owned() bool      { return true }
+func (d *debugState) checkReentry()    {}
+func (d *debugState) checkOrder()      {}
+func (d *debugState) hold(n int)       {}
+func (d *debugState) drop(n int)       {}
+func (d *debugState) transfer()        {}
+func (d *debugState) downgraded()      {}
+func (d *debugState) rUnlocked()       {}
+func (d *debugState) checkDowngraded() {}
+
+func register(rw *RW)      {}
+func dumpAll() []LockState { return nil }
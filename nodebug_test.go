@@ -0,0 +1,79 @@
+//go:build !lockdebug
+
+package lock_test
+
+import (
+	"strconv"
+	"testing"
+	"unsafe"
+
+	. "github.com/as/lock"
+)
+
+// Like sync.RWMutex, an RW is not associated with a goroutine outside
+// of lockdebug builds, so one goroutine may unlock another's lock.
+func TestForeignUnlock(t *testing.T) {
+	var rw RW
+	rw.Lock()
+	done := make(chan bool)
+	go func() {
+		rw.Unlock()
+		done <- true
+	}()
+	<-done
+	if !rw.TryLock() {
+		t.Fatalf("Unlock from another goroutine left the lock held")
+	}
+	rw.Unlock()
+}
+
+func TestSize64(t *testing.T) {
+	if strconv.IntSize != 64 {
+		t.Skip("the size of an RW is only fixed on 64-bit platforms")
+	}
+	if s := unsafe.Sizeof(RW{}); s != 112 {
+		t.Fatalf("Sizeof(RW{}) = %d, want the documented 112", s)
+	}
+}
+
+// Lockdebug builds allocate to record the locks each goroutine holds,
+// so only other builds can check that a snapshot need not allocate.
+func TestLockedMapSnapshotAllocs(t *testing.T) {
+	var m LockedMap[int, int]
+	for i := 0; i < 10; i++ {
+		m.Set(i, i)
+	}
+	buf := m.Snapshot(nil)
+	if allocs := testing.AllocsPerRun(10, func() { buf = m.Snapshot(buf) }); allocs != 0 {
+		t.Fatalf("Snapshot into a large enough buffer: %v allocations, want 0", allocs)
+	}
+}
+
+// Only lockdebug builds check WithMaxReaders.
+func TestMaxReadersUnchecked(t *testing.T) {
+	rw := New(WithMaxReaders(1))
+	rw.RLockN(2)
+	rw.RUnlockN(2)
+}
+
+func TestWithoutUnlockChecks(t *testing.T) {
+	rw := New(WithoutUnlockChecks())
+	if mustPanic(rw.RUnlock) {
+		t.Fatalf("RUnlock of an idle RW panicked despite WithoutUnlockChecks")
+	}
+	rw.RLock() // repair the count RUnlock took below zero
+	if !rw.TryLock() {
+		t.Fatalf("RW not idle after repairing it: %v", rw)
+	}
+	rw.Unlock()
+	if mustPanic(rw.Unlock) {
+		t.Fatalf("Unlock of an idle RW panicked despite WithoutUnlockChecks")
+	}
+}
+
+func TestDumpAllOff(t *testing.T) {
+	New()
+	if states := DumpAll(); states != nil {
+		t.Fatalf("DumpAll = %v outside lockdebug builds, want nil", states)
+	}
+}
@@ -0,0 +1,50 @@
+//go:build !lockportable
+
+package lock
+
+import _ "unsafe" // for go:linkname
+
+// The runtime semaphore behind sync.Mutex.
+
+//go:linkname runtime_Semacquire sync.runtime_Semacquire
+func runtime_Semacquire(s *uint32)
+
+//go:linkname runtime_Semrelease sync.runtime_Semrelease
+func runtime_Semrelease(s *uint32, handoff bool, skipframes int)
+
+// The P running the calling goroutine, for ShardedRW.
+
+//go:linkname runtime_procPin runtime.procPin
+func runtime_procPin() int
+
+//go:linkname runtime_procUnpin runtime.procUnpin
+func runtime_procUnpin()
+
+// procHint returns the id of the P running the calling goroutine. The
+// goroutine may move to another P as soon as it returns, so the id is
+// only a hint, but goroutines running at the same time get distinct
+// ones.
+func procHint() uint64 {
+	p := runtime_procPin()
+	runtime_procUnpin()
+	return uint64(p)
+}
+
+// sleepSema waits for a token on q's semaphore, if q does not use a
+// futex.
+func (q *waitq) sleepSema() {
+	if p := q.sem.Load(); p != nil {
+		p.acquire()
+		return
+	}
+	runtime_Semacquire(&q.sema)
+}
+
+// postSema posts a token on q's semaphore, if q does not use a futex.
+func (q *waitq) postSema() {
+	if p := q.sem.Load(); p != nil {
+		p.release()
+		return
+	}
+	runtime_Semrelease(&q.sema, false, 0)
+}
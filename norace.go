@@ -0,0 +1,13 @@
+//go:build !race
+
+package lock
+
+import "unsafe"
+
+const raceEnabled = false
+
+func raceAcquire(addr unsafe.Pointer)      {}
+func raceRelease(addr unsafe.Pointer)      {}
+func raceReleaseMerge(addr unsafe.Pointer) {}
+func raceDisable()                         {}
+func raceEnable()                          {}
@@ -0,0 +1,46 @@
+package lock
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Notify returns a channel that receives a value after rw goes idle,
+// for select-based code that would rather try to acquire rw when it
+// has just been released than spin or block on it. Every call returns
+// the same channel. It holds at most one pending notification: releases
+// that leave rw idle while one is pending are coalesced into it, and
+// the releasing goroutine never blocks on the channel.
+//
+// A notification is a hint, not a handoff. By the time it is received,
+// another goroutine may hold rw again, so the receiver must still
+// acquire rw, typically with TryLock or TryRLock, and wait for the next
+// notification if that fails. Releases before the first call to Notify
+// send nothing.
+func (rw *RW) Notify() <-chan struct{} {
+	if c := rw.idleChan(); c != nil {
+		return c
+	}
+	c := make(chan struct{}, 1)
+	if atomic.CompareAndSwapPointer(&rw.idle, nil, *(*unsafe.Pointer)(unsafe.Pointer(&c))) {
+		return c
+	}
+	return rw.idleChan()
+}
+
+// idleChan returns the channel of Notify, or nil if there is none yet.
+// The channel is kept as an unsafe.Pointer so that releasing rw loads
+// it with a single atomic read, which the race detector accepts in the
+// sections where rw hides its own synchronization from it.
+func (rw *RW) idleChan() chan struct{} {
+	p := atomic.LoadPointer(&rw.idle)
+	return *(*chan struct{})(unsafe.Pointer(&p))
+}
+
+// notify sends a notification on c unless one is already pending.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
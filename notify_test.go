@@ -0,0 +1,75 @@
+package lock_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestNotify(t *testing.T) {
+	var rw RW
+	c := rw.Notify()
+	if rw.Notify() != c {
+		t.Fatalf("Notify returned a different channel on the second call")
+	}
+	select {
+	case <-c:
+		t.Fatalf("notification before any release")
+	default:
+	}
+	rw.Lock()
+	rw.Unlock()
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Unlock sent no notification")
+	}
+
+	// Releases pile up into a single notification, and none blocks.
+	for i := 0; i < 10; i++ {
+		rw.Lock()
+		rw.Unlock()
+		rw.RLock()
+		rw.RUnlock()
+	}
+	<-c
+	select {
+	case <-c:
+		t.Fatalf("releases not coalesced into one notification")
+	default:
+	}
+
+	// Only a release that leaves the lock idle notifies.
+	rw.RLock()
+	rw.RLock()
+	rw.RUnlock()
+	select {
+	case <-c:
+		t.Fatalf("notification while a reader still held the lock")
+	default:
+	}
+	rw.RUnlock()
+	<-c
+}
+
+func TestNotifyAcquire(t *testing.T) {
+	var rw RW
+	rw.Lock()
+	acquired := make(chan bool)
+	go func() {
+		c := rw.Notify()
+		for !rw.TryLock() {
+			<-c
+		}
+		rw.Unlock()
+		acquired <- true
+	}()
+	time.Sleep(time.Millisecond)
+	rw.Unlock()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("waiter on Notify never acquired the released lock")
+	}
+}
@@ -0,0 +1,5 @@
+//go:build !js && !wasip1
+
+package lock
+
+const singleThreaded = false
@@ -0,0 +1,56 @@
+package lock
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// nudge is a function registered by OnWriterWaiting, in a stack of
+// them linked through next.
+type nudge struct {
+	fn   func()
+	next *nudge
+}
+
+// OnWriterWaiting registers fn to be called once, by the next writer
+// that has to wait for rw, as it starts waiting. It is meant for a
+// goroutine holding a read lock for a long time, which fn can nudge
+// into finishing early, typically by setting a flag that its loop
+// checks, so that the writer gets in sooner. fn may run on any
+// goroutine, possibly before OnWriterWaiting returns, and must be
+// quick and must not use rw.
+//
+// The nudge is advisory and best-effort. OnWriterWaiting does not know
+// which read lock it was called under: registrations are dropped when
+// rw goes idle, so one made by a reader that has since left is called
+// only if other readers kept rw busy until a writer came, and one made
+// as rw went idle may be dropped unseen. Writers that get rw at their
+// first attempt, and upgrades, call nothing.
+func (rw *RW) OnWriterWaiting(fn func()) {
+	n := &nudge{fn: fn}
+	for {
+		old := atomic.LoadPointer(&rw.nudges)
+		n.next = (*nudge)(old)
+		if atomic.CompareAndSwapPointer(&rw.nudges, old, unsafe.Pointer(n)) {
+			return
+		}
+	}
+}
+
+// writerWaiting calls and drops the functions registered by
+// OnWriterWaiting, for a writer about to wait. It must be called with
+// race instrumentation enabled, so that the detector sees the
+// registration happen before the call.
+func (rw *RW) writerWaiting() {
+	for n := (*nudge)(atomic.SwapPointer(&rw.nudges, nil)); n != nil; n = n.next {
+		n.fn()
+	}
+}
+
+// dropNudges forgets the functions registered by OnWriterWaiting, once
+// rw has gone idle and the readers they were for have left.
+func (rw *RW) dropNudges() {
+	if atomic.LoadPointer(&rw.nudges) != nil {
+		atomic.StorePointer(&rw.nudges, nil)
+	}
+}
@@ -0,0 +1,65 @@
+package lock_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestOnWriterWaiting(t *testing.T) {
+	var rw RW
+	rw.RLock()
+	var nudged atomic.Int32
+	stop := make(chan bool)
+	rw.OnWriterWaiting(func() {
+		nudged.Add(1)
+		close(stop)
+	})
+	if nudged.Load() != 0 {
+		t.Fatalf("nudged with no writer waiting")
+	}
+	done := make(chan bool)
+	go func() {
+		rw.Lock()
+		rw.Unlock()
+		done <- true
+	}()
+
+	// The long reader finishes early once nudged.
+	select {
+	case <-stop:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("reader never nudged by the waiting writer")
+	}
+	rw.RUnlock()
+	<-done
+	rw.Lock()
+	rw.Unlock() // a second writer does not call the nudge again
+	if n := nudged.Load(); n != 1 {
+		t.Fatalf("nudged %d times, want 1", n)
+	}
+}
+
+func TestOnWriterWaitingDropped(t *testing.T) {
+	var rw RW
+	rw.RLock()
+	rw.OnWriterWaiting(func() { t.Errorf("nudge called after its reader left") })
+	rw.RUnlock()
+
+	// rw went idle, so the writer below waiting for a new reader does
+	// not nudge it on behalf of the old one.
+	rw.RLock()
+	done := make(chan bool)
+	go func() {
+		rw.Lock()
+		rw.Unlock()
+		done <- true
+	}()
+	for rw.Stats().WaitingWriters == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	rw.RUnlock()
+	<-done
+}
@@ -0,0 +1,354 @@
+package lock
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// An Option configures an RW created by New.
+type Option func(*config)
+
+// config holds the settings of an RW created by New. An RW with no
+// config, such as the zero value, uses the package defaults.
+type config struct {
+	spinBudget    int
+	yield         bool
+	preferWriters bool
+	backoff       [2]uint32 // min and max pause, or zero for spinCycles
+	observer      func(m Mode, spins int, waited time.Duration)
+	spinHook      func()
+	yieldFunc     func() // or nil for runtime.Gosched
+	loadFirst     bool
+	portablePark  bool
+	futex         bool
+	alternate     bool
+	maxReaders    int64 // or 0 for no limit
+	noUnlockCheck bool
+	hold          *holdTimes     // or nil if not kept
+	counts        *acquireCounts // or nil if not kept
+	starveBound   int64          // or 0 for none
+	jitter        uint32         // most extra hints per pause, or 0
+	name          string         // or "" for none
+	groupSize     int64          // most readers per read group, or 0
+
+	// turn is state rather than a setting, kept here so that only RWs
+	// that alternate turns pay for it: the number of readers held back
+	// for the next read turn in its low half, and the number of write
+	// turns that ended with readers held back in its high half.
+	turn atomic.Uint64
+
+	// overtakes is the number of readers let in ahead of the waiting
+	// writers since the first of them started waiting or the last got
+	// in, under WithWriterStarvationBound.
+	overtakes atomic.Int64
+
+	// group is the number of readers let into the current read group,
+	// under WithMaxReadGroupSize.
+	group atomic.Int64
+}
+
+// New returns an unlocked RW configured by opts. Options not given
+// take the package defaults, which are also what the zero value of
+// RW uses.
+func New(opts ...Option) *RW {
+	c := &config{
+		spinBudget: SpinBudget,
+		yield:      true,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	rw := &RW{cfg: c}
+	register(rw)
+	if c.portablePark {
+		rw.readerq.sem.Store(newSemaphore())
+		rw.writerq.sem.Store(newSemaphore())
+	}
+	if c.futex && futexSupported && !c.portablePark {
+		rw.readerq.futex = true
+		rw.writerq.futex = true
+	}
+	return rw
+}
+
+// WithSpinBudget sets the number of failed attempts a waiter makes
+// before it blocks or starts yielding its processor, overriding
+// SpinBudget. A larger budget favors latency when critical sections
+// are short, a smaller one saves processor time when they are long or
+// goroutines outnumber processors. It is ignored on single-threaded
+// platforms, as SpinBudget is.
+func WithSpinBudget(n int) Option {
+	return func(c *config) {
+		if n < 0 {
+			n = 0
+		}
+		c.spinBudget = n
+	}
+}
+
+// WithYield sets whether a waiter blocks or yields its processor once
+// its spin budget is exhausted. Without yielding, RW is a pure
+// spinlock: waiters keep spinning for as long as the lock is held,
+// which is only safe when holders are never descheduled. Even then a
+// spinning waiter lets the scheduler run something else every few dozen
+// spins, so that a holder it shares a processor with is not left
+// waiting for preemption to finish its critical section. Like the spin
+// budget, it is ignored on single-threaded platforms.
+func WithYield(yield bool) Option {
+	return func(c *config) {
+		c.yield = yield
+	}
+}
+
+// WithPortableParking makes waiters that have spent their spin budget
+// block on a semaphore built from sync.Cond, rather than on the runtime
+// semaphore that sync.Mutex uses, which the package reaches with
+// go:linkname. Spin-then-block behavior is the same either way, but a
+// waiter blocks and wakes through an extra mutex, so handoffs under
+// heavy contention are slower. To keep go:linkname out of the build
+// altogether, for Go versions that may reject it, build with
+// -tags lockportable, which makes every RW park this way.
+func WithPortableParking() Option {
+	return func(c *config) {
+		c.portablePark = true
+	}
+}
+
+// WithFutex makes waiters that have spent their spin budget sleep in
+// the futex system call on Linux, instead of parking on the runtime
+// semaphore, and releases wake them with another. A sleeping waiter
+// holds on to its OS thread, and the runtime starts new threads to run
+// other goroutines, so this suits few waiters on locks held long
+// enough to repay the system calls. On other platforms, and together
+// with WithPortableParking, which takes precedence, it is ignored.
+func WithFutex() Option {
+	return func(c *config) {
+		c.futex = true
+	}
+}
+
+// WithBackoff replaces the fixed pause between failed attempts within
+// the spin budget with one that starts at min spin-wait hints and
+// doubles after every attempt up to max. Longer pauses under heavy
+// contention mean fewer attempts hitting the lock's cache line at
+// once, at the cost of noticing a release later. The first attempt is
+// never delayed. A min below 1 is taken as 1, a max below min as min,
+// and either above 1<<16 as 1<<16.
+func WithBackoff(min, max int) Option {
+	return func(c *config) {
+		min = clampPause(min, 1)
+		max = clampPause(max, min)
+		c.backoff = [2]uint32{uint32(min), uint32(max)}
+	}
+}
+
+// WithJitter adds a pseudo-random number of spin-wait hints, from 0 to
+// max, to every pause between failed attempts within the spin budget,
+// whether fixed or set by WithBackoff. Waiters released at the same
+// moment, such as the readers a writer lets in, otherwise retry in
+// step and collide on the lock's cache line again and again; jitter
+// spreads their attempts out. Each waiter draws from its own generator,
+// and an acquisition that succeeds at its first attempt draws nothing.
+// A max below 1 adds none, and one above 1<<16 adds at most 1<<16.
+func WithJitter(max int) Option {
+	return func(c *config) {
+		c.jitter = uint32(clampPause(max, 0))
+	}
+}
+
+// clampPause returns n spin-wait hints, or lo if n is below it, or
+// maxPause if n is above that.
+func clampPause(n, lo int) int {
+	switch {
+	case n < lo:
+		return lo
+	case n > maxPause:
+		return maxPause
+	}
+	return n
+}
+
+// WithWriterPreference makes the RW hold back new readers while a
+// writer is waiting, instead of letting them in ahead of it. Readers
+// that already hold the lock, or announced themselves before the writer
+// started waiting, finish first, so a waiting writer is delayed by at
+// most one group of readers and cannot be starved by a steady stream of
+// them. The price is read throughput: readers stall whenever a writer
+// waits. A goroutine holding a read lock must not RLock again, as it
+// would deadlock with a writer waiting for it to release.
+func WithWriterPreference() Option {
+	return func(c *config) {
+		c.preferWriters = true
+	}
+}
+
+// WithAlternation makes the RW alternate between write turns and
+// read turns whenever both kinds of goroutine want it. As with
+// WithWriterPreference, new readers are held back while a writer is
+// waiting, so a writer is delayed by at most the read turn in progress.
+// Unlike it, a writer that unlocks or downgrades lets in every reader
+// held back during its turn as one batch, before the next writer can
+// get in, so readers are delayed by at most one write turn however many
+// writers are queued. Reads are still grouped within a turn. Readers
+// held back spin and yield rather than park until their turn. The same
+// rule against a reader that RLocks again applies.
+func WithAlternation() Option {
+	return func(c *config) {
+		c.alternate = true
+	}
+}
+
+// WithWriterStarvationBound makes the RW let in at most k new readers
+// ahead of a waiting writer, and hold back any more until a writer gets
+// in, as WithWriterPreference holds back all of them. Readers keep the
+// throughput of reader priority while writers are rare, and a writer is
+// still delayed by at most k read locks beyond those already held or
+// being taken when it started waiting. Readers that overlap are not
+// grouped: each read lock taken while a writer waits counts once, and
+// RLockN once for all its readers, so the k+1th is held back even if
+// all k are still held. The count starts again whenever a
+// writer gets in, for the next writer in line. The same rule against a
+// reader that RLocks again applies as for WithWriterPreference. A k
+// below 1 sets no bound, and WithWriterPreference and WithAlternation
+// hold back readers regardless.
+func WithWriterStarvationBound(k int) Option {
+	return func(c *config) {
+		if k < 0 {
+			k = 0
+		}
+		c.starveBound = int64(k)
+	}
+}
+
+// WithMaxReadGroupSize makes the RW let readers in by groups of at
+// most n, so that a burst of overlapping readers cannot hold off a
+// writer without end, without holding back every reader for it as
+// WithWriterPreference does. Once n readers have entered the current
+// group it closes: new readers wait until the readers of the group have
+// all left, and, if a writer is waiting, until it has got in, before
+// the next group opens. Readers wait between groups even if no writer
+// is waiting, so n should be well above the number of readers that
+// usually overlap. Each read lock counts once, when it is taken, and
+// RLockN takes a single place for all its readers. A reader must not
+// RLock again while it holds a read lock, or it may wait for its own
+// group. An n below 1
+// sets no limit, and WithAlternation, whose read turns are bounded
+// already, ignores it.
+func WithMaxReadGroupSize(n int) Option {
+	return func(c *config) {
+		if n < 0 {
+			n = 0
+		}
+		c.groupSize = int64(n)
+	}
+}
+
+// WithContentionObserver sets a function called after every acquisition
+// that could not succeed on its first attempt, with the mode acquired,
+// the number of times the waiter spun and how long it waited. It is
+// called by the goroutine that acquired the lock, while holding it, and
+// should be quick. Acquisitions that succeed at once, and ones that give
+// up, are not reported and never read the clock.
+func WithContentionObserver(fn func(m Mode, spins int, waited time.Duration)) Option {
+	return func(c *config) {
+		c.observer = fn
+	}
+}
+
+// WithMaxReaders makes lockdebug builds panic when a read lock would
+// take the number of readers of the RW, as counted by ReaderCount,
+// over n, which catches a reader leaked by a missing RUnlock long
+// before its count could overflow. The reader that went over is not
+// left in. Other builds skip the check, and a limit below 1 sets none.
+func WithMaxReaders(n int) Option {
+	return func(c *config) {
+		if n < 0 {
+			n = 0
+		}
+		c.maxReaders = int64(n)
+	}
+}
+
+// WithoutUnlockChecks stops Unlock and RUnlock from checking that the
+// RW is held, for code that has proved its locking correct and wants
+// the last branch out of its releases. An unlock of an RW not held then
+// corrupts its state, which shows later as a deadlock or as an RW that
+// two goroutines hold at once. Lockdebug builds check regardless.
+func WithoutUnlockChecks() Option {
+	return func(c *config) {
+		c.noUnlockCheck = true
+	}
+}
+
+// WithHoldStats makes the RW time every write lock from acquisition to
+// release and keep the totals that HoldStats reports, for finding
+// critical sections that do too much work under the lock. It costs two
+// reads of the clock per write lock. Read locks are not timed.
+func WithHoldStats() Option {
+	return func(c *config) {
+		c.hold = new(holdTimes)
+	}
+}
+
+// WithCounters makes the RW count its acquisitions, in each mode and
+// those that waited, and keep the lifetime totals that Counters
+// reports, for the acquisition rates of a dashboard. It costs an atomic
+// add per acquisition, and another per acquisition that waits, which
+// is less than WithContentionObserver's call per contended one but,
+// unlike that, is paid by every acquisition.
+func WithCounters() Option {
+	return func(c *config) {
+		c.counts = new(acquireCounts)
+	}
+}
+
+// WithName gives the RW a name that its String, DumpAll and the panics
+// of misuse, such as an Unlock of an RW not held, identify it by, so
+// that diagnostics say which lock is involved rather than where it is
+// in memory. Names need not be unique.
+func WithName(name string) Option {
+	return func(c *config) {
+		c.name = name
+	}
+}
+
+// WithLoadFirstRLock makes RLock read the RW before it takes the read
+// lock, and take it with a CAS if no writer holds it, adding itself to
+// wait as RLock otherwise does only if that fails. A reader arriving
+// while a writer holds the RW then only reads its cache line, rather
+// than take it exclusive, but a CAS takes the line exclusive just as
+// the add does, and fails where the add would not when readers arrive
+// together, so this rarely pays; BenchmarkRLockStrategy compares both.
+// TryRLock always looks first, and RLockN and the acquisitions that
+// may give up never do.
+func WithLoadFirstRLock() Option {
+	return func(c *config) {
+		c.loadFirst = true
+	}
+}
+
+// WithYieldFunc sets a function that waiters call to yield their
+// processor instead of runtime.Gosched, for runtimes where something
+// else lets the holder run, or where a waiter should also do some work
+// of its own while it waits, such as petting a watchdog. It is called
+// wherever a waiter would yield: after every failed attempt once the
+// spin budget is spent, by acquisitions that may give up and so cannot
+// block, and every few dozen spins by waiters still spinning. Waiters
+// that block do not call it while they are parked, and acquisitions
+// that get the lock at once never call it. fn must not use the RW.
+func WithYieldFunc(fn func()) Option {
+	return func(c *config) {
+		c.yieldFunc = fn
+	}
+}
+
+// WithSpinHook sets a function called by a waiter every time it spins,
+// before it pauses and tries again, but not while it is parked. It is
+// meant for tests, which can use it to interleave other goroutines with
+// a waiter at exact points and so reproduce rare schedules, and should
+// not be used in production.
+func WithSpinHook(fn func()) Option {
+	return func(c *config) {
+		c.spinHook = fn
+	}
+}
@@ -0,0 +1,610 @@
+package lock_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestNew(t *testing.T) {
+	rw := New(WithWriterPreference())
+	rw.Lock()
+	rw.Downgrade()
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.Fatalf("lock from New not idle after use")
+	}
+	rw.Unlock()
+	hammerOversubscribed(t, rw)
+}
+
+func TestWithSpinBudget(t *testing.T) {
+	hammerOversubscribed(t, New(WithSpinBudget(0)))
+	hammerOversubscribed(t, New(WithSpinBudget(1000), WithYield(true)))
+}
+
+func TestWithYield(t *testing.T) {
+	rw := New(WithYield(false))
+	locked := make(chan bool)
+	go func() {
+		rw.Lock()
+		locked <- true
+		time.Sleep(time.Millisecond)
+		rw.Unlock()
+	}()
+	<-locked
+	// A pure spinner still acquires the lock once it is released.
+	rw.Lock()
+	rw.Unlock()
+}
+
+func TestWithBackoff(t *testing.T) {
+	hammerOversubscribed(t, New(WithBackoff(1, 1024)))
+	hammerOversubscribed(t, New(WithBackoff(0, -1)))
+}
+
+// BenchmarkBackoff compares a contended lock pausing a fixed time
+// between attempts with one backing off exponentially, for increasing
+// numbers of goroutines per processor.
+func BenchmarkBackoff(b *testing.B) {
+	for _, p := range []int{1, 4, 16} {
+		for _, bm := range []struct {
+			name string
+			opts []Option
+		}{
+			{"Fixed", nil},
+			{"Backoff", []Option{WithBackoff(4, 1024)}},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", bm.name, p), func(b *testing.B) {
+				rw := New(bm.opts...)
+				b.SetParallelism(p)
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						rw.Lock()
+						rw.Unlock()
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	hammerOversubscribed(t, New(WithJitter(64)))
+	hammerOversubscribed(t, New(WithJitter(64), WithBackoff(1, 1024)))
+	hammerOversubscribed(t, New(WithJitter(-1)))
+}
+
+// TestWithJitterHuge checks that a max too large for a pause is
+// clamped, rather than wrapped into pauses of billions of hints.
+func TestWithJitterHuge(t *testing.T) {
+	for _, rw := range []*RW{
+		New(WithJitter(math.MaxInt)),
+		New(WithJitter(math.MaxInt), WithBackoff(math.MaxInt, math.MaxInt)),
+	} {
+		for i := 0; i < 10; i++ {
+			rw.Lock()
+			done := make(chan bool)
+			go func() {
+				rw.Lock()
+				rw.Unlock()
+				done <- true
+			}()
+			time.Sleep(time.Millisecond)
+			rw.Unlock()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("waiter still spinning 10s after Unlock")
+			}
+		}
+	}
+}
+
+// BenchmarkJitter compares a contended lock with and without jitter in
+// its pauses, with many goroutines per processor released together by
+// each writer.
+func BenchmarkJitter(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Fixed", nil},
+		{"Jitter", []Option{WithJitter(32)}},
+		{"Backoff", []Option{WithBackoff(4, 1024)}},
+		{"BackoffJitter", []Option{WithBackoff(4, 1024), WithJitter(32)}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			rw := New(bm.opts...)
+			var n uint64
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if i%8 == 0 {
+						rw.Lock()
+						n++
+						rw.Unlock()
+					} else {
+						rw.RLock()
+						_ = n
+						rw.RUnlock()
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestWithWriterPreference(t *testing.T) {
+	const readers = 4
+	rw := New(WithWriterPreference())
+	var cycles int64
+	stop := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < readers; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					done <- true
+					return
+				default:
+				}
+				rw.RLock()
+				atomic.AddInt64(&cycles, 1)
+				// Yield while holding the read lock, so
+				// that the readers' holds overlap and the
+				// lock never goes idle.
+				runtime.Gosched()
+				rw.RUnlock()
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		for i := 0; i < readers; i++ {
+			<-done
+		}
+	}()
+	for atomic.LoadInt64(&cycles) < 100 {
+		runtime.Gosched()
+	}
+	for i := 0; i < 10; i++ {
+		before := atomic.LoadInt64(&cycles)
+		rw.Lock()
+		// Readers already in flight when the writer started
+		// waiting may finish ahead of it, but no others.
+		if n := atomic.LoadInt64(&cycles) - before; n > 2*readers {
+			t.Fatalf("writer waited for %d read cycles, want at most %d", n, 2*readers)
+		}
+		rw.Unlock()
+		runtime.Gosched()
+	}
+}
+
+func TestWithWriterStarvationBound(t *testing.T) {
+	const readers, k = 4, 16
+	rw := New(WithWriterStarvationBound(k))
+	var cycles int64
+	stop := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < readers; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					done <- true
+					return
+				default:
+				}
+				rw.RLock()
+				atomic.AddInt64(&cycles, 1)
+				runtime.Gosched() // keep the lock from going idle
+				rw.RUnlock()
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		for i := 0; i < readers; i++ {
+			<-done
+		}
+	}()
+	for atomic.LoadInt64(&cycles) < 100 {
+		runtime.Gosched()
+	}
+	for i := 0; i < 10; i++ {
+		waited := make(chan int64)
+		go func() {
+			before := atomic.LoadInt64(&cycles)
+			rw.Lock()
+			n := atomic.LoadInt64(&cycles) - before
+			rw.Unlock()
+			waited <- n
+		}()
+		select {
+		case n := <-waited:
+			// Besides the k readers let past, those in flight
+			// when the writer started waiting may finish ahead.
+			if n > k+2*readers {
+				t.Fatalf("writer waited for %d read cycles, want at most %d", n, k+2*readers)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("writer starved by overlapping readers")
+		}
+		runtime.Gosched()
+	}
+}
+
+// TestWithWriterStarvationBoundReaders lets read locks past a waiting
+// writer one at a time, and checks that the k+1th is held back for it,
+// whether or not the readers before it waited for another writer.
+func TestWithWriterStarvationBoundReaders(t *testing.T) {
+	const k = 3
+	rw := New(WithWriterStarvationBound(k))
+	rw.RLock() // in before the writer, so not counted
+	wrote := make(chan bool)
+	go func() {
+		rw.Lock()
+		rw.Unlock()
+		wrote <- true
+	}()
+	for rw.Stats().WaitingWriters == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < k; i++ {
+		if !rw.TryRLock() {
+			t.Fatalf("read lock %d held back, want %d let past the writer", i+1, k)
+		}
+	}
+	if rw.TryRLock() {
+		t.Fatalf("read lock %d let past the writer, want it held back", k+1)
+	}
+	rw.RUnlockN(k + 1)
+	<-wrote
+
+	// A reader that tried and then waited for the writer holding rw,
+	// with another waiting, takes a single place too.
+	rw.Lock()
+	go func() {
+		rw.Lock()
+		rw.Unlock()
+		wrote <- true
+	}()
+	for rw.Stats().WaitingWriters == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	in := make(chan bool)
+	go func() {
+		rw.RLock()
+		in <- true
+	}()
+	for rw.Stats().Readers == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	rw.Unlock()
+	<-in
+	for i := 1; i < k; i++ {
+		if !rw.TryRLock() {
+			t.Fatalf("read lock %d held back after a reader that waited, want %d let past", i+1, k)
+		}
+	}
+	if rw.TryRLock() {
+		t.Fatalf("read lock %d let past the writer after a reader that waited", k+1)
+	}
+	rw.RUnlockN(k)
+	<-wrote
+}
+
+func TestWithMaxReadGroupSize(t *testing.T) {
+	const readers, n = 4, 3
+	rw := New(WithMaxReadGroupSize(n))
+	var cycles, in, most int64
+	stop := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < readers; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					done <- true
+					return
+				default:
+				}
+				rw.RLock()
+				atomic.AddInt64(&cycles, 1)
+				for v := atomic.AddInt64(&in, 1); ; {
+					m := atomic.LoadInt64(&most)
+					if v <= m || atomic.CompareAndSwapInt64(&most, m, v) {
+						break
+					}
+				}
+				runtime.Gosched() // keep the lock from going idle
+				atomic.AddInt64(&in, -1)
+				rw.RUnlock()
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		for i := 0; i < readers; i++ {
+			<-done
+		}
+	}()
+	for atomic.LoadInt64(&cycles) < 100 {
+		runtime.Gosched()
+	}
+	if m := atomic.LoadInt64(&most); m > n {
+		t.Fatalf("%d readers held the lock at once, want at most %d", m, n)
+	}
+	for i := 0; i < 10; i++ {
+		waited := make(chan int64)
+		go func() {
+			before := atomic.LoadInt64(&cycles)
+			rw.Lock()
+			got := atomic.LoadInt64(&cycles) - before
+			rw.Unlock()
+			waited <- got
+		}()
+		select {
+		case got := <-waited:
+			// The group open when the writer started waiting,
+			// and readers in flight, may finish ahead of it.
+			if got > n+readers {
+				t.Fatalf("writer waited for %d read cycles, want at most %d", got, n+readers)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("writer starved by overlapping readers")
+		}
+		runtime.Gosched()
+	}
+}
+
+// TestWithMaxReadGroupSizeAfterWriter fills a group with readers that
+// waited out a writer, and checks that each took a single place in it.
+func TestWithMaxReadGroupSizeAfterWriter(t *testing.T) {
+	const n = 3
+	rw := New(WithMaxReadGroupSize(n))
+	rw.Lock()
+	in := make(chan bool)
+	for i := 0; i < n-1; i++ {
+		go func() {
+			rw.RLock()
+			in <- true
+		}()
+	}
+	for start := time.Now(); rw.Stats().Readers != n-1; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("%d of %d readers waiting behind the writer", rw.Stats().Readers, n-1)
+		}
+	}
+	rw.Unlock()
+	for i := 0; i < n-1; i++ {
+		<-in
+	}
+	if !rw.TryRLock() {
+		t.Fatalf("TryRLock failed with %d readers in a group of %d", n-1, n)
+	}
+	if rw.TryRLock() {
+		t.Fatalf("TryRLock succeeded with a group of %d full", n)
+	}
+	rw.RUnlockN(n)
+
+	// The next group, opened with the lock idle, fits n readers too.
+	for i := 0; i < n; i++ {
+		if !rw.TryRLock() {
+			t.Fatalf("TryRLock %d failed in a new group of %d", i+1, n)
+		}
+	}
+	rw.RUnlockN(n)
+}
+
+// TestWithAlternation measures the longest a writer waits under a
+// sustained stream of overlapping readers, and checks that a reader
+// still gets in under a sustained stream of writers.
+func TestWithAlternation(t *testing.T) {
+	const readers, writers = 4, 3
+	rw := New(WithAlternation())
+	var cycles int64
+	stop := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < readers; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					done <- true
+					return
+				default:
+				}
+				rw.RLock()
+				atomic.AddInt64(&cycles, 1)
+				runtime.Gosched()
+				rw.RUnlock()
+			}
+		}()
+	}
+	for atomic.LoadInt64(&cycles) < 100 {
+		runtime.Gosched()
+	}
+	var maxCycles int64
+	var maxWait time.Duration
+	for i := 0; i < 20; i++ {
+		before := atomic.LoadInt64(&cycles)
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := rw.LockContext(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("writer starved by readers: %v", err)
+		}
+		if d := time.Since(start); d > maxWait {
+			maxWait = d
+		}
+		if n := atomic.LoadInt64(&cycles) - before; n > maxCycles {
+			maxCycles = n
+		}
+		rw.Unlock()
+		runtime.Gosched()
+	}
+	close(stop)
+	for i := 0; i < readers; i++ {
+		<-done
+	}
+	t.Logf("max writer wait %v, %d read cycles", maxWait, maxCycles)
+	// The writer waits for the read turn in progress, and the
+	// readers held back during its own turn, but no others.
+	if maxCycles > 2*readers {
+		t.Fatalf("writer waited for %d read cycles, want at most %d", maxCycles, 2*readers)
+	}
+
+	var writes int64
+	stop = make(chan bool)
+	for i := 0; i < writers; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					done <- true
+					return
+				default:
+				}
+				rw.Lock()
+				atomic.AddInt64(&writes, 1)
+				runtime.Gosched()
+				rw.Unlock()
+			}
+		}()
+	}
+	for atomic.LoadInt64(&writes) < 100 {
+		runtime.Gosched()
+	}
+	for i := 0; i < 20; i++ {
+		before := atomic.LoadInt64(&writes)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := rw.RLockContext(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("reader starved by writers: %v", err)
+		}
+		if n := atomic.LoadInt64(&writes) - before; n > 1 {
+			t.Fatalf("reader waited for %d write turns, want at most 1", n)
+		}
+		rw.RUnlock()
+		runtime.Gosched()
+	}
+	close(stop)
+	for i := 0; i < writers; i++ {
+		<-done
+	}
+	hammerOversubscribed(t, rw)
+}
+
+func TestWithContentionObserver(t *testing.T) {
+	type event struct {
+		m      Mode
+		spins  int
+		waited time.Duration
+	}
+	events := make(chan event, 10)
+	rw := New(WithContentionObserver(func(m Mode, spins int, waited time.Duration) {
+		events <- event{m, spins, waited}
+	}))
+	rw.Lock()
+	rw.Unlock()
+	rw.RLock()
+	rw.RUnlock()
+	select {
+	case e := <-events:
+		t.Fatalf("uncontended acquisition reported: %+v", e)
+	default:
+	}
+
+	for _, m := range []Mode{Write, Read} {
+		rw.Lock()
+		acquired := make(chan bool)
+		go func() {
+			if m == Write {
+				rw.Lock()
+				rw.Unlock()
+			} else {
+				rw.RLock()
+				rw.RUnlock()
+			}
+			acquired <- true
+		}()
+		time.Sleep(5 * time.Millisecond)
+		rw.Unlock()
+		<-acquired
+		e := <-events
+		if e.m != m || e.spins == 0 || e.waited < time.Millisecond {
+			t.Fatalf("%v: got %+v, want a %v acquisition that spun for about 5ms", m, e, m)
+		}
+	}
+}
+
+func TestWithYieldFunc(t *testing.T) {
+	var yields atomic.Int64
+	yield := func() {
+		yields.Add(1)
+		runtime.Gosched()
+	}
+	for name, rw := range map[string]*RW{
+		"WithSpinBudget": New(WithYieldFunc(yield), WithSpinBudget(0)),
+		"WithYield":      New(WithYieldFunc(yield), WithYield(false)),
+	} {
+		yields.Store(0)
+		rw.Lock()
+		rw.Unlock()
+		rw.RLock()
+		if !rw.TryRLockTimeout(time.Millisecond) {
+			t.Fatalf("%s: TryRLockTimeout failed with only readers in", name)
+		}
+		rw.RUnlockN(2)
+		if n := yields.Load(); n != 0 {
+			t.Fatalf("%s: yield func called %d times without contention", name, n)
+		}
+
+		rw.Lock()
+		if rw.TryRLockTimeout(5 * time.Millisecond) {
+			t.Fatalf("%s: TryRLockTimeout succeeded while write-locked", name)
+		}
+		rw.Unlock()
+		if yields.Load() == 0 {
+			t.Fatalf("%s: yield func not called by a waiter that timed out", name)
+		}
+	}
+}
+
+// TestWithSpinHook uses the hook to let a reader spin exactly three
+// times behind a writer, releasing the writer from the third spin.
+func TestWithSpinHook(t *testing.T) {
+	release, released := make(chan bool), make(chan bool)
+	spins := 0
+	rw := New(WithSpinHook(func() {
+		if spins++; spins == 3 {
+			release <- true
+			<-released
+		}
+	}))
+	locked := make(chan bool)
+	go func() {
+		rw.Lock()
+		locked <- true
+		<-release
+		rw.Unlock()
+		released <- true
+	}()
+	<-locked
+	rw.RLock()
+	rw.RUnlock()
+	if spins != 3 {
+		t.Fatalf("reader spun %d times, want 3", spins)
+	}
+}
@@ -0,0 +1,51 @@
+package lock_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestPaddedRW(t *testing.T) {
+	locks := make([]PaddedRW, 4)
+	for i := range locks {
+		locks[i].Lock()
+	}
+	for i := range locks {
+		if locks[i].TryRLock() {
+			t.Fatalf("lock %d: TryRLock succeeded while write-locked", i)
+		}
+		locks[i].Downgrade()
+		locks[i].RUnlock()
+		if !locks[i].TryLock() {
+			t.Fatalf("lock %d not idle after RUnlock", i)
+		}
+		locks[i].Unlock()
+	}
+}
+
+// BenchmarkPaddedRW has each goroutine hammer a lock of its own in an
+// array of locks, which only contend through false sharing.
+func BenchmarkPaddedRW(b *testing.B) {
+	const n = 16
+	b.Run("RW", func(b *testing.B) {
+		var locks [n]RW
+		benchmarkOwnLock(b, func(i int) *RW { return &locks[i%n] })
+	})
+	b.Run("PaddedRW", func(b *testing.B) {
+		var locks [n]PaddedRW
+		benchmarkOwnLock(b, func(i int) *RW { return &locks[i%n].RW })
+	})
+}
+
+func benchmarkOwnLock(b *testing.B, lock func(i int) *RW) {
+	var next int32
+	b.RunParallel(func(pb *testing.PB) {
+		rw := lock(int(atomic.AddInt32(&next, 1)))
+		for pb.Next() {
+			rw.Lock()
+			rw.Unlock()
+		}
+	})
+}
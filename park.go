@@ -0,0 +1,124 @@
+package lock
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A waitq parks goroutines that have exhausted their spin budget on a
+// semaphore until a release that could let them in wakes them,
+// rather than let them burn a processor for as long as the lock is
+// held. RW keeps one waitq for readers, which wait for the writer to
+// leave, and one for writers, which wait for rw to go idle: a woken
+// waiter that loses the race parks again, and it could otherwise take
+// the wakeup meant for a waiter of the other kind.
+//
+// Parking follows a register-then-check protocol. The waiter counts
+// itself in waiters and tries once more before sleeping, and every
+// release that could admit it checks waiters after updating the lock
+// state. Whichever of the two goes second sees the other's write, so
+// a waiter either gets in or is woken. A wakeup takes every waiter off
+// waiters and posts one semaphore token for each. A waiter whose last
+// try succeeded takes itself off waiters, or, if a wakeup already did,
+// consumes the token posted for it.
+//
+// The semaphore is the runtime's behind sync.Mutex, which parks
+// goroutines without holding an OS thread, unless the RW was created
+// WithPortableParking or WithFutex or the build is tagged lockportable.
+type waitq struct {
+	waiters int32
+	sema    uint32                    // runtime semaphore, or futex word
+	sem     atomic.Pointer[semaphore] // portable semaphore, if used
+	futex   bool                      // sleep in futex calls on sema
+}
+
+// park waits for a wakeup on q, unless try succeeds after the calling
+// goroutine has registered as a waiter, and reports whether try
+// succeeded.
+func (q *waitq) park(s *spinner, try func() bool) bool {
+	s.begin()
+	s.n++
+	atomic.AddInt32(&q.waiters, 1)
+	if try() {
+		q.unregister()
+		return true
+	}
+	q.sleep()
+	return false
+}
+
+// unregister undoes the registration of a waiter that did not sleep.
+func (q *waitq) unregister() {
+	for {
+		n := atomic.LoadInt32(&q.waiters)
+		if n == 0 {
+			q.sleep()
+			return
+		}
+		if atomic.CompareAndSwapInt32(&q.waiters, n, n-1) {
+			return
+		}
+	}
+}
+
+// wake wakes every waiter parked on q.
+func (q *waitq) wake() {
+	if atomic.LoadInt32(&q.waiters) == 0 {
+		return
+	}
+	for n := atomic.SwapInt32(&q.waiters, 0); n > 0; n-- {
+		q.post()
+	}
+}
+
+// sleep waits for a token on q's semaphore.
+func (q *waitq) sleep() {
+	if q.futex {
+		futexAcquire(&q.sema)
+		return
+	}
+	q.sleepSema()
+}
+
+// post posts a token on q's semaphore.
+func (q *waitq) post() {
+	if q.futex {
+		futexRelease(&q.sema)
+		return
+	}
+	q.postSema()
+}
+
+// A semaphore is a counting semaphore built on sync.Cond, which parks
+// goroutines through the standard library alone. RW hides its own
+// synchronization from the race detector, which then ignores the
+// mutex as well, so tokens is also accessed atomically.
+type semaphore struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	tokens atomic.Int32
+}
+
+func newSemaphore() *semaphore {
+	p := new(semaphore)
+	p.cond.L = &p.mu
+	return p
+}
+
+// acquire waits for a token and takes it.
+func (p *semaphore) acquire() {
+	p.mu.Lock()
+	for p.tokens.Load() == 0 {
+		p.cond.Wait()
+	}
+	p.tokens.Add(-1)
+	p.mu.Unlock()
+}
+
+// release posts a token, waking a goroutine waiting for one.
+func (p *semaphore) release() {
+	p.mu.Lock()
+	p.tokens.Add(1)
+	p.mu.Unlock()
+	p.cond.Signal()
+}
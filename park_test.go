@@ -0,0 +1,329 @@
+package lock_test
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+// parked reports whether n goroutines are blocked on a semaphore,
+// the runtime's, a portable one or a futex.
+func parked(n int) bool {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	stacks := string(buf)
+	return strings.Count(stacks, "[semacquire")+strings.Count(stacks, "[sync.Cond.Wait")+
+		strings.Count(stacks, "[syscall") >= n
+}
+
+// parkers are the ways an RW can park its waiters.
+var parkers = []struct {
+	name string
+	new  func() *RW
+}{
+	{"Runtime", func() *RW { return new(RW) }},
+	{"Portable", func() *RW { return New(WithPortableParking()) }},
+	{"Futex", func() *RW { return New(WithFutex()) }},
+}
+
+func TestLockParks(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		unlock func(rw *RW)
+	}{
+		{"Unlock", (*RW).Unlock},
+		{"Downgrade", func(rw *RW) {
+			rw.Downgrade()
+			rw.RUnlock()
+		}},
+	} {
+		for _, p := range parkers {
+			t.Run(p.name+"/"+tc.name, func(t *testing.T) {
+				rw := p.new()
+				rw.Lock()
+				done := make(chan bool)
+				go func() {
+					rw.Lock()
+					rw.Unlock()
+					done <- true
+				}()
+				go func() {
+					rw.RLock()
+					rw.RUnlock()
+					done <- true
+				}()
+				deadline := time.Now().Add(5 * time.Second)
+				for !parked(2) {
+					if time.Now().After(deadline) {
+						t.Fatalf("waiters still spinning on a lock held for 5s")
+					}
+					time.Sleep(time.Millisecond)
+				}
+				tc.unlock(rw)
+				for i := 0; i < 2; i++ {
+					select {
+					case <-done:
+					case <-time.After(5 * time.Second):
+						t.Fatalf("parked waiter not woken by %s", tc.name)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestUnlockAdmitsBatch checks that readers parked behind a writer
+// are in as soon as it unlocks, before any of them has run.
+func TestUnlockAdmitsBatch(t *testing.T) {
+	for _, p := range parkers {
+		t.Run(p.name, func(t *testing.T) {
+			const readers = 8
+			rw := p.new()
+			rw.Lock()
+			release := make(chan bool)
+			done := make(chan bool)
+			for r := 0; r < readers; r++ {
+				go func() {
+					rw.RLock()
+					<-release
+					rw.RUnlock()
+					done <- true
+				}()
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for !parked(readers) {
+				if time.Now().After(deadline) {
+					t.Fatalf("readers still spinning on a lock held for 5s")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			rw.Unlock()
+			if st := rw.Stats(); st.WriteHeld || st.Readers != readers {
+				t.Fatalf("after Unlock: %+v, want %d readers in", st, readers)
+			}
+			close(release)
+			for r := 0; r < readers; r++ {
+				<-done
+			}
+		})
+	}
+}
+
+// TestParkHammer checks for lost wakeups: readers and writers park
+// and wake in every order Unlock, Downgrade, RUnlock and a reader
+// giving up can produce.
+func TestParkHammer(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	for _, procs := range []int{1, 2, 4} {
+		runtime.GOMAXPROCS(procs)
+		for round := 0; round < 20; round++ {
+			rw := parkers[round%len(parkers)].new()
+			const writers, readers, loops = 3, 8, 100
+			done := make(chan bool)
+			for w := 0; w < writers; w++ {
+				go func(w int) {
+					for i := 0; i < loops; i++ {
+						rw.Lock()
+						runtime.Gosched()
+						if (i+w)%3 == 0 {
+							rw.Downgrade()
+							runtime.Gosched()
+							rw.RUnlock()
+						} else {
+							rw.Unlock()
+						}
+					}
+					done <- true
+				}(w)
+			}
+			for r := 0; r < readers; r++ {
+				go func(r int) {
+					for i := 0; i < loops; i++ {
+						if r == 0 {
+							ctx, cancel := context.WithTimeout(context.Background(), time.Microsecond)
+							if rw.RLockContext(ctx) == nil {
+								rw.RUnlock()
+							}
+							cancel()
+							continue
+						}
+						rw.RLock()
+						runtime.Gosched()
+						rw.RUnlock()
+					}
+					done <- true
+				}(r)
+			}
+			for i := 0; i < writers+readers; i++ {
+				select {
+				case <-done:
+				case <-time.After(10 * time.Second):
+					t.Fatalf("GOMAXPROCS=%d: deadlock, lock state %v", procs, rw)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkUnlockReaders measures how long a batch of readers that
+// parked behind a writer takes to get through once it unlocks.
+func BenchmarkUnlockReaders(b *testing.B) {
+	for _, p := range parkers {
+		b.Run(p.name, func(b *testing.B) {
+			const readers = 32
+			rw := p.new()
+			var wg sync.WaitGroup
+			var elapsed time.Duration
+			for i := 0; i < b.N; i++ {
+				rw.Lock()
+				wg.Add(readers)
+				for r := 0; r < readers; r++ {
+					go func() {
+						rw.RLock()
+						rw.RUnlock()
+						wg.Done()
+					}()
+				}
+				time.Sleep(100 * time.Microsecond)
+				start := time.Now()
+				rw.Unlock()
+				wg.Wait()
+				elapsed += time.Since(start)
+			}
+			b.ReportMetric(float64(elapsed.Nanoseconds())/float64(b.N), "ns/batch")
+		})
+	}
+}
+
+func TestLockBlocking(t *testing.T) {
+	for _, p := range parkers {
+		t.Run(p.name, func(t *testing.T) {
+			rw := p.new()
+			rw.LockBlocking()
+			done := make(chan bool)
+			go func() {
+				rw.LockBlocking()
+				rw.Unlock()
+				done <- true
+			}()
+			deadline := time.Now().Add(5 * time.Second)
+			for !parked(1) {
+				if time.Now().After(deadline) {
+					t.Fatalf("LockBlocking did not park on a held lock")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			rw.Unlock()
+			<-done
+		})
+	}
+	// A spinning lock still parks a blocking waiter.
+	rw := New(WithYield(false))
+	rw.Lock()
+	done := make(chan bool)
+	go func() {
+		rw.LockBlocking()
+		rw.Unlock()
+		done <- true
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !parked(1) {
+		if time.Now().After(deadline) {
+			t.Fatalf("LockBlocking spun on a lock created WithYield(false)")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	rw.Unlock()
+	<-done
+}
+
+// BenchmarkLockBlocking contends for a lock with many more goroutines
+// than processors, and reports how much work an unrelated goroutine
+// gets done per acquisition, for Lock and LockBlocking.
+func BenchmarkLockBlocking(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		lock func(rw *RW)
+	}{
+		{"Lock", (*RW).Lock},
+		{"LockBlocking", (*RW).LockBlocking},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var rw RW
+			var work int64
+			stop := make(chan bool)
+			go func() {
+				for {
+					select {
+					case <-stop:
+						stop <- true
+						return
+					default:
+						atomic.AddInt64(&work, 1)
+					}
+				}
+			}()
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					bm.lock(&rw)
+					for i := 0; i < 100; i++ {
+						runtime.KeepAlive(i)
+					}
+					rw.Unlock()
+				}
+			})
+			stop <- true
+			<-stop
+			b.ReportMetric(float64(atomic.LoadInt64(&work))/float64(b.N), "work/op")
+		})
+	}
+}
+
+// BenchmarkLockLongHold holds the lock for much longer than the spin
+// budget while other goroutines wait for it, and reports how much work
+// an unrelated goroutine gets done per hold.
+func BenchmarkLockLongHold(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		rw   *RW
+	}{
+		{"Park", New()},
+		{"PortablePark", New(WithPortableParking())},
+		{"Futex", New(WithFutex())},
+		{"Spin", New(WithYield(false))},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var work int64
+			stop := make(chan bool)
+			go func() {
+				for {
+					select {
+					case <-stop:
+						stop <- true
+						return
+					default:
+						atomic.AddInt64(&work, 1)
+					}
+				}
+			}()
+			b.SetParallelism(4)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					bm.rw.Lock()
+					time.Sleep(50 * time.Microsecond)
+					bm.rw.Unlock()
+				}
+			})
+			stop <- true
+			<-stop
+			b.ReportMetric(float64(atomic.LoadInt64(&work))/float64(b.N), "work/op")
+		})
+	}
+}
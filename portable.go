@@ -0,0 +1,40 @@
+//go:build lockportable
+
+package lock
+
+import "unsafe"
+
+// Builds tagged lockportable park every RW on a portable semaphore, so
+// that the package does not reach into the runtime with go:linkname,
+// which later Go versions may refuse. A waitq creates its semaphore
+// when it is first needed, since the zero RW has none.
+
+// procHint stands in for the id of the P running the calling goroutine,
+// which only go:linkname can get, with a hash of the address of its
+// stack. Goroutines have distinct stacks, so concurrent goroutines tend
+// to get distinct hints, without any shared state to consult.
+func procHint() uint64 {
+	var b byte
+	p := uintptr(unsafe.Pointer(&b))
+	// Stacks are at least 2KB apart; mix the bits above that.
+	return uint64(p>>11) * 0x9E3779B97F4A7C15 >> 32
+}
+
+// sleepSema waits for a token on q's semaphore, if q does not use a
+// futex.
+func (q *waitq) sleepSema() {
+	q.semaphore().acquire()
+}
+
+// postSema posts a token on q's semaphore, if q does not use a futex.
+func (q *waitq) postSema() {
+	q.semaphore().release()
+}
+
+func (q *waitq) semaphore() *semaphore {
+	if p := q.sem.Load(); p != nil {
+		return p
+	}
+	q.sem.CompareAndSwap(nil, newSemaphore())
+	return q.sem.Load()
+}
@@ -0,0 +1,16 @@
+//go:build race
+
+package lock
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+const raceEnabled = true
+
+func raceAcquire(addr unsafe.Pointer)      { runtime.RaceAcquire(addr) }
+func raceRelease(addr unsafe.Pointer)      { runtime.RaceRelease(addr) }
+func raceReleaseMerge(addr unsafe.Pointer) { runtime.RaceReleaseMerge(addr) }
+func raceDisable()                         { runtime.RaceDisable() }
+func raceEnable()                          { runtime.RaceEnable() }
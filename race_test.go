@@ -0,0 +1,155 @@
+//go:build race
+
+package lock_test
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+	"github.com/as/lock/internal/testenv"
+)
+
+// TestRaceReaderWrites runs testRaceReaderWrites in a child process
+// and expects the race detector to report it.
+func TestRaceReaderWrites(t *testing.T) {
+	if os.Getenv("LOCK_TEST_RACE_CHILD") != "" {
+		testRaceReaderWrites()
+		return
+	}
+	testenv.MustHaveExec(t)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRaceReaderWrites$")
+	cmd.Env = append(os.Environ(), "LOCK_TEST_RACE_CHILD=1")
+	out, _ := cmd.CombinedOutput()
+	if !strings.Contains(string(out), "WARNING: DATA RACE") {
+		t.Fatalf("race detector missed writes under a read lock:\n%s", out)
+	}
+}
+
+// testRaceReaderWrites writes the protected data while holding only
+// the read lock in two goroutines. A read lock does not order readers,
+// so the writes conflict.
+func testRaceReaderWrites() {
+	var (
+		rw   RW
+		x    int
+		done = make(chan bool)
+	)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			// Sleep rather than synchronize, so that only rw
+			// could order the two writes.
+			time.Sleep(time.Duration(i) * 10 * time.Millisecond)
+			rw.RLock()
+			x += i
+			rw.RUnlock()
+			done <- true
+		}(i)
+	}
+	<-done
+	<-done
+}
+
+// TestRaceProtected checks that accesses rw does order are not
+// reported: the test binary fails if the race detector complains.
+func TestRaceProtected(t *testing.T) {
+	var (
+		rw RW
+		x  int
+	)
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		switch i {
+		case 0:
+			go func() {
+				rw.Lock()
+				x++
+				rw.Unlock()
+			}()
+		case 1:
+			go func() {
+				rw.Lock()
+				x++
+				rw.Downgrade()
+				_ = x
+				rw.RUnlock()
+			}()
+		case 2:
+			go func() {
+				rw.RLock()
+				_ = x
+				if rw.Upgrade() {
+					x++
+					rw.Unlock()
+				} else {
+					rw.RUnlock()
+				}
+			}()
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	rw.Lock()
+	x++
+	rw.Unlock()
+}
+
+// TestRaceAcquireRelease checks that the edges of LoadAcquire and
+// StoreRelease order plain accesses for the race detector, with sleeps
+// rather than synchronization keeping the goroutines apart, and that
+// without them the race detector reports the same accesses.
+func TestRaceAcquireRelease(t *testing.T) {
+	if os.Getenv("LOCK_TEST_RACE_CHILD") != "" {
+		testRaceAcquireRelease(false)
+		return
+	}
+	testRaceAcquireRelease(true)
+	testenv.MustHaveExec(t)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRaceAcquireRelease$")
+	cmd.Env = append(os.Environ(), "LOCK_TEST_RACE_CHILD=1")
+	out, _ := cmd.CombinedOutput()
+	if !strings.Contains(string(out), "WARNING: DATA RACE") {
+		t.Fatalf("race detector missed accesses that only sleeps kept apart:\n%s", out)
+	}
+}
+
+// testRaceAcquireRelease hands x from a writer to lock-free code, and
+// y from lock-free code to a reader, fencing each side with
+// LoadAcquire and StoreRelease if fence.
+func testRaceAcquireRelease(fence bool) {
+	var (
+		rw   RW
+		x, y int
+		done = make(chan bool)
+	)
+	go func() {
+		rw.Lock()
+		x = 1
+		rw.Unlock()
+		done <- true
+	}()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		if fence {
+			rw.LoadAcquire()
+		}
+		_ = x
+		y = 1
+		if fence {
+			rw.StoreRelease()
+		}
+		done <- true
+	}()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		rw.RLock()
+		_ = y
+		rw.RUnlock()
+		done <- true
+	}()
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+}
@@ -0,0 +1,87 @@
+package lock
+
+import "sync"
+
+// RecursiveRW is a read/write lock that keeps count of the read locks
+// held by each goroutine, so that a goroutine can RLock again while it
+// holds a read lock, through nested calls, and be told when its RUnlock
+// calls do not match. Only a goroutine's outermost RLock and RUnlock
+// touch the underlying lock, so nested read locks never wait, even for
+// a writer that an RW with writer preference would let in first.
+//
+// Identifying the calling goroutine costs about a microsecond on every
+// RLock and RUnlock, and the per-goroutine counts live in a map behind
+// a mutex that every reader takes, so RecursiveRW is far slower than RW
+// and its readers contend with each other. The write half is a plain
+// RW write lock: Lock does not nest, and a goroutine holding a read
+// lock must not Lock.
+//
+// The zero value is an unlocked RecursiveRW.
+type RecursiveRW struct {
+	rw     RW
+	mu     sync.Mutex    // guards depths
+	depths map[int64]int // read locks held, by goroutine id
+}
+
+// RLock locks r for reading, or increases the read depth of the
+// calling goroutine if it already holds a read lock.
+func (r *RecursiveRW) RLock() {
+	id := goid()
+	r.mu.Lock()
+	d := r.depths[id]
+	if d > 0 {
+		r.depths[id] = d + 1
+	}
+	r.mu.Unlock()
+	if d > 0 {
+		return
+	}
+	r.rw.RLock()
+	r.mu.Lock()
+	if r.depths == nil {
+		r.depths = make(map[int64]int)
+	}
+	r.depths[id] = 1
+	r.mu.Unlock()
+}
+
+// RUnlock undoes one RLock by the calling goroutine, releasing its
+// read lock once the outermost RLock is undone. It panics if the
+// calling goroutine holds no read lock.
+func (r *RecursiveRW) RUnlock() {
+	id := goid()
+	r.mu.Lock()
+	d := r.depths[id]
+	if d == 0 {
+		r.mu.Unlock()
+		panic("lock: RUnlock of RecursiveRW not read-locked by the calling goroutine")
+	}
+	if d == 1 {
+		delete(r.depths, id)
+	} else {
+		r.depths[id] = d - 1
+	}
+	r.mu.Unlock()
+	if d == 1 {
+		r.rw.RUnlock()
+	}
+}
+
+// ReadDepth returns the number of read locks of r the calling goroutine
+// holds, to check that a call returned with as many as it started with.
+func (r *RecursiveRW) ReadDepth() int {
+	id := goid()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.depths[id]
+}
+
+// Lock locks r for writing.
+func (r *RecursiveRW) Lock() {
+	r.rw.Lock()
+}
+
+// Unlock unlocks r for writing.
+func (r *RecursiveRW) Unlock() {
+	r.rw.Unlock()
+}
@@ -0,0 +1,64 @@
+package lock_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func rrecurse(t *testing.T, r *RecursiveRW, depth int) {
+	r.RLock()
+	defer r.RUnlock()
+	if d := r.ReadDepth(); d == 0 {
+		t.Fatalf("ReadDepth 0 inside RLock")
+	}
+	if depth > 0 {
+		rrecurse(t, r, depth-1)
+	}
+}
+
+func TestRecursiveRW(t *testing.T) {
+	var r RecursiveRW
+	var wrote int32
+	r.RLock()
+	done := make(chan bool)
+	go func() {
+		r.Lock()
+		atomic.StoreInt32(&wrote, 1)
+		r.Unlock()
+		done <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	rrecurse(t, &r, 100)
+	if d := r.ReadDepth(); d != 1 {
+		t.Fatalf("ReadDepth %d after nested reads returned, want 1", d)
+	}
+	if atomic.LoadInt32(&wrote) != 0 {
+		t.Fatalf("writer ran while a read lock was held")
+	}
+	r.RUnlock()
+	<-done
+	if d := r.ReadDepth(); d != 0 {
+		t.Fatalf("ReadDepth %d after the outermost RUnlock, want 0", d)
+	}
+}
+
+func TestRecursiveRWImbalance(t *testing.T) {
+	var r RecursiveRW
+	if !mustPanic(r.RUnlock) {
+		t.Fatalf("RUnlock without RLock did not panic")
+	}
+	r.RLock()
+	panicked := make(chan bool)
+	go func() {
+		panicked <- mustPanic(r.RUnlock)
+	}()
+	if !<-panicked {
+		t.Fatalf("RUnlock by a goroutine holding no read lock did not panic")
+	}
+	r.RUnlock()
+	r.Lock()
+	r.Unlock()
+}
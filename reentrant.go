@@ -0,0 +1,67 @@
+package lock
+
+import "sync/atomic"
+
+// ReentrantRW is a read/write lock whose write half may be locked
+// again by the goroutine already holding it. Each Lock by the owner
+// must be matched by an Unlock, and the lock is only released when
+// the outermost Unlock returns. The owner may also RLock and RUnlock
+// while holding the write lock; those calls count as nested writes.
+//
+// Identifying the calling goroutine costs about a microsecond on every
+// Lock and Unlock, and on every RLock and RUnlock made while a writer
+// holds the lock, so ReentrantRW is far slower than RW. Prefer
+// restructuring code to not need reentrancy. ReentrantRW cannot be
+// downgraded.
+//
+// The zero value is an unlocked ReentrantRW.
+type ReentrantRW struct {
+	rw    RW
+	owner atomic.Int64 // goroutine id of the writer, or 0
+	depth int          // nested write holds; only accessed by the owner
+}
+
+// Lock locks r for writing, or increases the nesting depth if the
+// calling goroutine already holds the write lock.
+func (r *ReentrantRW) Lock() {
+	id := goid()
+	if r.owner.Load() == id {
+		r.depth++
+		return
+	}
+	r.rw.Lock()
+	r.owner.Store(id)
+	r.depth = 1
+}
+
+// Unlock undoes one Lock by the calling goroutine, releasing r once
+// the outermost Lock is undone. It panics if the calling goroutine
+// does not hold the write lock.
+func (r *ReentrantRW) Unlock() {
+	if r.owner.Load() != goid() {
+		panic("lock: Unlock of ReentrantRW not held by the calling goroutine")
+	}
+	if r.depth--; r.depth == 0 {
+		r.owner.Store(0)
+		r.rw.Unlock()
+	}
+}
+
+// RLock locks r for reading. If the calling goroutine holds the write
+// lock, it instead increases the nesting depth.
+func (r *ReentrantRW) RLock() {
+	if o := r.owner.Load(); o != 0 && o == goid() {
+		r.depth++
+		return
+	}
+	r.rw.RLock()
+}
+
+// RUnlock undoes one RLock by the calling goroutine.
+func (r *ReentrantRW) RUnlock() {
+	if o := r.owner.Load(); o != 0 && o == goid() {
+		r.Unlock()
+		return
+	}
+	r.rw.RUnlock()
+}
@@ -0,0 +1,76 @@
+package lock_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestReentrantRW(t *testing.T) {
+	var r ReentrantRW
+	r.Lock()
+	r.Lock()
+	r.RLock()
+	r.RUnlock()
+	r.Unlock()
+
+	acquired := make(chan bool)
+	go func() {
+		r.Lock()
+		acquired <- true
+		r.Unlock()
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("another goroutine locked a held ReentrantRW")
+	case <-time.After(10 * time.Millisecond):
+	}
+	r.Unlock()
+	select {
+	case <-acquired:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("ReentrantRW not released by the outermost Unlock")
+	}
+}
+
+func TestReentrantRWRead(t *testing.T) {
+	var r ReentrantRW
+	r.RLock()
+	r.RLock()
+	r.RUnlock()
+	r.RUnlock()
+	r.Lock()
+	r.Unlock()
+}
+
+func TestReentrantRWForeignUnlock(t *testing.T) {
+	var r ReentrantRW
+	r.Lock()
+	panicked := make(chan bool)
+	go func() {
+		panicked <- mustPanic(r.Unlock)
+	}()
+	if !<-panicked {
+		t.Fatalf("Unlock by a non-owner did not panic")
+	}
+	r.Unlock()
+}
+
+func recurse(r *ReentrantRW, depth int) int {
+	r.Lock()
+	defer r.Unlock()
+	if depth == 0 {
+		return 0
+	}
+	return 1 + recurse(r, depth-1)
+}
+
+func TestReentrantRWRecursion(t *testing.T) {
+	var r ReentrantRW
+	if n := recurse(&r, 100); n != 100 {
+		t.Fatalf("recursion depth %d, want 100", n)
+	}
+	r.Lock()
+	r.Unlock()
+}
@@ -0,0 +1,25 @@
+package lock
+
+// LockState describes an RW listed by DumpAll.
+type LockState struct {
+	ID    uint64 // order in which New made the RW, from 1
+	Name  string // as given by WithName, if it was
+	State string // as String describes it
+	Stats Stats  // as Stats returns it
+}
+
+// DumpAll returns the state of every RW made by New that is still in
+// use, in the order they were made, for dumping from a SIGQUIT handler
+// or a debug endpoint when a program hangs. Only lockdebug builds keep
+// the registry it reads: other builds return nil. The registry does
+// not keep an RW alive, and one the garbage collector frees drops out
+// of it, although the lock order checks remember, and so keep alive,
+// every RW that was ever held together with another. RWs not made by
+// New, such as zero values, are never listed.
+// Each state is a separate advisory snapshot.
+//
+// The registry takes the finalizer of each RW made by New, so in
+// lockdebug builds runtime.SetFinalizer on one is a fatal error.
+func DumpAll() []LockState {
+	return dumpAll()
+}
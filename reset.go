@@ -0,0 +1,42 @@
+package lock
+
+import "sync/atomic"
+
+// Reset returns rw to the unlocked state so that it can be reused,
+// for example when the struct holding it is recycled through a
+// sync.Pool. The caller must know that no goroutine holds rw or is
+// waiting for it: Reset does not wait, and resetting an RW in use
+// corrupts it. Reset keeps the settings rw was created with, and its
+// sequence number and Version carry on from where they were.
+//
+// When built with the race detector or the lockdebug tag, Reset panics
+// if rw is in use, which catches a lock leaked by a missing Unlock.
+func (rw *RW) Reset() {
+	if (raceEnabled || debug) && rw.inUse() {
+		panic("lock: Reset of " + rw.named() + " while it is held or waited for")
+	}
+	rw.state.Store(0)
+	atomic.StoreInt32(&rw.writers, 0)
+}
+
+// CloneIdle returns a lock to use in place of rw in a copy of the
+// struct holding it, for duplicating such a struct while rw is idle
+// without the copy of a lock that vet reports. The lock returned is a
+// zero RW, since the copy of an idle lock is an unlocked one, with the
+// package defaults rather than the settings of rw. CloneIdle reports
+// true only if no goroutine held rw or waited for it at the moment of
+// the call; otherwise the copy it was meant for would not be of a
+// consistent state, and the zero RW comes with false. As with
+// IsWriteLocked, the check is a snapshot: keeping rw idle while copying
+// what it protects is up to the caller.
+func (rw *RW) CloneIdle() (RW, bool) {
+	return RW{}, !rw.inUse()
+}
+
+// inUse reports whether a goroutine holds rw or is waiting for it.
+func (rw *RW) inUse() bool {
+	return rw.state.Load()&^closedBit != 0 ||
+		atomic.LoadInt32(&rw.writers) != 0 ||
+		atomic.LoadInt32(&rw.readerq.waiters) != 0 ||
+		atomic.LoadInt32(&rw.writerq.waiters) != 0
+}
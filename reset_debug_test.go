@@ -0,0 +1,24 @@
+//go:build lockdebug || race
+
+package lock_test
+
+import (
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestResetHeld(t *testing.T) {
+	var rw RW
+	rw.RLock()
+	if !mustPanic(rw.Reset) {
+		t.Fatalf("Reset of a read-locked RW did not panic")
+	}
+	rw.RUnlock()
+	rw.Lock()
+	if !mustPanic(rw.Reset) {
+		t.Fatalf("Reset of a write-locked RW did not panic")
+	}
+	rw.Unlock()
+	rw.Reset()
+}
@@ -0,0 +1,48 @@
+package lock_test
+
+import (
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestReset(t *testing.T) {
+	var rw RW
+	rw.Lock()
+	rw.Unlock()
+	rw.Reset()
+	if !rw.TryLock() {
+		t.Fatalf("TryLock failed after Reset")
+	}
+	rw.Unlock()
+	if v := rw.Version(); v != 2 {
+		t.Fatalf("Reset changed the version to %d, want 2", v)
+	}
+}
+
+func TestCloneIdle(t *testing.T) {
+	var rw RW
+	for _, tc := range []struct {
+		name         string
+		lock, unlock func()
+	}{
+		{"write-held", rw.Lock, rw.Unlock},
+		{"read-held", rw.RLock, rw.RUnlock},
+	} {
+		tc.lock()
+		if _, ok := rw.CloneIdle(); ok {
+			t.Fatalf("CloneIdle of a %s lock reported success", tc.name)
+		}
+		tc.unlock()
+	}
+	c, ok := rw.CloneIdle()
+	if !ok {
+		t.Fatalf("CloneIdle of an idle lock failed")
+	}
+	if !c.TryLock() {
+		t.Fatalf("lock from CloneIdle not idle")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("CloneIdle shares its state with the lock it cloned")
+	}
+}
@@ -100,19 +100,22 @@ func writer(rwm *RWMutex, num_iterations int, activity *int32, cdone chan bool)
 }
 
 func HammerRWMutex(gomaxprocs, numReaders, num_iterations int) {
+	hammerRWMutex(new(RWMutex), gomaxprocs, numReaders, num_iterations)
+}
+
+func hammerRWMutex(rwm *RWMutex, gomaxprocs, numReaders, num_iterations int) {
 	GOMAXPROCS(gomaxprocs)
 	// Number of active readers + 10000 * number of active writers.
 	var activity int32
-	var rwm RWMutex
 	cdone := make(chan bool)
-	go writer(&rwm, num_iterations, &activity, cdone)
+	go writer(rwm, num_iterations, &activity, cdone)
 	var i int
 	for i = 0; i < numReaders/2; i++ {
-		go reader(&rwm, num_iterations, &activity, cdone)
+		go reader(rwm, num_iterations, &activity, cdone)
 	}
-	go writer(&rwm, num_iterations, &activity, cdone)
+	go writer(rwm, num_iterations, &activity, cdone)
 	for ; i < numReaders; i++ {
-		go reader(&rwm, num_iterations, &activity, cdone)
+		go reader(rwm, num_iterations, &activity, cdone)
 	}
 	// Wait for the 2 writers and all readers to finish.
 	for i := 0; i < 2+numReaders; i++ {
@@ -138,6 +141,22 @@ func TestRWMutex(t *testing.T) {
 	HammerRWMutex(10, 5, n)
 }
 
+// TestRWMutexLoadFirst hammers an RW made WithLoadFirstRLock, whose
+// readers load its state and CAS first rather than add at once, as the zero RW
+// is hammered by TestRWMutex.
+func TestRWMutexLoadFirst(t *testing.T) {
+	defer GOMAXPROCS(GOMAXPROCS(-1))
+	n := 1000
+	if testing.Short() {
+		n = 5
+	}
+	for _, procs := range []int{1, 4, 10} {
+		for _, readers := range []int{1, 3, 10} {
+			hammerRWMutex(New(WithLoadFirstRLock()), procs, readers, n)
+		}
+	}
+}
+
 func BenchmarkRWMutexUncontended(b *testing.B) {
 	type PaddedRWMutex struct {
 		RWMutex
@@ -193,3 +212,51 @@ func BenchmarkRWMutexWorkWrite100(b *testing.B) {
 func BenchmarkRWMutexWorkWrite10(b *testing.B) {
 	benchmarkRWMutex(b, 100, 10)
 }
+
+// BenchmarkRLockStrategy compares a CAS after checking for a writer,
+// as TryRLock tries, with RLock, which adds its reader without
+// looking, on a read-mostly lock shared by a growing number of
+// goroutines. LoadFirst is RLock on an RW made WithLoadFirstRLock,
+// which checks and tries a CAS once before it adds. Both take the lock's cache line
+// exclusive, so any difference in time per operation comes from the
+// CAS failing or the extra load, and only shows once readers run on
+// several cores.
+func BenchmarkRLockStrategy(b *testing.B) {
+	for _, bm := range []struct {
+		name  string
+		rw    func() *RW
+		rlock func(rw *RW)
+	}{
+		{"CAS", func() *RW { return new(RW) }, func(rw *RW) {
+			if !rw.TryRLock() {
+				rw.RLock()
+			}
+		}},
+		{"Add", func() *RW { return new(RW) }, (*RW).RLock},
+		{"LoadFirst", func() *RW { return New(WithLoadFirstRLock()) }, (*RW).RLock},
+	} {
+		for _, procs := range []int{1, 2, 4, 16} {
+			b.Run(fmt.Sprintf("%s/goroutines=%d", bm.name, procs), func(b *testing.B) {
+				rw := bm.rw()
+				done := make(chan bool)
+				for g := 0; g < procs; g++ {
+					go func(g int) {
+						for i := g; i < b.N; i += procs {
+							if i%100 == 0 {
+								rw.Lock()
+								rw.Unlock()
+								continue
+							}
+							bm.rlock(rw)
+							rw.RUnlock()
+						}
+						done <- true
+					}(g)
+				}
+				for g := 0; g < procs; g++ {
+					<-done
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package lock
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// WithLock calls fn while holding the write lock. The lock is
+// released by Unlock even if fn panics.
+func (rw *RW) WithLock(fn func()) {
+	rw.Lock()
+	defer rw.Unlock()
+	fn()
+}
+
+// WithRLock calls fn while holding the read lock. The lock is
+// released by RUnlock even if fn panics.
+func (rw *RW) WithRLock(fn func()) {
+	rw.RLock()
+	defer rw.RUnlock()
+	fn()
+}
+
+// DoWrite calls fn while holding the write lock and returns its error.
+// The lock is released by Unlock whether fn returns an error or
+// panics, and a panic carries on once the lock is released.
+func (rw *RW) DoWrite(fn func() error) error {
+	rw.Lock()
+	defer rw.Unlock()
+	return fn()
+}
+
+// DoRead calls fn while holding the read lock and returns its error.
+// The lock is released by RUnlock whether fn returns an error or
+// panics, and a panic carries on once the lock is released.
+func (rw *RW) DoRead(fn func() error) error {
+	rw.RLock()
+	defer rw.RUnlock()
+	return fn()
+}
+
+// WithLockDowngrade calls write while holding the write lock, then
+// downgrades and calls read while holding the resulting read lock.
+// No other writer can run between the two calls. Whichever half of
+// the lock is held is released even if either function panics.
+func (rw *RW) WithLockDowngrade(write, read func()) {
+	rw.Lock()
+	downgraded := false
+	defer func() {
+		if downgraded {
+			rw.RUnlock()
+		} else {
+			rw.Unlock()
+		}
+	}()
+	write()
+	rw.Downgrade()
+	downgraded = true
+	read()
+}
+
+// DowngradeThen downgrades the write lock held by the caller and calls
+// read while holding the resulting read lock, which is released by
+// RUnlock even if read panics. No other writer can run between the
+// caller's write and read.
+func (rw *RW) DowngradeThen(read func()) {
+	rw.Downgrade()
+	defer rw.RUnlock()
+	read()
+}
+
+// DowngradeForReaders hands what the caller wrote under its write lock
+// off to exactly n reads, taken by other goroutines with ReadHandoff,
+// which calls each once for each of them. Reads are admitted one at a
+// time, against however many goroutines ask at once: the first n
+// ReadHandoff calls run each, concurrently with one another, and any
+// more are turned away. DowngradeForReaders waits until the last
+// admitted read returns, then retires the handoff and releases rw.
+//
+// To everyone but the admitted reads, rw stays write-locked throughout,
+// so that no writer can get in from the caller's write until the n
+// reads are done, and no other reader runs alongside them; a read that
+// must get in without a handoff waits, as for any writer. The caller
+// may reuse what was read once DowngradeForReaders returns. If a call
+// of each panics, the panic carries on in the caller once all n have
+// returned and rw is released. DowngradeForReaders panics if n is
+// negative. It waits for ever if fewer than n reads come.
+func (rw *RW) DowngradeForReaders(n int, each func()) {
+	if n < 0 {
+		panic("lock: DowngradeForReaders with negative count")
+	}
+	if debug && rw.state.Load()&1 == 0 {
+		panic("lock: DowngradeForReaders of " + rw.named() + " not write-locked")
+	}
+	h := &handoff{each: each}
+	h.left.Store(int64(n))
+	h.running.Add(n)
+	handoffs.Store(rw, h)
+	h.running.Wait()
+	handoffs.Delete(rw)
+	rw.Unlock()
+	if h.panicked != nil {
+		panic(h.panicked)
+	}
+}
+
+// ReadHandoff takes one of the reads that a DowngradeForReaders in
+// progress on rw hands off and calls its each, reporting true once each
+// returns. It reports false at once, calling nothing, if no handoff is
+// in progress or its n reads have all been taken. Readers that expect
+// a handoff which has not begun yet may try again.
+func (rw *RW) ReadHandoff() bool {
+	v, ok := handoffs.Load(rw)
+	if !ok {
+		return false
+	}
+	h := v.(*handoff)
+	for {
+		left := h.left.Load()
+		if left <= 0 {
+			return false
+		}
+		if h.left.CompareAndSwap(left, left-1) {
+			break
+		}
+	}
+	defer h.running.Done()
+	defer func() {
+		if p := recover(); p != nil {
+			h.once.Do(func() { h.panicked = p })
+		}
+	}()
+	h.each()
+	return true
+}
+
+// A handoff is a DowngradeForReaders in progress.
+type handoff struct {
+	each     func()
+	left     atomic.Int64   // reads still to be admitted
+	running  sync.WaitGroup // admitted reads that have not returned
+	once     sync.Once
+	panicked any
+}
+
+// handoffs holds the handoff in progress on each RW that has one. It
+// is kept aside, rather than in every RW, since only DowngradeForReaders
+// and ReadHandoff look at it.
+var handoffs sync.Map // *RW to *handoff
+
+// UpgradeScope upgrades the read lock held by the caller, as Upgrade
+// does, calls write while holding the write lock and downgrades back
+// to a read lock, so that the caller carries on reading with no other
+// writer having run since it read-locked rw. If rw has other readers,
+// it returns false at once without calling write, and the caller still
+// holds its read lock: it may then release it, write-lock rw and check
+// again whatever made it want to write. The caller holds the read lock
+// on return even if write panics.
+func (rw *RW) UpgradeScope(write func()) bool {
+	if !rw.Upgrade() {
+		return false
+	}
+	defer rw.Downgrade()
+	write()
+	return true
+}
+
+// GuardWrite locks rw for writing and returns a function that releases
+// whichever half of rw the caller holds when it is called: Unlock if
+// the caller still holds the write lock, RUnlock if it has downgraded
+// it since. Deferring it makes a write section that may downgrade safe
+// against panics at any point:
+//
+//	defer rw.GuardWrite()()
+//	/* modify the protected data */
+//	rw.Downgrade()
+//	/* read it, with no other writer in between */
+//
+// The release function must be called exactly once, by the goroutine
+// holding rw, and must not be called after the caller released rw
+// itself.
+func (rw *RW) GuardWrite() (release func()) {
+	rw.Lock()
+	return func() {
+		// The caller holds rw, so the writer bit can only be its
+		// own: it is set until the caller downgrades, and no other
+		// writer can set it while the caller still holds a read lock.
+		if rw.state.Load()&1 != 0 {
+			rw.Unlock()
+		} else {
+			rw.RUnlock()
+		}
+	}
+}
+
+// DoWriteTracked locks rw for writing and calls fn, which may
+// downgrade rw and reports whether it did, and then releases whichever
+// half of rw fn left it holding. It returns the mode of the section's
+// end: Write if it ended in Unlock, Read if it ended as a downgraded
+// write in RUnlock, for layers such as audit logs that treat published
+// writes differently. If fn panics, rw is released as by GuardWrite.
+// Lockdebug builds panic if fn's report is wrong.
+func (rw *RW) DoWriteTracked(fn func() (downgraded bool)) Mode {
+	defer rw.GuardWrite()()
+	downgraded := fn()
+	if debug && downgraded == (rw.state.Load()&1 != 0) {
+		panic("lock: DoWriteTracked with fn misreporting its downgrade")
+	}
+	if downgraded {
+		return Read
+	}
+	return Write
+}
+
+// ReadSnapshot calls fn while holding the read lock of rw and returns
+// the value it assembles, for copying several fields out of the state
+// rw protects as one consistent value:
+//
+//	st := lock.ReadSnapshot(&c.mu, func() stats {
+//		return stats{size: c.size, used: c.used}
+//	})
+//
+// The lock is released by RUnlock even if fn panics.
+func ReadSnapshot[T any](rw *RW, fn func() T) T {
+	rw.RLock()
+	defer rw.RUnlock()
+	return fn()
+}
+
+// Mutate calls fn while holding the write lock of rw, the write-side
+// counterpart of ReadSnapshot. The lock is released by Unlock even if
+// fn panics.
+func Mutate(rw *RW, fn func()) {
+	rw.Lock()
+	defer rw.Unlock()
+	fn()
+}
@@ -0,0 +1,388 @@
+package lock_test
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestWithLock(t *testing.T) {
+	var rw RW
+	rw.WithLock(func() {
+		if rw.TryRLock() {
+			t.Fatalf("TryRLock succeeded inside WithLock")
+		}
+	})
+	if !mustPanic(func() { rw.WithLock(func() { panic("fn") }) }) {
+		t.Fatalf("WithLock swallowed a panic")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("WithLock leaked the write lock on panic")
+	}
+	rw.Unlock()
+}
+
+func TestWithRLock(t *testing.T) {
+	var rw RW
+	rw.WithRLock(func() {
+		if rw.TryLock() {
+			t.Fatalf("TryLock succeeded inside WithRLock")
+		}
+		if !rw.TryRLock() {
+			t.Fatalf("TryRLock failed inside WithRLock")
+		}
+		rw.RUnlock()
+	})
+	if !mustPanic(func() { rw.WithRLock(func() { panic("fn") }) }) {
+		t.Fatalf("WithRLock swallowed a panic")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("WithRLock leaked the read lock on panic")
+	}
+	rw.Unlock()
+}
+
+func TestDoWriteDoRead(t *testing.T) {
+	var rw RW
+	errFn := errors.New("fn failed")
+	for _, tc := range []struct {
+		name   string
+		do     func(fn func() error) error
+		inside func() bool // whether the lock is held as it should be
+	}{
+		{"DoWrite", rw.DoWrite, func() bool { return rw.IsWriteLocked() }},
+		{"DoRead", rw.DoRead, func() bool { return !rw.IsWriteLocked() && rw.ReaderCount() == 1 }},
+	} {
+		for _, want := range []error{nil, errFn} {
+			err := tc.do(func() error {
+				if !tc.inside() {
+					t.Fatalf("%s: lock not held inside fn: %v", tc.name, &rw)
+				}
+				return want
+			})
+			if err != want {
+				t.Fatalf("%s: returned %v, want %v", tc.name, err, want)
+			}
+			if !rw.TryLock() {
+				t.Fatalf("%s: lock leaked after fn returned %v", tc.name, want)
+			}
+			rw.Unlock()
+		}
+		if !mustPanic(func() { tc.do(func() error { panic("fn") }) }) {
+			t.Fatalf("%s swallowed a panic", tc.name)
+		}
+		if !rw.TryLock() {
+			t.Fatalf("%s leaked the lock on panic", tc.name)
+		}
+		rw.Unlock()
+	}
+}
+
+func TestWithLockDowngrade(t *testing.T) {
+	var rw RW
+	var order []string
+	rw.WithLockDowngrade(func() {
+		if rw.TryRLock() {
+			t.Fatalf("TryRLock succeeded in write phase")
+		}
+		order = append(order, "write")
+	}, func() {
+		if rw.TryLock() {
+			t.Fatalf("TryLock succeeded in read phase")
+		}
+		if !rw.TryRLock() {
+			t.Fatalf("TryRLock failed in read phase")
+		}
+		rw.RUnlock()
+		order = append(order, "read")
+	})
+	if len(order) != 2 || order[0] != "write" || order[1] != "read" {
+		t.Fatalf("phases ran as %v, want [write read]", order)
+	}
+	for _, tc := range []struct {
+		name        string
+		write, read func()
+	}{
+		{"write", func() { panic("write") }, func() {}},
+		{"read", func() {}, func() { panic("read") }},
+	} {
+		if !mustPanic(func() { rw.WithLockDowngrade(tc.write, tc.read) }) {
+			t.Fatalf("%s: WithLockDowngrade swallowed a panic", tc.name)
+		}
+		if !rw.TryLock() {
+			t.Fatalf("%s: WithLockDowngrade leaked the lock on panic", tc.name)
+		}
+		rw.Unlock()
+	}
+}
+
+func TestDowngradeThen(t *testing.T) {
+	var rw RW
+	var wrote int32
+	rw.Lock()
+	done := make(chan bool)
+	go func() {
+		rw.Lock()
+		atomic.StoreInt32(&wrote, 1)
+		rw.Unlock()
+		done <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	rw.DowngradeThen(func() {
+		time.Sleep(10 * time.Millisecond)
+		if atomic.LoadInt32(&wrote) != 0 {
+			t.Errorf("writer ran during DowngradeThen")
+		}
+	})
+	<-done
+
+	rw.Lock()
+	if !mustPanic(func() { rw.DowngradeThen(func() { panic("read") }) }) {
+		t.Fatalf("DowngradeThen swallowed a panic")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("DowngradeThen leaked the read lock on panic")
+	}
+	rw.Unlock()
+}
+
+func TestDowngradeForReaders(t *testing.T) {
+	const n, readers = 5, 12
+	var rw RW
+	if rw.ReadHandoff() {
+		t.Fatalf("ReadHandoff admitted a read with no handoff in progress")
+	}
+	var data, reads, arrived int32
+	release := make(chan bool)
+
+	// More goroutines than reads ask at once, each until it is
+	// admitted or every read is taken.
+	var admitted, rejected int32
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if rw.ReadHandoff() {
+					atomic.AddInt32(&admitted, 1)
+					return
+				}
+				if atomic.LoadInt32(&arrived) == n {
+					atomic.AddInt32(&rejected, 1)
+					return
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+	go func() {
+		for atomic.LoadInt32(&arrived) != n {
+			runtime.Gosched()
+		}
+		// All n reads are in and overlap; the next is turned away,
+		// and a plain reader or writer waits for the handoff to
+		// retire.
+		if rw.ReadHandoff() {
+			t.Errorf("read %d admitted to a handoff of %d", n+1, n)
+		}
+		if rw.TryRLock() || rw.TryLock() {
+			t.Errorf("lock taken outside the handoff: %v", &rw)
+		}
+		close(release)
+	}()
+	rw.Lock()
+	atomic.StoreInt32(&data, 42)
+	rw.DowngradeForReaders(n, func() {
+		if atomic.LoadInt32(&data) != 42 {
+			t.Errorf("read %d, want the handed-off 42", data)
+		}
+		atomic.AddInt32(&reads, 1)
+		atomic.AddInt32(&arrived, 1)
+		<-release
+	})
+	wg.Wait()
+	if reads != n || admitted != n || rejected != readers-n {
+		t.Fatalf("%d reads ran, %d admitted and %d rejected; want %d, %d and %d", reads, admitted, rejected, n, n, readers-n)
+	}
+	if rw.ReadHandoff() {
+		t.Fatalf("ReadHandoff admitted a read after the handoff retired")
+	}
+
+	rw.Lock()
+	rw.DowngradeForReaders(0, func() { t.Errorf("each called for no reads") })
+	rw.Lock()
+	go func() {
+		for !rw.ReadHandoff() {
+			runtime.Gosched()
+		}
+	}()
+	if !mustPanic(func() { rw.DowngradeForReaders(1, func() { panic("read") }) }) {
+		t.Fatalf("DowngradeForReaders swallowed a panic")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("lock still held after the handoff: %v", &rw)
+	}
+	rw.Unlock()
+}
+
+func TestUpgradeScope(t *testing.T) {
+	var rw RW
+	rw.RLock()
+	wrote := false
+	if !rw.UpgradeScope(func() {
+		if rw.TryRLock() {
+			t.Errorf("TryRLock succeeded inside UpgradeScope's write")
+		}
+		wrote = true
+	}) {
+		t.Fatalf("UpgradeScope failed with no other reader")
+	}
+	if !wrote {
+		t.Fatalf("UpgradeScope succeeded without calling write")
+	}
+	if n := rw.ReaderCount(); n != 1 || rw.TryLock() {
+		t.Fatalf("%d readers after UpgradeScope succeeded, want the caller's read lock back", n)
+	}
+
+	rw.RLock() // another reader
+	if rw.UpgradeScope(func() { t.Errorf("UpgradeScope called write with another reader in") }) {
+		t.Fatalf("UpgradeScope succeeded with another reader in")
+	}
+	if n := rw.ReaderCount(); n != 2 {
+		t.Fatalf("%d readers after UpgradeScope failed, want 2", n)
+	}
+	rw.RUnlock()
+
+	if !mustPanic(func() { rw.UpgradeScope(func() { panic("write") }) }) {
+		t.Fatalf("UpgradeScope swallowed a panic")
+	}
+	if n := rw.ReaderCount(); n != 1 || rw.TryLock() {
+		t.Fatalf("%d readers after write panicked, want the caller's read lock back", n)
+	}
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.Fatalf("lock still held: %v", &rw)
+	}
+}
+
+func TestGuardWrite(t *testing.T) {
+	var rw RW
+	for _, tc := range []struct {
+		name      string
+		downgrade bool
+	}{
+		{"write-locked", false},
+		{"downgraded", true},
+	} {
+		if !mustPanic(func() {
+			defer rw.GuardWrite()()
+			if rw.TryRLock() {
+				t.Fatalf("%s: TryRLock succeeded under GuardWrite", tc.name)
+			}
+			if tc.downgrade {
+				rw.Downgrade()
+			}
+			panic(tc.name)
+		}) {
+			t.Fatalf("%s: GuardWrite swallowed a panic", tc.name)
+		}
+		if !rw.TryLock() {
+			t.Fatalf("%s: GuardWrite leaked the lock on panic", tc.name)
+		}
+		rw.Unlock()
+	}
+
+	func() {
+		defer rw.GuardWrite()()
+		rw.Downgrade()
+		if !rw.TryRLock() {
+			t.Fatalf("TryRLock failed after downgrading under GuardWrite")
+		}
+		rw.RUnlock()
+	}()
+	if !rw.TryLock() {
+		t.Fatalf("GuardWrite leaked the read lock")
+	}
+	rw.Unlock()
+}
+
+func TestDoWriteTracked(t *testing.T) {
+	var rw RW
+	if m := rw.DoWriteTracked(func() bool { return false }); m != Write {
+		t.Fatalf("DoWriteTracked ended in %v without a downgrade, want write", m)
+	}
+	if !rw.TryLock() {
+		t.Fatalf("DoWriteTracked left rw held after an Unlock end")
+	}
+	rw.Unlock()
+	if m := rw.DoWriteTracked(func() bool {
+		rw.Downgrade()
+		if !rw.TryRLock() {
+			t.Errorf("TryRLock failed after fn downgraded")
+		} else {
+			rw.RUnlock()
+		}
+		return true
+	}); m != Read {
+		t.Fatalf("DoWriteTracked ended in %v after a downgrade, want read", m)
+	}
+	if !rw.TryLock() {
+		t.Fatalf("DoWriteTracked left rw held after a downgraded end")
+	}
+	rw.Unlock()
+	if !mustPanic(func() { rw.DoWriteTracked(func() bool { rw.Downgrade(); panic("write") }) }) {
+		t.Fatalf("DoWriteTracked swallowed a panic")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("DoWriteTracked left rw held after fn panicked")
+	}
+	rw.Unlock()
+}
+
+func TestReadSnapshot(t *testing.T) {
+	var rw RW
+	a, b := 1, 2
+	got := ReadSnapshot(&rw, func() [2]int {
+		if rw.TryLock() {
+			t.Fatalf("TryLock succeeded inside ReadSnapshot")
+		}
+		return [2]int{a, b}
+	})
+	if got != [2]int{1, 2} {
+		t.Fatalf("ReadSnapshot = %v, want [1 2]", got)
+	}
+	if !mustPanic(func() { ReadSnapshot(&rw, func() int { panic("fn") }) }) {
+		t.Fatalf("ReadSnapshot swallowed a panic")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("ReadSnapshot leaked the read lock on panic")
+	}
+	rw.Unlock()
+}
+
+func TestMutate(t *testing.T) {
+	var rw RW
+	n := 0
+	Mutate(&rw, func() {
+		if rw.TryRLock() {
+			t.Fatalf("TryRLock succeeded inside Mutate")
+		}
+		n++
+	})
+	if n != 1 {
+		t.Fatalf("Mutate ran fn %d times, want 1", n)
+	}
+	if !mustPanic(func() { Mutate(&rw, func() { panic("fn") }) }) {
+		t.Fatalf("Mutate swallowed a panic")
+	}
+	if !rw.TryLock() {
+		t.Fatalf("Mutate leaked the write lock on panic")
+	}
+	rw.Unlock()
+}
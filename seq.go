@@ -0,0 +1,74 @@
+package lock
+
+// Seq returns rw's sequence number, which every writer increments
+// twice: once when it acquires the lock, making the number odd, and
+// once when it releases or downgrades it, making it even again. Readers
+// of data that writers only modify under rw can use it as a seqlock:
+//
+//	for {
+//		s := rw.Seq()
+//		if s&1 != 0 {
+//			continue // a write is in progress
+//		}
+//		/* copy the protected data */
+//		if rw.Seq() == s {
+//			break // no write overlapped the copy
+//		}
+//	}
+//
+// The same rules as for TryOptimisticRead apply to the copy.
+func (rw *RW) Seq() uint64 {
+	return rw.seq.Load()
+}
+
+// TryOptimisticRead returns a stamp for an optimistic read of the data
+// rw protects. An optimistic read takes no lock and writes nothing
+// shared, so readers do not contend with each other at all:
+//
+//	for {
+//		stamp := rw.TryOptimisticRead()
+//		/* copy the protected data */
+//		if rw.Validate(stamp) {
+//			break // the copy is consistent
+//		}
+//	}
+//
+// The copy is only meaningful once Validate accepts the stamp. Until
+// then it may be torn or half-written, so reading it must not fault,
+// loop forever or otherwise act on its contents, and it must be read
+// with atomic operations for the race detector to accept it. After
+// repeated failures, fall back to RLock.
+func (rw *RW) TryOptimisticRead() uint64 {
+	return rw.seq.Load()
+}
+
+// Validate reports whether no writer held rw at any time since stamp
+// was returned by TryOptimisticRead, so that data read in between is
+// consistent. Writers that changed nothing, such as a Guarded's
+// CompareAndWrite that did not store, do not count.
+func (rw *RW) Validate(stamp uint64) bool {
+	return stamp&1 == 0 && rw.seq.Load() == stamp
+}
+
+// Version returns the number of write locks of rw released so far, by
+// Unlock or Downgrade. A writer holding rw has not yet been counted,
+// and readers never change it. Unlike Seq it is not a seqlock: it
+// serves to detect that data rw protects may have changed since an
+// earlier call, such as to invalidate a cache built from it.
+func (rw *RW) Version() uint64 {
+	return rw.seq.Load() >> 1
+}
+
+// RLockIfNewerThan read-locks rw if its version, as returned by Version,
+// is greater than v, so that a caller holding results computed at
+// version v only relocks when the data may have changed since. It
+// returns whether it locked rw and the current version: the version the
+// read lock sees if locked, and otherwise one no greater than v, which
+// the caller's results are still good for.
+func (rw *RW) RLockIfNewerThan(v uint64) (locked bool, current uint64) {
+	if current = rw.Version(); current <= v {
+		return false, current
+	}
+	rw.RLock()
+	return true, rw.Version()
+}
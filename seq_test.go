@@ -0,0 +1,202 @@
+package lock_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestValidate(t *testing.T) {
+	var rw RW
+	stamp := rw.TryOptimisticRead()
+	if !rw.Validate(stamp) {
+		t.Fatalf("stamp of an idle lock not valid")
+	}
+	rw.RLock()
+	rw.RUnlock()
+	if !rw.Validate(stamp) {
+		t.Fatalf("a reader invalidated the stamp")
+	}
+	rw.Lock()
+	if rw.Validate(stamp) {
+		t.Fatalf("stamp valid while a writer holds the lock")
+	}
+	if held := rw.TryOptimisticRead(); rw.Validate(held) {
+		t.Fatalf("stamp taken while write-locked is valid")
+	}
+	rw.Downgrade()
+	rw.RUnlock()
+	if rw.Validate(stamp) {
+		t.Fatalf("stamp valid after a write")
+	}
+	stamp = rw.TryOptimisticRead()
+	rw.RLock()
+	if !rw.Upgrade() {
+		t.Fatalf("Upgrade failed")
+	}
+	if rw.Validate(stamp) {
+		t.Fatalf("stamp valid while an upgraded writer holds the lock")
+	}
+	rw.Unlock()
+}
+
+func TestOptimisticRead(t *testing.T) {
+	// The writer keeps a == b; a validated optimistic read must
+	// never observe them differing.
+	var (
+		rw   RW
+		a, b int64
+		stop int32
+		done = make(chan bool)
+	)
+	go func() {
+		for i := int64(1); atomic.LoadInt32(&stop) == 0; i++ {
+			rw.Lock()
+			atomic.StoreInt64(&a, i)
+			atomic.StoreInt64(&b, i)
+			rw.Unlock()
+		}
+		done <- true
+	}()
+	for i := 0; i < 100000; i++ {
+		stamp := rw.TryOptimisticRead()
+		x, y := atomic.LoadInt64(&a), atomic.LoadInt64(&b)
+		if rw.Validate(stamp) && x != y {
+			t.Fatalf("validated read saw a=%d b=%d", x, y)
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
+	<-done
+}
+
+func TestSeq(t *testing.T) {
+	var rw RW
+	if s := rw.Seq(); s != 0 {
+		t.Fatalf("idle lock has sequence %d", s)
+	}
+	rw.Lock()
+	if s := rw.Seq(); s != 1 {
+		t.Fatalf("sequence %d while write-locked, want 1", s)
+	}
+	rw.Unlock()
+	rw.RLock()
+	rw.RUnlock()
+	if s := rw.Seq(); s != 2 {
+		t.Fatalf("sequence %d after one write, want 2", s)
+	}
+	if !rw.TryLock() {
+		t.Fatalf("TryLock failed")
+	}
+	rw.Downgrade()
+	if s := rw.Seq(); s != 4 {
+		t.Fatalf("sequence %d after a downgrade, want 4", s)
+	}
+	rw.RUnlock()
+}
+
+func TestSeqTornReads(t *testing.T) {
+	const writers = 2
+	var (
+		rw    RW
+		words [4]int64
+		stop  int32
+		done  = make(chan bool)
+	)
+	for w := 0; w < writers; w++ {
+		go func() {
+			for i := int64(1); atomic.LoadInt32(&stop) == 0; i++ {
+				rw.Lock()
+				for j := range words {
+					atomic.StoreInt64(&words[j], i)
+				}
+				rw.Unlock()
+			}
+			done <- true
+		}()
+	}
+	torn := 0
+	for i := 0; i < 100000; i++ {
+		s := rw.Seq()
+		var c [4]int64
+		for j := range words {
+			c[j] = atomic.LoadInt64(&words[j])
+		}
+		consistent := c[0] == c[1] && c[1] == c[2] && c[2] == c[3]
+		if s&1 != 0 || rw.Seq() != s {
+			if !consistent {
+				torn++
+			}
+			continue
+		}
+		if !consistent {
+			t.Fatalf("seqlock read accepted a torn copy %v", c)
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
+	for w := 0; w < writers; w++ {
+		<-done
+	}
+	t.Logf("%d torn copies rejected", torn)
+}
+
+func TestVersion(t *testing.T) {
+	var rw RW
+	const n = 5
+	for i := 0; i < n; i++ {
+		rw.Lock()
+		if v := rw.Version(); v != uint64(i) {
+			t.Fatalf("version %d while writer %d holds rw, want %d", v, i, i)
+		}
+		rw.Unlock()
+		rw.RLock()
+		rw.RUnlock()
+	}
+	if v := rw.Version(); v != n {
+		t.Fatalf("version %d after %d writes, want %d", v, n, n)
+	}
+	rw.Lock()
+	rw.Downgrade()
+	if v := rw.Version(); v != n+1 {
+		t.Fatalf("version %d after a downgrade, want %d", v, n+1)
+	}
+	rw.RUnlock()
+	if v := rw.Version(); v != n+1 {
+		t.Fatalf("version %d after the downgraded reader left, want %d", v, n+1)
+	}
+}
+
+func TestRLockIfNewerThan(t *testing.T) {
+	var rw RW
+	locked, seen := rw.RLockIfNewerThan(0)
+	if locked || seen != 0 {
+		t.Fatalf("RLockIfNewerThan(0) = %v, %d on a new lock, want false, 0", locked, seen)
+	}
+	for i := 1; i <= 5; i++ {
+		rw.Lock()
+		if locked, v := rw.RLockIfNewerThan(seen); locked || v != seen {
+			t.Fatalf("RLockIfNewerThan(%d) = %v, %d while a write is in progress, want false, %d", seen, locked, v, seen)
+		}
+		if i%2 == 0 {
+			rw.Unlock()
+		} else {
+			rw.Downgrade()
+			rw.RUnlock()
+		}
+		locked, v := rw.RLockIfNewerThan(seen)
+		if !locked || v != uint64(i) {
+			t.Fatalf("RLockIfNewerThan(%d) = %v, %d after write %d, want true, %d", seen, locked, v, i, i)
+		}
+		if rw.TryLock() {
+			t.Fatalf("TryLock succeeded while RLockIfNewerThan holds the read lock")
+		}
+		rw.RUnlock()
+		seen = v
+		if locked, v := rw.RLockIfNewerThan(seen); locked || v != seen {
+			t.Fatalf("RLockIfNewerThan(%d) = %v, %d with no write since, want false, %d", seen, locked, v, seen)
+		}
+	}
+	if !rw.TryLock() {
+		t.Fatalf("lock still held: %v", &rw)
+	}
+}
@@ -0,0 +1,67 @@
+package lock
+
+import "runtime"
+
+// ShardedRW is a read/write lock for read-mostly data, also known as
+// a big-reader lock. It spreads readers over several RW shards, so that
+// readers on different shards never touch the same cache line, while a
+// writer must lock every shard. Reads therefore scale with the number
+// of shards, and writes become proportionally more expensive.
+//
+// A reader takes the shard of the P, the runtime's processor, running
+// its goroutine, so that with one shard per P, as NewShardedRW makes by
+// default, readers running at the same time never share a shard, and a
+// goroutine keeps reading through a cache line its processor already
+// holds, which on NUMA machines is also usually on its node. Fewer
+// shards are shared by Ps in turn. Builds tagged lockportable cannot
+// ask the runtime for the P and hash the address of the goroutine's
+// stack instead, which spreads concurrent readers at random.
+//
+// RLock returns the shard it locked, which must be passed to RUnlock.
+// A ShardedRW must be created with NewShardedRW and must not be copied.
+type ShardedRW struct {
+	shards []PaddedRW
+}
+
+// NewShardedRW returns a ShardedRW with n shards, or GOMAXPROCS
+// shards if n <= 0.
+func NewShardedRW(n int) *ShardedRW {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	return &ShardedRW{shards: make([]PaddedRW, n)}
+}
+
+// RLock locks a shard of s for reading and returns it.
+func (s *ShardedRW) RLock() (shard int) {
+	shard = s.pick()
+	s.shards[shard].RLock()
+	return shard
+}
+
+// RUnlock unlocks the shard returned by RLock.
+func (s *ShardedRW) RUnlock(shard int) {
+	s.shards[shard].RUnlock()
+}
+
+// Lock locks s for writing by locking every shard.
+func (s *ShardedRW) Lock() {
+	// Every writer locks the shards in the same order, so two
+	// writers cannot deadlock holding half of them each.
+	for i := range s.shards {
+		s.shards[i].Lock()
+	}
+}
+
+// Unlock unlocks s for writing.
+func (s *ShardedRW) Unlock() {
+	for i := range s.shards {
+		s.shards[i].Unlock()
+	}
+}
+
+// pick chooses a shard for the calling goroutine: the one indexed by
+// procHint, which tells apart goroutines running at the same time.
+func (s *ShardedRW) pick() int {
+	return int(procHint() % uint64(len(s.shards)))
+}
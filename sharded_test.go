@@ -0,0 +1,102 @@
+package lock_test
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestShardedRW(t *testing.T) {
+	s := NewShardedRW(4)
+	shard := s.RLock()
+	other := s.RLock()
+	s.RUnlock(other)
+	locked := make(chan bool)
+	go func() {
+		s.Lock()
+		s.Unlock()
+		locked <- true
+	}()
+	s.RUnlock(shard)
+	<-locked
+	s.RUnlock(s.RLock())
+}
+
+func TestShardedRWHammer(t *testing.T) {
+	var (
+		s        = NewShardedRW(0)
+		activity int32
+		done     = make(chan bool)
+	)
+	for i := 0; i < 10; i++ {
+		go func(write bool) {
+			for j := 0; j < 500; j++ {
+				if write {
+					s.Lock()
+					if n := atomic.AddInt32(&activity, 10000); n != 10000 {
+						panic("writer not exclusive")
+					}
+					atomic.AddInt32(&activity, -10000)
+					s.Unlock()
+				} else {
+					shard := s.RLock()
+					if n := atomic.AddInt32(&activity, 1); n < 1 || n >= 10000 {
+						panic("reader overlapped a writer")
+					}
+					atomic.AddInt32(&activity, -1)
+					s.RUnlock(shard)
+				}
+			}
+			done <- true
+		}(i%5 == 0)
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+}
+
+func BenchmarkShardedRWRead(b *testing.B) {
+	s := NewShardedRW(0)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.RUnlock(s.RLock())
+		}
+	})
+}
+
+// BenchmarkShardedRWReadOversubscribed reads with several goroutines
+// per P, where readers on the same P share its shard, and with fewer
+// shards than Ps. On machines with many cores, compare against a
+// lockportable build, which picks shards by a hash of the stack.
+func BenchmarkShardedRWReadOversubscribed(b *testing.B) {
+	for _, bm := range []struct {
+		name          string
+		shards, procs int
+	}{
+		{"PerP/1", 0, 1},
+		{"PerP/8", 0, 8},
+		{"Quarter/8", (runtime.GOMAXPROCS(0) + 3) / 4, 8},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			s := NewShardedRW(bm.shards)
+			b.SetParallelism(bm.procs)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					s.RUnlock(s.RLock())
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkRWRead(b *testing.B) {
+	var rw RW
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rw.RLock()
+			rw.RUnlock()
+		}
+	})
+}
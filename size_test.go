@@ -0,0 +1,20 @@
+package lock_test
+
+import (
+	"testing"
+	"unsafe"
+
+	. "github.com/as/lock"
+)
+
+func TestSize(t *testing.T) {
+	if a := unsafe.Alignof(RW{}); a != 8 {
+		t.Errorf("Alignof(RW{}) = %d, want 8 so that its 64-bit words are aligned", a)
+	}
+	if s := unsafe.Sizeof(RW{}); s > 128 || s%8 != 0 {
+		t.Errorf("Sizeof(RW{}) = %d, want a multiple of 8 up to a cache line of 128", s)
+	}
+	if s := unsafe.Sizeof(PaddedRW{}); s != 128 {
+		t.Errorf("Sizeof(PaddedRW{}) = %d, want 128", s)
+	}
+}
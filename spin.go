@@ -0,0 +1,179 @@
+package lock
+
+import (
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+// SpinBudget is the number of failed attempts a goroutine waiting for
+// an RW makes, pausing briefly between each, before it blocks until
+// the lock is released. Acquisitions that may give up, such as
+// LockContext, cannot block and instead yield their processor with
+// runtime.Gosched after every further attempt. Either way, a preempted
+// or descheduled holder gets to run, which pure spinning cannot
+// guarantee when goroutines outnumber processors (GOMAXPROCS=1 in the
+// extreme). SpinBudget must not be changed while any RW is in use.
+// On single-threaded platforms (js and wasip1), where spinning cannot
+// help, waiters never spin and SpinBudget is ignored.
+var SpinBudget = 30
+
+// spinCycles is the number of spin-wait hints executed between
+// failed attempts to acquire the lock. The hint (PAUSE on amd64,
+// ISB on arm64, see spinWait) eases pressure on the contended cache
+// line and on a sibling hyperthread.
+const spinCycles = 30
+
+// maxPause is the most spin-wait hints that WithBackoff or WithJitter
+// add to a pause, well below where their sum could wrap the uint32 that
+// spinWait takes, or reach 0 and make it loop 1<<32 times.
+const maxPause = 1 << 16
+
+// pollSpins is the number of spins between checks of a spinner's
+// stop condition, keeping the spin loop itself cheap.
+const pollSpins = 4096
+
+// yieldSpins is the interval, in spins, at which a waiter that is
+// still spinning, within a large spin budget or WithYield(false), yields
+// its processor with runtime.Gosched once. Asynchronous preemption
+// would stop a spinner eventually, but only after about 10ms, while a
+// holder it displaced waits to finish a critical section of perhaps a
+// microsecond. At spinCycles hints a spin, 64 spins come to tens of
+// microseconds, against which a Gosched that finds no other goroutine
+// to run costs well under a percent; it is also well above the default
+// SpinBudget, which it therefore leaves as it is.
+const yieldSpins = 64
+
+// spinner paces a goroutine between failed attempts to acquire an RW
+// configured by cfg, which is nil for the package defaults, and
+// decides when a bounded or cancellable acquisition gives up.
+type spinner struct {
+	cfg   *config
+	n     int    // spins so far
+	pause uint32 // last backoff pause, see WithBackoff
+	rng   uint64 // xorshift state for WithJitter, or 0 before first use
+
+	// If bounded, the acquisition gives up after limit spins.
+	bounded bool
+	limit   int
+
+	// If stop is not nil, it is polled on the first spin and every
+	// pollSpins spins after that, and the acquisition gives up once
+	// it reports true.
+	stop func() bool
+
+	// If block, the acquisition parks without spinning first,
+	// whatever cfg says.
+	block bool
+
+	start time.Time // first spin, if cfg has an observer
+}
+
+// spin waits before the next attempt to acquire the lock, a spin-wait
+// hint while within the spin budget, except every yieldSpins spins, and
+// a yield to the scheduler afterwards. It reports false instead if the
+// acquisition should give up.
+func (s *spinner) spin() bool {
+	if s.bounded && s.n >= s.limit {
+		return false
+	}
+	if s.stop != nil && s.n%pollSpins == 0 && s.stop() {
+		return false
+	}
+	s.begin()
+	if s.cfg != nil && s.cfg.spinHook != nil {
+		s.cfg.spinHook()
+	}
+	if budget, yield := s.settings(); (s.n < budget || !yield) && s.n%yieldSpins != yieldSpins-1 {
+		spinWait(s.backoff())
+	} else {
+		s.yield()
+	}
+	s.n++
+	return true
+}
+
+// yield lets another goroutine run, by WithYieldFunc's function if cfg
+// has one.
+func (s *spinner) yield() {
+	if s.cfg != nil && s.cfg.yieldFunc != nil {
+		s.cfg.yieldFunc()
+		return
+	}
+	runtime.Gosched()
+}
+
+// backoff returns the number of spin-wait hints to execute before the
+// next attempt, always from 1 to 2*maxPause.
+func (s *spinner) backoff() uint32 {
+	if s.cfg == nil {
+		return spinCycles
+	}
+	d := uint32(spinCycles)
+	if lo, hi := s.cfg.backoff[0], s.cfg.backoff[1]; hi != 0 {
+		switch {
+		case s.pause == 0:
+			s.pause = lo
+		case s.pause < hi/2:
+			s.pause *= 2
+		default:
+			s.pause = hi
+		}
+		d = s.pause
+	}
+	if s.cfg.jitter != 0 {
+		d += s.jitter(s.cfg.jitter)
+	}
+	return d
+}
+
+// jitter returns a pseudo-random number of hints from 0 to max. The
+// generator is seeded on first use from the address of s, which is on
+// the waiting goroutine's stack, so that goroutines released at once
+// draw different pauses without sharing any state.
+func (s *spinner) jitter(max uint32) uint32 {
+	x := s.rng
+	if x == 0 {
+		x = uint64(uintptr(unsafe.Pointer(s)))*0x9e3779b97f4a7c15 | 1
+	}
+	x ^= x << 13
+	x ^= x >> 7
+	x ^= x << 17
+	s.rng = x
+	return uint32(x % (uint64(max) + 1))
+}
+
+// begin records the start of the wait on its first spin or park, if
+// cfg has an observer.
+func (s *spinner) begin() {
+	if s.n == 0 && s.cfg != nil && s.cfg.observer != nil {
+		s.start = time.Now()
+	}
+}
+
+// parks reports whether the acquisition should block until the lock
+// is released rather than spin again: once the spin budget is spent,
+// if yielding is allowed and the acquisition cannot give up.
+func (s *spinner) parks() bool {
+	budget, yield := s.settings()
+	return s.n >= budget && yield && !s.bounded && s.stop == nil
+}
+
+// settings returns the spin budget and whether to yield.
+func (s *spinner) settings() (budget int, yield bool) {
+	if singleThreaded || s.block {
+		return 0, true
+	}
+	if s.cfg != nil {
+		return s.cfg.spinBudget, s.cfg.yield
+	}
+	return SpinBudget, true
+}
+
+// acquired reports an acquisition in mode m that was paced by s to the
+// contention observer, if it spun and there is one.
+func (s *spinner) acquired(m Mode) {
+	if s.n > 0 && s.cfg != nil && s.cfg.observer != nil {
+		s.cfg.observer(m, s.n, time.Since(s.start))
+	}
+}
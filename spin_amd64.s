@@ -0,0 +1,14 @@
+#include "textflag.h"
+
+// PAUSE tells the processor that this is a spin-wait loop, so that it
+// does not speculate ahead on the lock's cache line and yields the
+// core's resources to a sibling hyperthread meanwhile.
+
+// func spinWait(cycles uint32)
+TEXT ·spinWait(SB),NOSPLIT,$0-4
+	MOVL	cycles+0(FP), AX
+again:
+	PAUSE
+	SUBL	$1, AX
+	JNZ	again
+	RET
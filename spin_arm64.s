@@ -0,0 +1,18 @@
+#include "textflag.h"
+
+// YIELD retires at once on most arm64 cores, so a loop of them spins
+// little longer than an empty loop. ISB instead waits for the pipeline
+// to drain, which delays about as long as PAUSE does on amd64 and
+// keeps the number of attempts on the lock's cache line comparable.
+// WFE would sleep until that line is written, but only after arming
+// the exclusive monitor on it, which the callers cannot do for every
+// condition they wait on.
+
+// func spinWait(cycles uint32)
+TEXT ·spinWait(SB),NOSPLIT,$0-4
+	MOVWU	cycles+0(FP), R0
+again:
+	ISB	$15
+	SUBW	$1, R0
+	CBNZ	R0, again
+	RET
@@ -0,0 +1,9 @@
+//go:build amd64 || arm64
+
+package lock
+
+// spinWait executes the architecture's spin-wait hint cycles times.
+// cycles must be positive.
+//
+//go:noescape
+func spinWait(cycles uint32)
@@ -0,0 +1,10 @@
+//go:build !amd64 && !arm64
+
+package lock
+
+// spinWait busy-waits for roughly cycles iterations on architectures
+// without a dedicated spin-wait hint.
+func spinWait(cycles uint32) {
+	for i := uint32(0); i < cycles; i++ {
+	}
+}
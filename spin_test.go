@@ -0,0 +1,100 @@
+package lock_test
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+// BenchmarkSpinWait measures the wait between two failed attempts on
+// a lock that stays held, for pauses of increasing numbers of the
+// architecture's spin-wait hint, and reports the time per hint.
+func BenchmarkSpinWait(b *testing.B) {
+	for _, hints := range []int{1, 30, 256} {
+		b.Run(fmt.Sprint(hints), func(b *testing.B) {
+			rw := New(WithBackoff(hints, hints))
+			// Upgrade needs the caller's to be the only read lock,
+			// so every TryUpgrade spins once and fails.
+			rw.RLock()
+			rw.RLock()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				if rw.TryUpgrade(1) {
+					b.Fatalf("TryUpgrade succeeded with another reader in")
+				}
+			}
+			elapsed := time.Since(start)
+			b.ReportMetric(float64(elapsed.Nanoseconds())/float64(b.N*hints), "ns/hint")
+			rw.RUnlock()
+			rw.RUnlock()
+		})
+	}
+}
+
+// TestSpinOversubscribed runs more goroutines than processors against
+// locks whose waiters spin for a long time, or for ever, while holders
+// stay in for a while, and fails if a goroutine waits beyond a bound to
+// acquire the lock.
+func TestSpinOversubscribed(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	const n, loops, bound = 8, 100, 2 * time.Second
+	for name, rw := range map[string]*RW{
+		"default":                  new(RW),
+		"WithSpinBudget":           New(WithSpinBudget(1 << 20)),
+		"WithYield":                New(WithYield(false)),
+		"WithYield/WithSpinBudget": New(WithSpinBudget(1<<20), WithYield(false)),
+	} {
+		var (
+			mu     sync.Mutex
+			worst  time.Duration
+			shared int
+			wg     sync.WaitGroup
+		)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var longest time.Duration
+				for j := 0; j < loops; j++ {
+					write := j%4 == 0
+					start := time.Now()
+					if write {
+						rw.Lock()
+					} else {
+						rw.RLock()
+					}
+					acquired := time.Now()
+					if d := acquired.Sub(start); d > longest {
+						longest = d
+					}
+					// Hold the lock long enough for waiters to
+					// spin their way through yieldSpins.
+					for time.Since(acquired) < 20*time.Microsecond {
+						if write {
+							shared++
+						}
+					}
+					if write {
+						rw.Unlock()
+					} else {
+						rw.RUnlock()
+					}
+				}
+				mu.Lock()
+				if longest > worst {
+					worst = longest
+				}
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		if worst > bound {
+			t.Errorf("%s: a goroutine waited %v for the lock, want under %v", name, worst, bound)
+		}
+		t.Logf("%s: longest wait %v", name, worst)
+	}
+}
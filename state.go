@@ -0,0 +1,228 @@
+package lock
+
+import (
+	"strconv"
+	"sync/atomic"
+	"unsafe"
+)
+
+// IsWriteLocked reports whether a writer held rw at the moment of the
+// call. The result is a snapshot that may be stale by the time it is
+// returned. It is intended for assertions and diagnostics and must
+// never be used to decide whether to lock or unlock rw.
+func (rw *RW) IsWriteLocked() bool {
+	return rw.state.Load()&1 != 0
+}
+
+// ReaderCount returns the number of readers of rw at the moment of
+// the call. A writer that downgraded counts as one reader, and so
+// does a reader that has announced itself but is still waiting for
+// the current writer to release. Like IsWriteLocked, the result is
+// an advisory snapshot.
+func (rw *RW) ReaderCount() int {
+	return int(readersOf(rw.state.Load()))
+}
+
+// HasPendingWriters reports whether a writer was waiting for rw at
+// the moment of the call, so that readers can choose to back off. Like
+// IsWriteLocked, the result is an advisory snapshot. A writer that
+// holds rw is not pending, nor is a reader waiting to upgrade.
+func (rw *RW) HasPendingWriters() bool {
+	return atomic.LoadInt32(&rw.writers) != 0
+}
+
+// LoadState returns the raw state word of rw, for building higher-level
+// primitives and tooling on RW. Bit 0 is set while a writer holds rw,
+// bit 62 once Close has closed it, and the bits between them count
+// readers as ReaderCount does. Like IsWriteLocked, the result is an
+// advisory snapshot, and loading it is always safe; ReaderCount,
+// IsWriteLocked, IsClosed and Stats decode it.
+func (rw *RW) LoadState() uint64 {
+	return uint64(rw.state.Load())
+}
+
+// LoadAcquire loads the state word of rw, as LoadState does, with the
+// ordering of an acquisition: everything done by the holders that had
+// released rw by then, and before every StoreRelease of rw that came
+// first, is synchronized before the code after it. It lets lock-free
+// code handed data by a holder of rw, such as a pointer read under the
+// read lock, see what the writers of that data did without locking rw
+// itself. It takes no lock, and the result is an advisory snapshot.
+func (rw *RW) LoadAcquire() uint64 {
+	if raceEnabled {
+		raceDisable()
+	}
+	v := rw.state.Load()
+	if raceEnabled {
+		raceEnable()
+		rw.raceAcquireWrite()
+	}
+	return uint64(v)
+}
+
+// StoreRelease makes everything done before it synchronized before
+// every later acquisition of rw, of either half, and every LoadAcquire
+// that follows it, as the release of a lock would, for lock-free code
+// that hands data back to the holders of rw. It writes rw's state with
+// an atomic operation that changes none of it, so it may be called at
+// any time, and never waits.
+func (rw *RW) StoreRelease() {
+	if raceEnabled {
+		raceReleaseMerge(unsafe.Pointer(&rw.state))
+		raceDisable()
+	}
+	rw.state.Add(0)
+	if raceEnabled {
+		raceEnable()
+	}
+}
+
+// UnsafeStoreState overwrites the raw state word of rw with v, in the
+// encoding of LoadState. It is for tests and recovery tooling in fully
+// controlled scenarios, such as restoring a state saved from an RW known
+// to be idle, and nothing else.
+//
+// UnsafeStoreState bypasses every rule rw enforces. Storing while any
+// goroutine holds rw, waits for it or may acquire it concurrently breaks
+// mutual exclusion, loses or invents lock holders, and can leave parked
+// waiters asleep for good, since nobody is woken. A stored state must
+// also match the holders that will release it: every reader counted in
+// v needs one RUnlock, and a write lock needs one Unlock or Downgrade,
+// which lockdebug builds only accept from the goroutine that stored it.
+// The sequence number is made odd for a stored write lock and even
+// otherwise, as Seq expects, but not otherwise changed.
+func (rw *RW) UnsafeStoreState(v uint64) {
+	if rw.seq.Load()&1 != v&1 {
+		rw.seq.Add(1)
+	}
+	if v&1 != 0 {
+		rw.dbg.locked()
+	}
+	rw.state.Store(int64(v))
+}
+
+// Stats is a snapshot of the state of an RW, as returned by RW.Stats.
+type Stats struct {
+	Readers        int  // as reported by ReaderCount
+	WriteHeld      bool // as reported by IsWriteLocked
+	WaitingWriters int  // writers waiting for the lock
+}
+
+// Stats returns a snapshot of the state of rw for metrics and
+// diagnostics. Readers and WriteHeld are read together and describe
+// the same instant, which separate calls to ReaderCount and
+// IsWriteLocked cannot promise. WaitingWriters is read just after, so
+// it is only approximately consistent with them. Like IsWriteLocked,
+// the result is advisory.
+func (rw *RW) Stats() Stats {
+	v := rw.state.Load()
+	return Stats{
+		Readers:        int(readersOf(v)),
+		WriteHeld:      v&1 != 0,
+		WaitingWriters: int(atomic.LoadInt32(&rw.writers)),
+	}
+}
+
+// String describes the state of rw at the moment of the call, as
+// "RW{idle}", "RW{readers: 3}" or "RW{write-locked}". Readers waiting
+// for the writer are included, as in "RW{write-locked, readers: 2}".
+// An RW closed by Close is described as "RW{closed}", or as in
+// "RW{closed, readers: 1}" while it is still held. An RW named by
+// WithName is described with its name, as in `RW("cache"){idle}`.
+// Like IsWriteLocked, the result is an advisory snapshot.
+func (rw *RW) String() string {
+	return string(appendState(rw.appendName(make([]byte, 0, 32)), rw.state.Load()))
+}
+
+// Name returns the name rw was given by WithName, or "" if it has none.
+func (rw *RW) Name() string {
+	if rw.cfg == nil {
+		return ""
+	}
+	return rw.cfg.name
+}
+
+// appendName appends "RW" and rw's name, if it has one, to b, as
+// String and the panics of misuse refer to rw.
+func (rw *RW) appendName(b []byte) []byte {
+	b = append(b, "RW"...)
+	if name := rw.Name(); name != "" {
+		b = append(b, '(')
+		b = strconv.AppendQuote(b, name)
+		b = append(b, ')')
+	}
+	return b
+}
+
+// named returns "RW" and rw's name, if it has one, for panic messages.
+func (rw *RW) named() string {
+	return string(rw.appendName(nil))
+}
+
+// appendState appends the description of state used by String to b.
+func appendState(b []byte, state int64) []byte {
+	b = append(b, '{')
+	if state&closedBit != 0 {
+		b = append(b, "closed"...)
+		if state &^= closedBit; state == 0 {
+			return append(b, '}')
+		}
+		b = append(b, ", "...)
+	}
+	readers := readersOf(state)
+	switch {
+	case state&1 != 0:
+		b = append(b, "write-locked"...)
+		if readers != 0 {
+			b = append(b, ", readers: "...)
+			b = strconv.AppendInt(b, readers, 10)
+		}
+	case readers != 0:
+		b = append(b, "readers: "...)
+		b = strconv.AppendInt(b, readers, 10)
+	default:
+		b = append(b, "idle"...)
+	}
+	return append(b, '}')
+}
+
+// MarshalText implements encoding.TextMarshaler for diagnostics dumps.
+// It describes the state of rw as "idle", "write-locked" or
+// "readers=2", and readers waiting for the writer as in
+// "write-locked,readers=2", and an RW closed by Close as in "closed"
+// or "closed,readers=1". Like String, the result is an advisory
+// snapshot, and there is no UnmarshalText: the state of a lock is
+// not something to persist.
+func (rw *RW) MarshalText() ([]byte, error) {
+	return appendText(nil, rw.state.Load()), nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding the description of
+// MarshalText as a JSON string.
+func (rw *RW) MarshalJSON() ([]byte, error) {
+	b := appendText([]byte{'"'}, rw.state.Load())
+	return append(b, '"'), nil
+}
+
+// appendText appends the description of state used by MarshalText.
+func appendText(b []byte, state int64) []byte {
+	if state&closedBit != 0 {
+		b = append(b, "closed"...)
+		if state &^= closedBit; state == 0 {
+			return b
+		}
+		b = append(b, ',')
+	}
+	readers := readersOf(state)
+	if state&1 != 0 {
+		b = append(b, "write-locked"...)
+		if readers == 0 {
+			return b
+		}
+		b = append(b, ',')
+	} else if readers == 0 {
+		return append(b, "idle"...)
+	}
+	b = append(b, "readers="...)
+	return strconv.AppendInt(b, readers, 10)
+}
@@ -0,0 +1,57 @@
+package lock
+
+import "hash/maphash"
+
+// defaultStripes is the number of stripes NewStriped uses by default.
+const defaultStripes = 64
+
+// Striped locks resources identified by string keys without keeping
+// a lock per key. It hashes each key to one of a fixed set of RW
+// stripes, so operations on the same key always serialize, while
+// operations on different keys usually proceed concurrently. Keys
+// whose hashes collide share a stripe and contend as if they were the
+// same key: more stripes make that rarer, at the cost of memory.
+//
+// Locking two keys at once deadlocks if they share a stripe, and can
+// deadlock with another goroutine locking them in the opposite order.
+// Use Stripe to lock each distinct stripe once, in increasing order.
+//
+// A Striped must be created with NewStriped and must not be copied.
+type Striped struct {
+	seed    maphash.Seed
+	stripes []PaddedRW
+}
+
+// NewStriped returns a Striped with n stripes, or 64 if n <= 0.
+func NewStriped(n int) *Striped {
+	if n <= 0 {
+		n = defaultStripes
+	}
+	return &Striped{seed: maphash.MakeSeed(), stripes: make([]PaddedRW, n)}
+}
+
+// Stripe returns the index of the stripe that guards key, between 0
+// and the number of stripes. It is fixed for the lifetime of s.
+func (s *Striped) Stripe(key string) int {
+	return int(maphash.String(s.seed, key) % uint64(len(s.stripes)))
+}
+
+// Lock locks key for writing.
+func (s *Striped) Lock(key string) {
+	s.stripes[s.Stripe(key)].Lock()
+}
+
+// Unlock unlocks key for writing.
+func (s *Striped) Unlock(key string) {
+	s.stripes[s.Stripe(key)].Unlock()
+}
+
+// RLock locks key for reading.
+func (s *Striped) RLock(key string) {
+	s.stripes[s.Stripe(key)].RLock()
+}
+
+// RUnlock unlocks key for reading.
+func (s *Striped) RUnlock(key string) {
+	s.stripes[s.Stripe(key)].RUnlock()
+}
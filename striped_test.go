@@ -0,0 +1,58 @@
+package lock_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestStriped(t *testing.T) {
+	s := NewStriped(8)
+	a, b := "a", ""
+	for i := 0; b == ""; i++ {
+		if k := strconv.Itoa(i); s.Stripe(k) != s.Stripe(a) {
+			b = k
+		}
+	}
+	s.Lock(a)
+	locked := make(chan bool)
+	go func() {
+		s.Lock(b)
+		s.Unlock(b)
+		locked <- true
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("keys on different stripes serialized")
+	}
+
+	go func() {
+		s.RLock(a)
+		locked <- true
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("RLock of a write-locked key succeeded")
+	case <-time.After(10 * time.Millisecond):
+	}
+	s.Unlock(a)
+	<-locked
+	s.RUnlock(a)
+}
+
+func TestStripedStripe(t *testing.T) {
+	s := NewStriped(0)
+	for i := 0; i < 1000; i++ {
+		k := strconv.Itoa(i)
+		n := s.Stripe(k)
+		if n < 0 || n >= 64 {
+			t.Fatalf("Stripe(%q) = %d, out of range for the default 64 stripes", k, n)
+		}
+		if s.Stripe(k) != n {
+			t.Fatalf("Stripe(%q) not stable", k)
+		}
+	}
+}
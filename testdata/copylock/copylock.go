@@ -0,0 +1,20 @@
+// Package copylock copies an RW by value, which go vet must report.
+package copylock
+
+import "github.com/as/lock"
+
+type counter struct {
+	rw lock.RW
+	n  int
+}
+
+// snapshot receives c by value, copying its lock.
+func snapshot(c counter) int {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+	return c.n
+}
+
+func use(c *counter) int {
+	return snapshot(*c)
+}
@@ -0,0 +1,49 @@
+package lock
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLockTimeout is returned by LockDeadline when the deadline passes
+// before the lock is acquired.
+var ErrLockTimeout = errors.New("lock: deadline passed before the lock was acquired")
+
+// TryLockTimeout locks rw, spinning for at most d, and reports whether
+// the lock was acquired. If d <= 0, it makes a single attempt like
+// TryLock. The clock is only read every pollSpins failed attempts.
+func (rw *RW) TryLockTimeout(d time.Duration) bool {
+	if d <= 0 {
+		return rw.TryLock()
+	}
+	deadline := time.Now().Add(d)
+	s := spinner{cfg: rw.cfg, stop: func() bool { return !time.Now().Before(deadline) }}
+	return rw.lock(&s)
+}
+
+// TryRLockTimeout locks rw for reading, spinning for at most d while a
+// writer holds rw, and reports whether the read lock was acquired. On
+// failure it leaves no trace on rw: the reader it added to rw while
+// waiting has been removed again. If d <= 0, it makes a single attempt
+// like TryRLock.
+func (rw *RW) TryRLockTimeout(d time.Duration) bool {
+	if d <= 0 {
+		return rw.TryRLock()
+	}
+	deadline := time.Now().Add(d)
+	s := spinner{cfg: rw.cfg, stop: func() bool { return !time.Now().Before(deadline) }}
+	return rw.rlock(&s, 1)
+}
+
+// LockDeadline locks rw, spinning until the lock is available or the
+// time t, and returns nil if the lock was acquired or ErrLockTimeout if
+// t came first, in which case the caller holds nothing. It suits a
+// deadline threaded through many calls, as TryLockTimeout suits a
+// duration. If t has already passed, it makes a single attempt like
+// TryLock.
+func (rw *RW) LockDeadline(t time.Time) error {
+	if !rw.TryLockTimeout(time.Until(t)) {
+		return rw.closedErr(ErrLockTimeout)
+	}
+	return nil
+}
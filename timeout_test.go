@@ -0,0 +1,97 @@
+package lock_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/as/lock"
+)
+
+func TestTryLockTimeout(t *testing.T) {
+	var rw RW
+	if !rw.TryLockTimeout(time.Second) {
+		t.Fatalf("TryLockTimeout failed on idle lock")
+	}
+	start := time.Now()
+	if rw.TryLockTimeout(10 * time.Millisecond) {
+		t.Fatalf("TryLockTimeout succeeded on held lock")
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Fatalf("TryLockTimeout gave up after %v, want at least 10ms", elapsed)
+	}
+	if rw.TryLockTimeout(0) {
+		t.Fatalf("TryLockTimeout(0) succeeded on held lock")
+	}
+	rw.Unlock()
+	if !rw.TryLockTimeout(-1) {
+		t.Fatalf("TryLockTimeout(-1) failed on idle lock")
+	}
+	rw.Unlock()
+}
+
+func TestTryLockTimeoutHandoff(t *testing.T) {
+	var rw RW
+	locked := make(chan bool)
+	go func() {
+		rw.Lock()
+		locked <- true
+		time.Sleep(time.Millisecond)
+		rw.Unlock()
+	}()
+	<-locked
+	if !rw.TryLockTimeout(10 * time.Second) {
+		t.Fatalf("TryLockTimeout did not acquire a released lock")
+	}
+	rw.Unlock()
+}
+
+func TestTryRLockTimeout(t *testing.T) {
+	var rw RW
+	if !rw.TryRLockTimeout(0) {
+		t.Fatalf("TryRLockTimeout(0) failed on idle lock")
+	}
+	rw.RUnlock()
+	rw.Lock()
+	start := time.Now()
+	if rw.TryRLockTimeout(10 * time.Millisecond) {
+		t.Fatalf("TryRLockTimeout succeeded while write-locked")
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatalf("TryRLockTimeout gave up early")
+	}
+	if rw.TryRLockTimeout(-1) {
+		t.Fatalf("TryRLockTimeout(-1) succeeded while write-locked")
+	}
+	if s := rw.Stats(); s != (Stats{WriteHeld: true}) {
+		t.Fatalf("timed-out readers left a trace: %+v", s)
+	}
+	rw.Unlock()
+	if !rw.TryLock() {
+		t.Fatalf("lock not idle after timed-out readers")
+	}
+	rw.Unlock()
+}
+
+func TestLockDeadline(t *testing.T) {
+	var rw RW
+	if err := rw.LockDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("LockDeadline on idle lock: %v", err)
+	}
+	start := time.Now()
+	err := rw.LockDeadline(start.Add(10 * time.Millisecond))
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("LockDeadline on held lock: got %v, want ErrLockTimeout", err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatalf("LockDeadline gave up before the deadline")
+	}
+	if err := rw.LockDeadline(time.Time{}); err != ErrLockTimeout {
+		t.Fatalf("LockDeadline with a past deadline on held lock: got %v", err)
+	}
+	rw.Unlock()
+	if err := rw.LockDeadline(time.Time{}); err != nil {
+		t.Fatalf("LockDeadline with a past deadline on idle lock: %v", err)
+	}
+	rw.Unlock()
+}
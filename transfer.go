@@ -0,0 +1,25 @@
+package lock
+
+// TransferRead records that the calling goroutine has taken over a read
+// lock of rw from the goroutine that acquired it, which handed it over
+// without releasing it, as a work-stealing scheduler might hand off a
+// task along with the read lock it runs under. Releasing and
+// reacquiring the lock instead could let a writer in between.
+//
+// Read locks belong to no goroutine, so the reader that received one
+// may release it whether or not it calls TransferRead, and TransferRead
+// does nothing to rw itself. Only in lockdebug builds does it do
+// anything at all: it moves the read lock, in the bookkeeping of the
+// lock order checks, from another goroutine holding rw to the calling
+// one, and panics if no other goroutine holds it. Otherwise those
+// checks would go on judging the giver's acquisitions as made under rw
+// and never the receiver's.
+//
+// The hand-off itself, such as a send on a channel, must happen before
+// TransferRead is called:
+//
+//	rw.RLock()
+//	work <- task // the receiver calls rw.TransferRead, then rw.RUnlock
+func (rw *RW) TransferRead() {
+	rw.dbg.transfer()
+}
@@ -0,0 +1,32 @@
+package lock_test
+
+import (
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestTransferRead(t *testing.T) {
+	var rw RW
+	rw.RLock()
+	handoff := make(chan *RW)
+	done := make(chan bool)
+	go func() {
+		held := <-handoff
+		held.TransferRead()
+		if n := held.ReaderCount(); n != 1 {
+			t.Errorf("TransferRead changed the reader count to %d", n)
+		}
+		if held.TryLock() {
+			t.Errorf("writer got in during the hand-off")
+		}
+		held.RUnlock()
+		done <- true
+	}()
+	handoff <- &rw
+	<-done
+	if !rw.TryLock() {
+		t.Fatalf("read lock not released by its receiver: %v", &rw)
+	}
+	rw.Unlock()
+}
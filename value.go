@@ -0,0 +1,106 @@
+package lock
+
+import "reflect"
+
+// Value holds a value of type T that is loaded and stored whole, like
+// atomic.Value but for any T, including one that varies in dynamic
+// type, and with loads that only take the read lock. For values too
+// large to copy on every access, or updated in place, use Guarded.
+//
+// The zero value is a Value holding the zero T. A Value must not be
+// copied after first use.
+type Value[T any] struct {
+	rw RW
+	v  T
+}
+
+// NewValue returns a Value holding v.
+func NewValue[T any](v T) *Value[T] {
+	return &Value[T]{v: v}
+}
+
+// Load returns a copy of the value. As with Guarded.Read, the copy
+// may share memory with the stored value.
+func (x *Value[T]) Load() T {
+	x.rw.RLock()
+	v := x.v
+	x.rw.RUnlock()
+	return v
+}
+
+// Store replaces the value with v.
+func (x *Value[T]) Store(v T) {
+	x.rw.Lock()
+	x.v = v
+	x.rw.Unlock()
+}
+
+// Swap replaces the value with new and returns the value it replaced.
+func (x *Value[T]) Swap(new T) (old T) {
+	x.rw.Lock()
+	old, x.v = x.v, new
+	x.rw.Unlock()
+	return old
+}
+
+// CompareAndSwap replaces the value with new if it is deeply equal to
+// old, as reported by reflect.DeepEqual, and reports whether it did.
+func (x *Value[T]) CompareAndSwap(old, new T) bool {
+	return x.CompareAndSwapFunc(old, new, func(a, b T) bool {
+		return reflect.DeepEqual(a, b)
+	})
+}
+
+// CompareAndSwapFunc is like CompareAndSwap, but compares the value
+// with old using equal, which is called under the write lock and must
+// not use x.
+func (x *Value[T]) CompareAndSwapFunc(old, new T, equal func(a, b T) bool) bool {
+	x.rw.Lock()
+	defer x.rw.Unlock()
+	if !equal(x.v, old) {
+		return false
+	}
+	x.v = new
+	return true
+}
+
+// PtrValue holds a pointer to a T, for the common case of a lock
+// protecting a single pointer that is replaced whole. Load takes the
+// read lock and Store the write lock, so the RW accounts for every
+// access, and StoreDowngrade stores and then reads under the same hold.
+// It is lighter than a Value or Guarded of the pointer, needing neither
+// closures for plain loads nor copies beyond the pointer itself.
+//
+// The zero value holds nil. A PtrValue must not be copied after first
+// use.
+type PtrValue[T any] struct {
+	rw RW
+	p  *T
+}
+
+// Load returns the pointer stored.
+func (x *PtrValue[T]) Load() *T {
+	x.rw.RLock()
+	p := x.p
+	x.rw.RUnlock()
+	return p
+}
+
+// Store replaces the pointer with p.
+func (x *PtrValue[T]) Store(p *T) {
+	x.rw.Lock()
+	x.p = p
+	x.rw.Unlock()
+}
+
+// StoreDowngrade replaces the pointer with p under the write lock,
+// downgrades to a read lock, and calls read with p. No other Store can
+// run in between, so read sees the pointer it published while Loads
+// already return it. The lock is released even if read panics.
+func (x *PtrValue[T]) StoreDowngrade(p *T, read func(*T)) {
+	x.rw.Lock()
+	x.p = p
+	x.rw.Downgrade()
+	defer x.rw.RUnlock()
+	read(x.p)
+}
@@ -0,0 +1,134 @@
+package lock_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/as/lock"
+)
+
+func TestValue(t *testing.T) {
+	var v Value[[]int]
+	if got := v.Load(); got != nil {
+		t.Fatalf("zero Value: Load = %v, want nil", got)
+	}
+	v.Store([]int{1})
+	if old := v.Swap([]int{2}); len(old) != 1 || old[0] != 1 {
+		t.Fatalf("Swap returned %v, want [1]", old)
+	}
+	if v.CompareAndSwap([]int{1}, []int{3}) {
+		t.Fatalf("CompareAndSwap succeeded with a stale old value")
+	}
+	if !v.CompareAndSwap([]int{2}, []int{3}) {
+		t.Fatalf("CompareAndSwap failed with a deeply equal old value")
+	}
+	if got := v.Load(); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("Load = %v, want [3]", got)
+	}
+}
+
+func TestValueCompareAndSwapFunc(t *testing.T) {
+	v := NewValue("Hello")
+	if v.CompareAndSwapFunc("hello", "bye", func(a, b string) bool { return a == b }) {
+		t.Fatalf("CompareAndSwapFunc used the wrong comparator")
+	}
+	if !v.CompareAndSwapFunc("hello", "bye", strings.EqualFold) {
+		t.Fatalf("CompareAndSwapFunc ignored the comparator")
+	}
+	if got := v.Load(); got != "bye" {
+		t.Fatalf("Load = %q, want %q", got, "bye")
+	}
+}
+
+func TestValueConcurrent(t *testing.T) {
+	type pair struct{ a, b int }
+	v := NewValue(pair{})
+	const n, loops = 4, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < loops; j++ {
+				for {
+					old := v.Load()
+					if v.CompareAndSwap(old, pair{old.a + 1, old.b + 1}) {
+						break
+					}
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < loops; j++ {
+				if p := v.Load(); p.a != p.b {
+					t.Errorf("torn Load: %v", p)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if p := v.Load(); p.a != n*loops {
+		t.Fatalf("after %d increments: %v", n*loops, p)
+	}
+}
+
+func TestPtrValue(t *testing.T) {
+	var v PtrValue[int]
+	if p := v.Load(); p != nil {
+		t.Fatalf("zero PtrValue: Load = %v, want nil", p)
+	}
+	one := 1
+	v.Store(&one)
+	if p := v.Load(); p != &one {
+		t.Fatalf("Load = %p, want %p", p, &one)
+	}
+	two := 2
+	v.StoreDowngrade(&two, func(p *int) {
+		if p != &two || v.Load() != &two {
+			t.Fatalf("StoreDowngrade read %p, Load %p, want %p", p, v.Load(), &two)
+		}
+	})
+	if !mustPanic(func() { v.StoreDowngrade(&one, func(*int) { panic("read") }) }) {
+		t.Fatalf("StoreDowngrade swallowed a panic in read")
+	}
+	v.Store(&two)
+}
+
+func TestPtrValueConcurrent(t *testing.T) {
+	type pair struct{ a, b int }
+	var v PtrValue[pair]
+	v.Store(&pair{})
+	const n, loops = 4, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < loops; j++ {
+				p := &pair{i, i}
+				if j%2 == 0 {
+					v.Store(p)
+					continue
+				}
+				v.StoreDowngrade(p, func(got *pair) {
+					if got != p {
+						t.Errorf("StoreDowngrade read %v, want its own %v", got, p)
+					}
+				})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < loops; j++ {
+				if p := v.Load(); p == nil || p.a != p.b {
+					t.Errorf("bad Load: %v", p)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,9 @@
+//go:build js || wasip1
+
+package lock
+
+// On js and wasip1 goroutines share a single thread and are never
+// preempted, so a waiter that spins keeps the holder it waits for from
+// running, possibly forever. Waiters instead yield or park at once,
+// whatever their settings.
+const singleThreaded = true